@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+)
+
+// runDiff implements "gateway diff -conf <running> -new <candidate> [-overlay]".
+// The running side is loaded through the configured config source, so remote
+// documents (etcd, consul) are included. With -overlay the candidate is a config
+// document as stored in etcd/consul and is applied on top of the running config.
+// The exit code follows diff(1): 0 without changes, 1 with changes, 2 on errors.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	path := fs.String("conf", "config/gateway.yaml", "gateway config file path (running config)")
+	candidate := fs.String("new", "", "candidate config file")
+	overlay := fs.Bool("overlay", false, "treat -new as a config source document applied on top of the running config")
+	fs.Parse(args)
+	if *candidate == "" {
+		fmt.Fprintln(os.Stderr, "diff: -new is required")
+		return 2
+	}
+
+	running, err := loadRunning(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: failed to load %s: %v\n", *path, err)
+		return 2
+	}
+	var next *config.GatewayConfig
+	if *overlay {
+		data, err := os.ReadFile(*candidate)
+		if err == nil {
+			var o *config.ConfigOverlay
+			if o, err = config.ParseOverlay(data); err == nil {
+				next = o.Apply(running)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "diff: failed to load %s: %v\n", *candidate, err)
+			return 2
+		}
+	} else if next, err = config.LoadConfig(*candidate); err != nil {
+		fmt.Fprintf(os.Stderr, "diff: failed to load %s: %v\n", *candidate, err)
+		return 2
+	}
+
+	changes := core.Diff(running, next)
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return 0
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return 1
+}
+
+func loadRunning(path string) (*config.GatewayConfig, error) {
+	conf, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := config.NewProvider(path, conf)
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return provider.Load(ctx)
+}
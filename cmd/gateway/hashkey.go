@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"LensGateway.com/internal/consumer"
+)
+
+// runHashKey implements "gateway hash-key [key]": it prints the digest to put in
+// consumers[].keys. Without an argument the key is read from the first line of stdin,
+// which keeps it out of the shell history.
+func runHashKey(args []string) int {
+	fs := flag.NewFlagSet("hash-key", flag.ExitOnError)
+	fs.Parse(args)
+
+	key := fs.Arg(0)
+	if key == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "failed to read the key from stdin: %v\n", err)
+			return 1
+		}
+		key = strings.TrimRight(line, "\r\n")
+	}
+	if key == "" {
+		fmt.Fprintln(os.Stderr, "usage: gateway hash-key [key], or pass the key on stdin")
+		return 1
+	}
+	fmt.Println(consumer.HashKey(key))
+	return 0
+}
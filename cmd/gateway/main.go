@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
@@ -10,24 +11,36 @@ import (
 	"syscall"
 	"time"
 
+	"LensGateway.com/internal/admin"
 	"LensGateway.com/internal/config"
 	"LensGateway.com/internal/core"
 	_ "LensGateway.com/internal/logging"
-	"LensGateway.com/internal/middleware"
-	"LensGateway.com/internal/observe"
+	_ "LensGateway.com/internal/observe"
 	"github.com/common-nighthawk/go-figure"
-	"github.com/gin-gonic/gin"
 )
 
 var (
 	confPath string
+	strict   bool
 )
 
 func init() {
 	flag.StringVar(&confPath, "conf", "config/gateway.yaml", "gateway config file path")
+	flag.BoolVar(&strict, "strict", false, "refuse to start, and reject reloads, when the config fails validation")
 }
 
 func main() {
+	// subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "hash-key":
+			os.Exit(runHashKey(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
 	// Load configuration.
@@ -36,79 +49,84 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Initialize router using gin.New() for full middleware control.
-	router := gin.New()
-	// set trusted proxies if configured
-	trustedProxies := conf.Global.TrustedProxies
-	if len(trustedProxies) == 0 {
-		trustedProxies = []string{"127.0.0.1", "::1"}
+	// 生效配置 = 本地引导文件 + 配置源中的远程文档（etcd、consul 等）
+	provider, err := config.NewProvider(confPath, conf)
+	if err != nil {
+		log.Fatalf("Failed to create config source: %v", err)
 	}
-	err = router.SetTrustedProxies(trustedProxies)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	conf, err = provider.Load(ctx)
+	cancel()
 	if err != nil {
-		log.Fatalf("Failed to set trusted proxies: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// gateway health check endpoint
-	router.GET("/healthz", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
-	// Prometheus metrics endpoint
-	router.GET("/metrics", observe.MetricsHandler())
-
-	// 设置动态路由和反向代理（支持 etcd 动态上游）
-	var routerManager *core.RouterManager
-	if conf.ConfigSource.Type == "etcd" && len(conf.ConfigSource.Etcd.Endpoints) > 0 {
-		etcdCli, err := config.NewEtcdClient(conf.ConfigSource.Etcd.Endpoints)
+	if strict {
+		errs, err := config.UnknownKeys(confPath)
 		if err != nil {
-			log.Fatalf("Failed to connect etcd: %v", err)
+			log.Fatalf("Failed to validate config: %v", err)
 		}
-		// 初次拉取
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		ups, err := etcdCli.FetchUpstreams(ctx, conf.ConfigSource.Etcd.Key)
-		cancel()
-		if err != nil {
-			log.Fatalf("Failed to fetch upstreams from etcd: %v", err)
+		if errs = append(errs, core.Validate(conf)...); len(errs) > 0 {
+			log.Fatalf("Refusing to start with an invalid config (-strict): %v", config.ValidationError(errs))
 		}
-		routerManager, err = core.NewRouterManager(ups, conf.ConfigSource)
+	}
+
+	gw, err := core.NewGateway(conf)
+	if err != nil {
+		log.Fatalf("Failed to create gateway: %v", err)
+	}
+
+	// 可选：配置历史同时保存到 etcd
+	if prefix := conf.History.EtcdPrefix; prefix != "" {
+		historyCli, err := config.NewEtcdClient(conf.ConfigSource.Etcd)
 		if err != nil {
-			log.Fatalf("Failed to create router manager: %v", err)
-		}
-		// 监听变更
-		if conf.ConfigSource.Etcd.Watch {
-			go func() {
-				log.Printf("[gateway] watching etcd key %s for upstream updates", conf.ConfigSource.Etcd.Key)
-				if err := etcdCli.WatchUpstreams(context.Background(), conf.ConfigSource.Etcd.Key, func(newUps []config.UpstreamConfig) {
-					routerManager.UpdateUpstreams(newUps)
-					log.Printf("[gateway] upstreams updated: %d", len(newUps))
-				}); err != nil {
-					log.Printf("[gateway] etcd watch stopped: %v", err)
-				}
-			}()
+			log.Fatalf("Failed to connect etcd for config history: %v", err)
 		}
-	} else {
-		// config file mode
-		routerManager, err = core.NewRouterManager(conf.Upstreams, conf.ConfigSource)
+		defer historyCli.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = gw.History().Attach(ctx, historyCli.SnapshotStore(prefix, conf.History.Size))
+		cancel()
 		if err != nil {
-			log.Fatalf("Failed to create router manager: %v", err)
+			log.Fatalf("Failed to load config history from etcd: %v", err)
 		}
 	}
 
-
-	// register pre-match middleware for route prefix matching
-	router.Use(routerManager.PreMatchMiddleware())
-
-	// register other global middlewares
-	err = middleware.SetupMiddlewares(router, conf.Middlewares)
-	if err != nil {
-		log.Fatalf("Failed to setup middlewares: %v", err)
+	// 管理接口
+	var adminSrv *admin.Server
+	if conf.Admin.Enabled {
+		// 配置源支持写回时，管理接口的变更会持久化到配置源
+		writer, _ := provider.(config.UpstreamWriter)
+		adminSrv = admin.New(gw, conf.Admin, writer)
+		if err := adminSrv.Start(); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
 	}
-	// capture all routes except configured ones(e.g. /healthz)
-
-	router.NoRoute(routerManager.HandleRequest)
 
-	// Start server with graceful shutdown and hot-reload capabilities.
-	srv := &http.Server{
-		Addr:    conf.Global.ListenAddr,
-		Handler: router,
+	// 监听变更，与 SIGHUP 走同一条原子重载路径
+	source := conf.ConfigSource.Type
+	if source == "" {
+		source = "file"
 	}
+	if hc, ok := provider.(config.WatchHealthChecker); ok {
+		// gateway is not ready while the watch is reconnecting
+		gw.AddReadinessCheck("config_watch", hc.WatchHealthy)
+	}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go func() {
+		err := provider.Watch(watchCtx, func(newConf *config.GatewayConfig) {
+			// e.g. the echo of a change written back by the admin API
+			if config.ConfigHash(newConf) == gw.History().Current().Hash {
+				log.Printf("[gateway] config from %s matches the active version, nothing to reload", source)
+				return
+			}
+			// 文件监听在保存时就会触发，编辑到一半的配置只要能解析就会被应用，因此总是完整校验
+			reload(gw, newConf, source, strict || source == "file")
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("[gateway] config watch stopped: %v", err)
+		}
+	}()
 
 	// Goroutine for listening for signals (shutdown and reload).
 	go func() {
@@ -121,26 +139,25 @@ func main() {
 				log.Println("Shutdown signal received, graceful shutdown...")
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 				defer cancel()
-				if err := srv.Shutdown(ctx); err != nil {
+				stopWatch()
+				if adminSrv != nil {
+					adminSrv.Shutdown(ctx)
+				}
+				if err := gw.Shutdown(ctx); err != nil {
 					log.Fatalf("Server forced to shutdown: %v", err)
 				}
 				return
 			case syscall.SIGHUP:
 				log.Println("Reload signal (SIGHUP) received, attempting to reload configuration...")
-				newConf, err := config.LoadConfig(confPath)
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				newConf, err := provider.Load(ctx)
+				cancel()
 				if err != nil {
 					log.Printf("Error reloading config, keeping the old configuration. Error: %v", err)
 					continue // keep running with the old config
 				}
-
-				// In a file-based config source, we update the upstreams.
-				// For etcd, the watch mechanism handles this automatically.
-				if conf.ConfigSource.Type == "file" {
-					routerManager.UpdateUpstreams(newConf.Upstreams)
-					log.Println("Configuration reloaded successfully. Upstreams updated.")
-				} else {
-					log.Println("Configuration reload via SIGHUP is only supported for 'file' config source. Ignoring.")
-				}
+				// Sections held in the remote config source keep precedence over the file.
+				reload(gw, newConf, "sighup", strict)
 			}
 		}
 	}()
@@ -149,8 +166,26 @@ func main() {
 	fig := figure.NewFigure("LensGateway", "", true)
 	fig.Print()
 	log.Printf("Server listening on %s", conf.Global.ListenAddr)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := gw.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("listen: %s\n", err)
 	}
+	if err := provider.Close(); err != nil {
+		log.Printf("Failed to close config source: %v", err)
+	}
 	log.Println("Server exiting.")
 }
+
+// reload 原子重载网关，失败时保留旧配置；validate 为 true 时（-strict 或文件监听）校验不通过的配置不会被应用
+func reload(gw *core.Gateway, newConf *config.GatewayConfig, source string, validate bool) {
+	if validate {
+		if errs := core.Validate(newConf); len(errs) > 0 {
+			log.Printf("Rejecting invalid config from %s, keeping the old configuration. Error: %v", source, config.ValidationError(errs))
+			return
+		}
+	}
+	if err := gw.Reload(newConf, source); err != nil {
+		log.Printf("Error reloading config, keeping the old configuration. Error: %v", err)
+		return
+	}
+	log.Println("Configuration reloaded successfully.")
+}
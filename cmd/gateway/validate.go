@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+)
+
+// runValidate implements "gateway validate -conf <path>": it loads the config file
+// (with include fragments and interpolation) and reports every problem with its path.
+// The exit code is 0 when the config is valid, 1 otherwise.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("conf", "config/gateway.yaml", "gateway config file path")
+	fs.Parse(args)
+
+	_, err := core.ValidateFile(*path)
+	var verr config.ValidationError
+	switch {
+	case err == nil:
+		fmt.Printf("%s: config is valid\n", *path)
+		return 0
+	case errors.As(err, &verr):
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, verr)
+	default:
+		fmt.Fprintf(os.Stderr, "%s: failed to load config: %v\n", *path, err)
+	}
+	return 1
+}
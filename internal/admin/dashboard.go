@@ -0,0 +1,19 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 控制台是一个单文件页面（样式与脚本内联，无外部资源），通过轮询管理接口刷新。
+//
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+func (s *Server) dashboard(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", dashboardHTML)
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"LensGateway.com/internal/middleware"
+	"LensGateway.com/internal/quota"
+	"github.com/gin-gonic/gin"
+)
+
+// 配额接口：查看 quota 中间件的套餐与调用方在当前窗口的用量，以及清零用量。
+// 调用方为 quota 中间件 consumer 表达式取到的原始值，多项时以 ":" 连接；查找前按中间件相同的方式
+// 转义（并对过长的值取摘要），因此含 "/"、空格或 "+" 的调用方也能直接使用原始名称
+
+// requireToken 未配置 token 时拒绝修改运行时状态的请求
+func (s *Server) requireToken(c *gin.Context) {
+	if s.conf.Token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin writes are disabled, set admin.token to enable them"})
+	}
+}
+
+func (s *Server) listQuotas(c *gin.Context) {
+	quotas := make([]gin.H, 0)
+	for _, name := range quota.Names() {
+		q, ok := quota.Lookup(name)
+		if !ok {
+			continue
+		}
+		quotas = append(quotas, gin.H{"name": name, "plans": q.Plans(), "default_plan": q.DefaultPlan()})
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// lookupQuota 返回路径中的配额，不存在时返回 404
+func lookupQuota(c *gin.Context) (*quota.Quota, bool) {
+	q, ok := quota.Lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "quota not found"})
+	}
+	return q, ok
+}
+
+// consumerParam 返回路径中的调用方；路由使用通配参数，调用方可以包含 "/"
+func consumerParam(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("consumer"), "/")
+}
+
+// getQuotaUsage 返回调用方在各周期当前窗口的用量；带 ?plan= 时同时返回该套餐的限额与剩余请求数
+func (s *Server) getQuotaUsage(c *gin.Context) {
+	q, ok := lookupQuota(c)
+	if !ok {
+		return
+	}
+	consumer := consumerParam(c)
+	usage, err := q.Inspect(c.Request.Context(), middleware.KeyOf(consumer))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if plan := c.Query("plan"); plan != "" {
+		if _, ok := q.Plans()[plan]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown plan " + plan})
+			return
+		}
+		for _, l := range q.Limits(plan) {
+			for i := range usage {
+				if usage[i].Period == l.Period {
+					usage[i].Limit = l.Requests
+					usage[i].Remaining = max(0, l.Requests-usage[i].Used)
+				}
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"quota": q.Name(), "consumer": consumer, "usage": usage})
+}
+
+// resetQuotaUsage 清零调用方在当前窗口的用量，?period= 可指定只清零某个周期
+func (s *Server) resetQuotaUsage(c *gin.Context) {
+	q, ok := lookupQuota(c)
+	if !ok {
+		return
+	}
+	var periods []quota.Period
+	if v := c.Query("period"); v != "" {
+		p, err := quota.ParsePeriod(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		periods = append(periods, p)
+	}
+	if err := q.Reset(c.Request.Context(), middleware.KeyOf(consumerParam(c)), periods...); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
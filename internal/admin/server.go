@@ -0,0 +1,247 @@
+// Package admin 提供网关的管理 HTTP 接口：查看运行时路由表、上游节点状态与配置版本，
+// 以及修改上游、路由与节点权重、回滚配置版本、清零配额用量。默认在独立的监听地址上提供服务，避免与业务流量共用端口。
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+	"LensGateway.com/internal/observe"
+	"github.com/gin-gonic/gin"
+)
+
+// Server 管理接口服务
+type Server struct {
+	gw     *core.Gateway
+	conf   config.AdminConfig
+	writer config.UpstreamWriter // 写回配置源，为 nil 时写接口不可用
+	engine *gin.Engine
+	srv    *http.Server
+
+	writeMu sync.Mutex // 串行化写操作
+}
+
+// New 创建管理接口，Start 之前不会监听。writer 为当前配置源的写回实现，
+// 配置源不支持写回时传 nil
+func New(gw *core.Gateway, conf config.AdminConfig, writer config.UpstreamWriter) *Server {
+	if conf.ListenAddr == "" {
+		conf.ListenAddr = "127.0.0.1:9901"
+	}
+	s := &Server{gw: gw, conf: conf, writer: writer, engine: gin.New()}
+	s.engine.Use(gin.Recovery())
+	s.routes(s.engine.Group("/admin", s.authenticate))
+	// 控制台页面本身不含数据，不需要认证；页面中的 API 请求携带 token
+	s.engine.GET("/admin/ui/", s.dashboard)
+	s.engine.GET("/admin/ui", func(c *gin.Context) { c.Redirect(http.StatusMovedPermanently, "/admin/ui/") })
+	return s
+}
+
+func (s *Server) routes(r *gin.RouterGroup) {
+	r.GET("/routes", s.listRoutes)
+	r.GET("/upstreams", s.listUpstreams)
+	r.GET("/upstreams/:name", s.getUpstream)
+	// 配置内容中可能有明文密钥（jwt secret_key、redis_password 等），与历史一起只对持有 token 的请求开放
+	conf := r.Group("/config", s.requireConfigToken)
+	conf.GET("", s.getConfig)
+	conf.GET("/history", s.listHistory)
+	conf.GET("/history/:version", s.getHistory)
+	conf.GET("/history/:version/diff", s.getHistoryDiff)
+	conf.POST("/history/:version/rollback", s.rollback)
+	r.GET("/stats", s.getStats)
+	r.GET("/quotas", s.listQuotas)
+	r.GET("/quotas/:name/consumers/*consumer", s.getQuotaUsage)
+	r.DELETE("/quotas/:name/consumers/*consumer", s.requireToken, s.resetQuotaUsage)
+
+	w := r.Group("", s.requireWrites)
+	w.PUT("/upstreams/:name", s.putUpstream)
+	w.DELETE("/upstreams/:name", s.deleteUpstream)
+	w.PUT("/upstreams/:name/routes", s.putRoutes)
+	w.PUT("/upstreams/:name/nodes/:host", s.putNode)
+	w.DELETE("/upstreams/:name/nodes/:host", s.deleteNode)
+}
+
+// authenticate 配置了 token 时校验 Authorization: Bearer <token>
+func (s *Server) authenticate(c *gin.Context) {
+	if s.conf.Token == "" {
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.Token)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	}
+}
+
+// requireConfigToken 未配置 token 时不提供配置与配置历史
+func (s *Server) requireConfigToken(c *gin.Context) {
+	if s.conf.Token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "config endpoints are disabled, set admin.token to enable them"})
+	}
+}
+
+// Handler 返回管理接口的 http.Handler，所有路径位于 /admin/ 下
+func (s *Server) Handler() http.Handler {
+	return s.engine
+}
+
+// Start 开始提供服务：独立监听，或挂载到网关监听（embedded，要求配置 token）
+func (s *Server) Start() error {
+	if s.conf.Embedded {
+		if s.conf.Token == "" {
+			return errors.New("admin.embedded requires admin.token, the admin API would be served unauthenticated on the gateway listener")
+		}
+		log.Println("[gateway] admin API mounted on the gateway listener under /admin/")
+		return s.gw.SetAdminHandler(s.engine)
+	}
+	ln, err := net.Listen("tcp", s.conf.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.srv = &http.Server{Handler: s.engine}
+	log.Printf("[gateway] admin API listening on %s", ln.Addr())
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[gateway] admin API stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown 关闭独立监听
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) listRoutes(c *gin.Context) {
+	s.setETag(c)
+	c.JSON(http.StatusOK, gin.H{"routes": s.gw.RouterManager().Routes()})
+}
+
+func (s *Server) listUpstreams(c *gin.Context) {
+	s.setETag(c)
+	c.JSON(http.StatusOK, gin.H{"upstreams": s.gw.RouterManager().Upstreams()})
+}
+
+func (s *Server) getUpstream(c *gin.Context) {
+	s.setETag(c)
+	for _, up := range s.gw.RouterManager().Upstreams() {
+		if up.Name == c.Param("name") {
+			c.JSON(http.StatusOK, up)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "upstream not found"})
+}
+
+// getConfig 返回当前生效的配置及其版本，来自 ${...} 引用的值已脱敏
+func (s *Server) getConfig(c *gin.Context) {
+	snap := s.gw.History().Current()
+	snap.Config = s.gw.Config()
+	c.Header("ETag", etag(snap.Version))
+	writeSnapshot(c, snap)
+}
+
+func (s *Server) listHistory(c *gin.Context) {
+	snaps := s.gw.History().List()
+	for i := range snaps {
+		snaps[i].Config = nil
+	}
+	c.JSON(http.StatusOK, gin.H{"current": s.gw.History().Current().Version, "history": snaps})
+}
+
+func (s *Server) getHistory(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	snap, ok := s.gw.History().Get(version)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "config version not found"})
+		return
+	}
+	writeSnapshot(c, snap)
+}
+
+// getHistoryDiff 返回该版本相对于历史中上一个版本的变更
+func (s *Server) getHistoryDiff(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	snaps := s.gw.History().List()
+	i := slices.IndexFunc(snaps, func(snap config.Snapshot) bool { return snap.Version == version })
+	switch {
+	case i < 0:
+		c.JSON(http.StatusNotFound, gin.H{"error": "config version not found"})
+		return
+	case i == 0 || snaps[i-1].Config == nil || snaps[i].Config == nil:
+		c.JSON(http.StatusNotFound, gin.H{"error": "previous config version not available"})
+		return
+	}
+	changes := make([]string, 0)
+	for _, ch := range core.Diff(snaps[i-1].Config, snaps[i].Config) {
+		changes = append(changes, ch.String())
+	}
+	c.JSON(http.StatusOK, gin.H{"version": version, "previous": snaps[i-1].Version, "changes": changes})
+}
+
+// rollback 重新应用历史中的某个版本，生效后记录为新的版本。只影响当前进程，
+// 配置源随后的变更会覆盖回滚结果
+func (s *Server) rollback(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	snap, ok := s.gw.History().Get(version)
+	switch {
+	case !ok:
+		c.JSON(http.StatusNotFound, gin.H{"error": "config version not found"})
+		return
+	case snap.Redacted || snap.Config == nil:
+		c.JSON(http.StatusConflict, gin.H{"error": "config version was restored with secrets redacted and cannot be rolled back to"})
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.gw.Rollback(version); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": config.Redact(err.Error())})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": s.gw.History().Current().Version, "rolled_back_to": version})
+}
+
+// getStats 返回各路由的累计请求数、5xx 数与耗时直方图
+func (s *Server) getStats(c *gin.Context) {
+	stats, err := observe.RouteStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"routes": stats})
+}
+
+func writeSnapshot(c *gin.Context, snap config.Snapshot) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(config.Redact(string(data))))
+}
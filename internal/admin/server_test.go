@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+	"LensGateway.com/internal/observe"
+)
+
+func get(t *testing.T, h http.Handler, path string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminInspection(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	gw, err := core.NewGateway(&config.GatewayConfig{
+		Global: config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "users", LoadBalancing: "p2c", Hosts: []string{host}, Routes: []config.RouteConfig{
+				{Path: "/api/users/**", Methods: []string{"GET"}, Rewrite: "/users/",
+					Fallback: &config.FallbackConfig{Upstream: "backup", Status: 503}},
+			}},
+			{Name: "backup", Hosts: []string{"127.0.0.1:1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(gw, config.AdminConfig{}, nil).Handler()
+
+	// a request held by the backend shows up as in-flight
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.Get(gwSrv.URL + "/api/users/1"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	var up core.UpstreamInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if code := get(t, h, "/admin/upstreams/users", &up); code != http.StatusOK {
+			t.Fatalf("GET /admin/upstreams/users = %d", code)
+		}
+		if up.Nodes[0].InFlight == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if up.Algorithm != "p2c" || len(up.Nodes) != 1 || !up.Nodes[0].Healthy || up.Nodes[0].InFlight != 1 {
+		t.Fatalf("unexpected upstream %+v", up)
+	}
+	close(release)
+	<-done
+	get(t, h, "/admin/upstreams/users", &up)
+	if up.Nodes[0].InFlight != 0 {
+		t.Fatalf("in-flight after completion = %d", up.Nodes[0].InFlight)
+	}
+
+	var routes struct{ Routes []core.RouteInfo }
+	get(t, h, "/admin/routes", &routes)
+	if len(routes.Routes) != 1 {
+		t.Fatalf("routes = %+v", routes.Routes)
+	}
+	rt := routes.Routes[0]
+	if rt.Prefix != "/api/users/" || rt.Upstream != "users" || rt.Rewrite != "/users/" ||
+		len(rt.Methods) != 1 || rt.Fallback == nil || rt.Fallback.Upstream != "backup" || rt.Fallback.Status != 503 {
+		t.Fatalf("unexpected route %+v", rt)
+	}
+
+	// the config may hold secrets and is only served with a token
+	for _, path := range []string{"/admin/config", "/admin/config/history", "/admin/config/history/1"} {
+		if code := get(t, h, path, nil); code != http.StatusForbidden {
+			t.Fatalf("GET %s without admin token configured = %d", path, code)
+		}
+	}
+	rec := request(New(gw, config.AdminConfig{Token: "s3cret"}, nil).Handler(), http.MethodGet, "/admin/config", "", "")
+	var snap config.Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("GET /admin/config = %d: %v", rec.Code, err)
+	}
+	if snap.Version != 1 || snap.Source != "startup" || snap.Config == nil || len(snap.Config.Upstreams) != 2 {
+		t.Fatalf("unexpected config %+v", snap)
+	}
+	// the route's request shows up in the dashboard stats
+	var stats struct{ Routes []observe.RouteStat }
+	get(t, h, "/admin/stats", &stats)
+	if len(stats.Routes) == 0 || stats.Routes[0].Route != "/api/users/" || stats.Routes[0].Requests == 0 || stats.Routes[0].LatencyCount == 0 {
+		t.Fatalf("unexpected stats %+v", stats.Routes)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ui/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<title>LensGateway</title>") {
+		t.Fatalf("GET /admin/ui/ = %d", rec.Code)
+	}
+
+	if code := get(t, h, "/admin/upstreams/nope", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown upstream = %d", code)
+	}
+	if err := New(gw, config.AdminConfig{Embedded: true}, nil).Start(); err == nil {
+		t.Fatal("embedded admin API started without a token")
+	}
+}
+
+func TestAdminRollback(t *testing.T) {
+	v1 := &config.GatewayConfig{
+		Global: config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "users", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{{Path: "/api/users/**"}}},
+		},
+	}
+	gw, err := core.NewGateway(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2 := *v1
+	v2.Upstreams = append(v2.Upstreams, config.UpstreamConfig{
+		Name: "orders", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{{Path: "/api/orders/**"}},
+	})
+	if err := gw.Reload(&v2, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(h http.Handler, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(New(gw, config.AdminConfig{}, nil).Handler(), "/admin/config/history/1/rollback", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("rollback without admin token configured = %d", rec.Code)
+	}
+	h := New(gw, config.AdminConfig{Token: "s3cret"}, nil).Handler()
+	if rec := post(h, "/admin/config/history/1/rollback", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("rollback with wrong token = %d", rec.Code)
+	}
+	if rec := post(h, "/admin/config/history/9/rollback", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("rollback to unknown version = %d", rec.Code)
+	}
+	if rec := post(h, "/admin/config/history/x/rollback", "s3cret"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("rollback to invalid version = %d", rec.Code)
+	}
+
+	rec := post(h, "/admin/config/history/1/rollback", "s3cret")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"rolled_back_to":1,"version":3}` {
+		t.Fatalf("rollback = %d %s", rec.Code, rec.Body)
+	}
+	if routes := gw.RouterManager().Routes(); len(routes) != 1 || routes[0].Prefix != "/api/users/" {
+		t.Fatalf("routes after rollback = %+v", routes)
+	}
+	if cur := gw.History().Current(); cur.Version != 3 || cur.Source != "rollback:1" {
+		t.Fatalf("current version after rollback = %+v", cur)
+	}
+}
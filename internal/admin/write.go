@@ -0,0 +1,269 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+	"github.com/gin-gonic/gin"
+)
+
+// 写接口采用乐观并发控制：读接口在 ETag 中返回当前配置版本，写请求必须在 If-Match 中带回该值，
+// 期间配置发生过任何变更（其他操作员、配置源推送、SIGHUP）都会返回 412，需重新读取后再修改。
+// 变更先经过校验，再在 Gateway.UpdateUpstreams 中确认版本未变后写回配置源并原子生效。
+
+// statusError 带 HTTP 状态码的错误
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// persistError 写回配置源失败
+type persistError struct{ err error }
+
+func (e *persistError) Error() string { return e.err.Error() }
+func (e *persistError) Unwrap() error { return e.err }
+
+func errorf(status int, format string, args ...any) error {
+	return &statusError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func (s *Server) setETag(c *gin.Context) {
+	c.Header("ETag", etag(s.gw.History().Current().Version))
+}
+
+// requireWrites 未配置 token 或配置源不支持写回时拒绝写请求
+func (s *Server) requireWrites(c *gin.Context) {
+	switch {
+	case s.conf.Token == "":
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin writes are disabled, set admin.token to enable them"})
+	case s.writer == nil:
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": "the config source does not support writing changes back"})
+	}
+}
+
+// update 执行一次上游变更：检查 If-Match，调用 edit 生成新的上游列表（及成功时的状态码），
+// 校验、写回配置源并生效
+func (s *Server) update(c *gin.Context, edit func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error)) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match with the ETag of the current config version is required"})
+		return
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if current := s.gw.History().Current().Version; err != nil || version != current {
+		c.Header("ETag", etag(current))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": fmt.Sprintf("config version is %d, not %s", current, ifMatch)})
+		return
+	}
+
+	conf := s.gw.Config()
+	upstreams, status, err := edit(slices.Clone(conf.Upstreams))
+	if err != nil {
+		var se *statusError
+		if errors.As(err, &se) {
+			c.JSON(se.status, gin.H{"error": se.msg})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// 只拒绝这次变更引入的问题，已有配置中的问题不影响操作
+	candidate := *conf
+	candidate.Upstreams = upstreams
+	existing := make(map[config.FieldError]bool)
+	for _, fe := range core.Validate(conf) {
+		existing[fe] = true
+	}
+	var problems []config.FieldError
+	for _, fe := range core.Validate(&candidate) {
+		if !existing[fe] {
+			problems = append(problems, fe)
+		}
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid change", "problems": problems})
+		return
+	}
+
+	// 版本检查、写回与生效在网关的配置锁内完成：返回 412 时配置源未被写入
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	snap, err := s.gw.UpdateUpstreams(upstreams, "admin", version, func() error {
+		if err := s.writer.WriteUpstreams(ctx, upstreams); err != nil {
+			return &persistError{err}
+		}
+		return nil
+	})
+	var pe *persistError
+	switch {
+	case errors.Is(err, core.ErrVersionConflict):
+		c.Header("ETag", etag(s.gw.History().Current().Version))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, config.ErrRevisionConflict), errors.Is(err, config.ErrUpstreamsNotOwned),
+		errors.Is(err, config.ErrResolvedSecret):
+		c.JSON(http.StatusConflict, gin.H{"error": config.Redact(err.Error())})
+		return
+	case errors.As(err, &pe):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to persist the change: " + config.Redact(pe.err.Error())})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("ETag", etag(snap.Version))
+	c.JSON(status, gin.H{"version": snap.Version})
+}
+
+func bindJSON(c *gin.Context, out any) error {
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func indexOf(upstreams []config.UpstreamConfig, name string) int {
+	return slices.IndexFunc(upstreams, func(up config.UpstreamConfig) bool { return up.Name == name })
+}
+
+// putUpstream 创建或整体替换一个上游（包括其路由）
+func (s *Server) putUpstream(c *gin.Context) {
+	name := c.Param("name")
+	var up config.UpstreamConfig
+	if err := bindJSON(c, &up); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if up.Name != "" && up.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("upstream name %q does not match the path", up.Name)})
+		return
+	}
+	up.Name = name
+
+	s.update(c, func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error) {
+		if i := indexOf(upstreams, name); i >= 0 {
+			upstreams[i] = up
+			return upstreams, http.StatusOK, nil
+		}
+		return append(upstreams, up), http.StatusCreated, nil
+	})
+}
+
+func (s *Server) deleteUpstream(c *gin.Context) {
+	name := c.Param("name")
+	s.update(c, func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error) {
+		i := indexOf(upstreams, name)
+		if i < 0 {
+			return nil, 0, errorf(http.StatusNotFound, "upstream %q not found", name)
+		}
+		return slices.Delete(upstreams, i, i+1), http.StatusOK, nil
+	})
+}
+
+// putRoutes 替换上游的全部路由
+func (s *Server) putRoutes(c *gin.Context) {
+	name := c.Param("name")
+	var routes []config.RouteConfig
+	if err := bindJSON(c, &routes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.update(c, func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error) {
+		i := indexOf(upstreams, name)
+		if i < 0 {
+			return nil, 0, errorf(http.StatusNotFound, "upstream %q not found", name)
+		}
+		upstreams[i].Routes = routes
+		return upstreams, http.StatusOK, nil
+	})
+}
+
+// putNode 添加节点或修改节点权重，请求体形如 {"weight": 3}，可省略（权重为 1）
+func (s *Server) putNode(c *gin.Context) {
+	name, host := c.Param("name"), c.Param("host")
+	var body struct {
+		Weight int `json:"weight"`
+	}
+	if data, _ := c.GetRawData(); len(bytes.TrimSpace(data)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+	}
+	s.update(c, func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error) {
+		i := indexOf(upstreams, name)
+		if i < 0 {
+			return nil, 0, errorf(http.StatusNotFound, "upstream %q not found", name)
+		}
+		up := upstreams[i]
+		if !slices.Contains(up.Hosts, host) {
+			up.Hosts = append(slices.Clone(up.Hosts), host)
+		}
+		up.Weights = withoutHost(up.Weights, host)
+		if body.Weight != 0 && body.Weight != 1 {
+			if up.Weights == nil {
+				up.Weights = make(map[string]int, 1)
+			}
+			up.Weights[host] = body.Weight
+		}
+		upstreams[i] = up
+		return upstreams, http.StatusOK, nil
+	})
+}
+
+func (s *Server) deleteNode(c *gin.Context) {
+	name, host := c.Param("name"), c.Param("host")
+	s.update(c, func(upstreams []config.UpstreamConfig) ([]config.UpstreamConfig, int, error) {
+		i := indexOf(upstreams, name)
+		if i < 0 {
+			return nil, 0, errorf(http.StatusNotFound, "upstream %q not found", name)
+		}
+		up := upstreams[i]
+		j := slices.Index(up.Hosts, host)
+		if j < 0 {
+			return nil, 0, errorf(http.StatusNotFound, "host %q not found in upstream %q", host, name)
+		}
+		up.Hosts = slices.Delete(slices.Clone(up.Hosts), j, j+1)
+		up.Weights = withoutHost(up.Weights, host)
+		upstreams[i] = up
+		return upstreams, http.StatusOK, nil
+	})
+}
+
+// withoutHost 返回去掉 host 之后的权重表，为空时返回 nil
+func withoutHost(weights map[string]int, host string) map[string]int {
+	var out map[string]int
+	for h, w := range weights {
+		if !strings.EqualFold(h, host) {
+			if out == nil {
+				out = make(map[string]int, len(weights))
+			}
+			out[h] = w
+		}
+	}
+	return out
+}
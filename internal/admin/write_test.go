@@ -0,0 +1,117 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/core"
+)
+
+func request(h http.Handler, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(`global:
+  listen_addr: ":0"
+upstreams:
+  # owned by the users team
+  - name: users
+    hosts: ["127.0.0.1:1", "127.0.0.1:2"]
+    routes:
+      - path: "/api/users/**"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := config.NewProvider(path, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf, err = provider.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	gw, err := core.NewGateway(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(gw, config.AdminConfig{Token: "s3cret"}, provider.(config.UpstreamWriter)).Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET without token = %d", rec.Code)
+	}
+	rec = request(h, http.MethodGet, "/admin/upstreams/users", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag != `"1"` {
+		t.Fatalf("GET = %d, ETag %q", rec.Code, etag)
+	}
+
+	if rec := request(h, http.MethodPut, "/admin/upstreams/users/nodes/127.0.0.1:2", "", `{"weight": 3}`); rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("PUT without If-Match = %d", rec.Code)
+	}
+	rec = request(h, http.MethodPut, "/admin/upstreams/users/nodes/127.0.0.1:2", etag, `{"weight": 3}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("PUT node = %d %s, ETag %q", rec.Code, rec.Body, rec.Header().Get("ETag"))
+	}
+	// a second operator still holding the old ETag is turned away
+	if rec := request(h, http.MethodDelete, "/admin/upstreams/users", etag, ""); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match = %d", rec.Code)
+	}
+	etag = `"2"`
+
+	// invalid changes are rejected before anything is written
+	rec = request(h, http.MethodPut, "/admin/upstreams/orders", etag, `{"hosts": ["127.0.0.1:3"], "routes": [{"path": "/api/users/**"}]}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "shadowed") {
+		t.Fatalf("PUT shadowing upstream = %d %s", rec.Code, rec.Body)
+	}
+	rec = request(h, http.MethodPut, "/admin/upstreams/orders", etag, `{"hosts": ["127.0.0.1:3"], "routes": [{"path": "/api/orders/**"}]}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("ETag") != `"3"` {
+		t.Fatalf("PUT new upstream = %d %s", rec.Code, rec.Body)
+	}
+
+	// the change is live and persisted, the untouched parts of the file are kept
+	get := request(h, http.MethodGet, "/admin/upstreams/users", "", "")
+	if !strings.Contains(get.Body.String(), `"weight":3`) {
+		t.Fatalf("users after update: %s", get.Body)
+	}
+	diff := request(h, http.MethodGet, "/admin/config/history/2/diff", "", "")
+	if !strings.Contains(diff.Body.String(), `changed upstreams/users/weights[127.0.0.1:2]: 1 -\u003e 3`) {
+		t.Fatalf("diff of version 2: %s", diff.Body)
+	}
+	if _, ok := gw.RouterManager().PreMatch(http.MethodGet, "/api/orders/1"); !ok {
+		t.Fatal("new route is not live")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# owned by the users team") {
+		t.Errorf("comment lost:\n%s", data)
+	}
+	reloaded, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ConfigHash(reloaded) != gw.History().Current().Hash {
+		t.Fatalf("persisted config differs from the active one:\n%s", data)
+	}
+}
@@ -3,6 +3,7 @@ package balancer
 import (
 	"errors"
 	"net/url"
+	"sort"
 )
 
 var (
@@ -12,6 +13,8 @@ var (
 
 type UpstreamNode struct {
 	Url *url.URL
+	// Weight is the relative share of requests, honoured by round-robin; 0 means 1.
+	Weight int
 }
 
 // Balancer interface is the load balancer for the reverse proxy.
@@ -42,3 +45,13 @@ func Build(name, algo string, hosts []UpstreamNode) (Balancer, error) {
 	}
 	return factory(name, algo, hosts), nil
 }
+
+// Algorithms returns the registered algorithm names, sorted.
+func Algorithms() []string {
+	algos := make([]string, 0, len(factories))
+	for algo := range factories {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	return algos
+}
@@ -1,6 +1,7 @@
 package balancer
 
 import (
+	"slices"
 	"sync"
 )
 
@@ -60,6 +61,9 @@ func (b *BaseBalancer) Algo() string {
 	return b.algo
 }
 
+// Hosts returns a copy of the nodes currently in rotation.
 func (b *BaseBalancer) Hosts() []UpstreamNode {
-	return b.nodes
+	b.RLock()
+	defer b.RUnlock()
+	return slices.Clone(b.nodes)
 }
@@ -4,10 +4,14 @@ import (
 	"sync/atomic"
 )
 
-// RoundRobin will select the server in turn from the server to proxy
+// RoundRobin will select the server in turn from the server to proxy.
+// When any node has a weight above 1 it switches to smooth weighted
+// round-robin, which spreads the heavier nodes evenly instead of in bursts.
 type RoundRobin struct {
 	BaseBalancer
-	i atomic.Uint64
+	i        atomic.Uint64
+	weighted bool
+	current  map[string]int // smooth weighted round-robin state, keyed by host
 }
 
 func init() {
@@ -18,13 +22,17 @@ func init() {
 func NewRoundRobin(name, algo string, nodes []UpstreamNode) Balancer {
 
 	alive := make(map[string]bool)
+	weighted := false
 	for _, node := range nodes {
 		host := node.Url.Host
 		alive[host] = true // initial mark alive
+		weighted = weighted || node.Weight > 1
 	}
 
 	return &RoundRobin{
-		i: atomic.Uint64{},
+		i:        atomic.Uint64{},
+		weighted: weighted,
+		current:  make(map[string]int),
 		BaseBalancer: BaseBalancer{
 			nodes: nodes,
 			name:  name,
@@ -36,6 +44,9 @@ func NewRoundRobin(name, algo string, nodes []UpstreamNode) Balancer {
 
 // Balance selects a suitable host according
 func (r *RoundRobin) Balance(_ string) (UpstreamNode, error) {
+	if r.weighted {
+		return r.balanceWeighted()
+	}
 	r.RLock()
 	defer r.RUnlock()
 	if len(r.nodes) == 0 {
@@ -44,3 +55,25 @@ func (r *RoundRobin) Balance(_ string) (UpstreamNode, error) {
 	host := r.nodes[r.i.Add(1)%uint64(len(r.nodes))]
 	return host, nil
 }
+
+// balanceWeighted is nginx's smooth weighted round-robin: every pick adds each
+// node's weight to its current value, chooses the largest, and subtracts the total from it.
+func (r *RoundRobin) balanceWeighted() (UpstreamNode, error) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.nodes) == 0 {
+		return UpstreamNode{}, ErrorNoHost
+	}
+	total, best := 0, -1
+	for i, n := range r.nodes {
+		w := max(n.Weight, 1)
+		total += w
+		r.current[n.Url.Host] += w
+		if best < 0 || r.current[n.Url.Host] > r.current[r.nodes[best].Url.Host] {
+			best = i
+		}
+	}
+	node := r.nodes[best]
+	r.current[node.Url.Host] -= total
+	return node, nil
+}
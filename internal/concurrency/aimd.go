@@ -0,0 +1,35 @@
+package concurrency
+
+import "time"
+
+const AIMD = "aimd"
+
+// aimdBackoff is the factor applied to the limit when a request is dropped.
+const aimdBackoff = 0.9
+
+// AIMDLimit grows the limit by one while it is being used and cuts it by
+// aimdBackoff whenever a request is dropped. It only reacts to drops, so it
+// should be paired with a timeout on the limiter.
+type AIMDLimit struct {
+	opts  Options
+	limit float64
+}
+
+func init() {
+	factories[AIMD] = NewAIMD
+}
+
+// NewAIMD create new AIMD algorithm
+func NewAIMD(opts Options) Algorithm {
+	return &AIMDLimit{opts: opts, limit: float64(opts.Initial)}
+}
+
+func (a *AIMDLimit) Update(_ time.Duration, inflight int, dropped bool) int {
+	if dropped {
+		a.limit = a.opts.clamp(a.limit * aimdBackoff)
+	} else if inflight*2 >= int(a.limit) {
+		// only grow while at least half of the limit is in use
+		a.limit = a.opts.clamp(a.limit + 1)
+	}
+	return int(a.limit)
+}
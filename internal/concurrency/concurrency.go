@@ -0,0 +1,291 @@
+// Package concurrency limits the number of requests in flight to a backend and adapts
+// the limit from observed latency, in the spirit of Netflix's concurrency-limits.
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var ErrorAlgorithmNotSupported = errors.New("concurrency limit algorithm not supported")
+
+var (
+	// Current concurrency limit of each limiter.
+	limitGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "concurrency_limit",
+			Help:      "Current adaptive concurrency limit.",
+		},
+		// scope: route|upstream, name: route prefix or upstream name
+		[]string{"scope", "name"},
+	)
+
+	inflightGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "concurrency_inflight",
+			Help:      "Requests currently admitted by the concurrency limiter.",
+		},
+		[]string{"scope", "name"},
+	)
+
+	shedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lens_gateway",
+			Name:      "concurrency_shed_total",
+			Help:      "Total number of requests rejected by the concurrency limiter.",
+		},
+		// class: priority class of the request,
+		// reason: overload (no queue), queue_full, evicted (by a more important request), timeout, canceled
+		[]string{"scope", "name", "class", "reason"},
+	)
+
+	queueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "concurrency_queue_depth",
+			Help:      "Requests waiting in the admission queue of the concurrency limiter.",
+		},
+		[]string{"scope", "name", "class"},
+	)
+)
+
+// Algorithm adjusts a concurrency limit from the latency of finished requests.
+// Implementations are not safe for concurrent use; Limiter serialises calls.
+type Algorithm interface {
+	// Update records one finished request and returns the new limit. inflight is the
+	// number of requests in flight when it started, dropped reports that it timed out
+	// or the backend answered as overloaded.
+	Update(rtt time.Duration, inflight int, dropped bool) int
+}
+
+// Options bound the limit of every algorithm and configure the limiter around it.
+type Options struct {
+	Initial int
+	Min     int
+	Max     int
+
+	Timeout      time.Duration // requests slower than this count as dropped, 0 disables
+	QueueSize    int           // requests waiting for a slot, 0 sheds as soon as the limit is reached
+	QueueTimeout time.Duration // longest wait in the queue, 0 waits until the request is canceled
+}
+
+func (o Options) clamp(limit float64) float64 {
+	return min(float64(o.Max), max(float64(o.Min), limit))
+}
+
+// Factory creates an algorithm starting at opts.Initial.
+type Factory func(opts Options) Algorithm
+
+var factories = make(map[string]Factory)
+
+// Build creates the named algorithm. Options are normalised so that 1 <= Min <= Initial <= Max.
+func Build(name string, opts Options) (Algorithm, Options, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, opts, ErrorAlgorithmNotSupported
+	}
+	opts.Min = max(1, opts.Min)
+	opts.Max = max(opts.Min, opts.Max)
+	opts.Initial = min(opts.Max, max(opts.Min, opts.Initial))
+	return factory(opts), opts, nil
+}
+
+// Algorithms returns the registered algorithm names, sorted.
+func Algorithms() []string {
+	algos := make([]string, 0, len(factories))
+	for algo := range factories {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	return algos
+}
+
+// Limiters with the same scope and name share their metric series. After a reload the
+// old middleware chain's limiter keeps serving in-flight requests while the new chain's
+// limiter takes over, so only the most recently created limiter of a series (its owner)
+// sets the gauges and removes the series on Close.
+var (
+	ownersMu sync.Mutex
+	owners   = make(map[[2]string]*Limiter)
+)
+
+// Class is the priority of a request. Requests with a lower Level are admitted from the
+// queue first and evict queued requests of a higher Level when the queue is full.
+type Class struct {
+	Name  string
+	Level int
+}
+
+// Limiter admits requests while fewer than the current limit are in flight. With a queue,
+// requests over the limit wait for a slot in priority order instead of being shed at once.
+type Limiter struct {
+	scope, name string
+	opts        Options
+	now         func() time.Time
+
+	mu       sync.Mutex
+	algo     Algorithm
+	limit    int
+	inflight int
+	queue    waitQueue
+	seq      uint64
+	owner    atomic.Bool // reports the gauges of its scope and name, see owners
+}
+
+// NewLimiter creates a limiter reported under the given scope and name.
+func NewLimiter(scope, name string, algo Algorithm, opts Options) *Limiter {
+	l := &Limiter{scope: scope, name: name, opts: opts, now: time.Now, algo: algo, limit: opts.Initial}
+	ownersMu.Lock()
+	if prev := owners[[2]string{scope, name}]; prev != nil {
+		prev.owner.Store(false)
+	}
+	owners[[2]string{scope, name}] = l
+	l.owner.Store(true)
+	ownersMu.Unlock()
+	limitGauge.WithLabelValues(scope, name).Set(float64(l.limit))
+	return l
+}
+
+// Acquire admits a request if the limit allows it, otherwise queues it until a slot frees up,
+// the queue timeout passes, ctx is done or a more important request evicts it. The returned
+// function must be called exactly once when the request finishes, with dropped set if the
+// backend was overloaded.
+func (l *Limiter) Acquire(ctx context.Context, class Class) (release func(dropped bool), ok bool) {
+	l.mu.Lock()
+	if l.inflight < l.limit && len(l.queue) == 0 {
+		l.inflight++
+		inflight := l.inflight
+		l.setGauges()
+		l.mu.Unlock()
+		return l.release(inflight), true
+	}
+	if l.opts.QueueSize <= 0 {
+		l.mu.Unlock()
+		l.shed(class, "overload")
+		return nil, false
+	}
+	if len(l.queue) >= l.opts.QueueSize {
+		victim := l.queue.last()
+		if victim.class.Level <= class.Level {
+			l.mu.Unlock()
+			l.shed(class, "queue_full")
+			return nil, false
+		}
+		l.dequeue(victim)
+		victim.ready <- evicted
+	}
+	w := &waiter{class: class, seq: l.seq, ready: make(chan int, 1)}
+	l.seq++
+	heap.Push(&l.queue, w)
+	queueDepth.WithLabelValues(l.scope, l.name, class.Name).Inc()
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.opts.QueueTimeout > 0 {
+		timer := time.NewTimer(l.opts.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var inflight int
+	reason := ""
+	select {
+	case inflight = <-w.ready:
+	case <-timeout:
+		reason = "timeout"
+	case <-ctx.Done():
+		reason = "canceled"
+	}
+	if reason != "" {
+		l.mu.Lock()
+		if w.index >= 0 {
+			l.dequeue(w)
+			l.mu.Unlock()
+			l.shed(class, reason)
+			return nil, false
+		}
+		l.mu.Unlock()
+		// admitted or evicted just before giving up
+		inflight = <-w.ready
+	}
+	if inflight == evicted {
+		l.shed(class, "evicted")
+		return nil, false
+	}
+	return l.release(inflight), true
+}
+
+// release returns the function that frees the slot of an admitted request.
+func (l *Limiter) release(inflight int) func(dropped bool) {
+	start := l.now()
+	return func(dropped bool) {
+		rtt := l.now().Sub(start)
+		if l.opts.Timeout > 0 && rtt > l.opts.Timeout {
+			dropped = true
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.inflight--
+		l.limit = l.algo.Update(rtt, inflight, dropped)
+		// hand free slots to the most important waiters
+		for l.inflight < l.limit && len(l.queue) > 0 {
+			w := heap.Pop(&l.queue).(*waiter)
+			queueDepth.WithLabelValues(l.scope, l.name, w.class.Name).Dec()
+			l.inflight++
+			w.ready <- l.inflight
+		}
+		l.setGauges()
+	}
+}
+
+// setGauges reports the limit and in-flight count if l owns its series. Callers hold l.mu.
+func (l *Limiter) setGauges() {
+	if !l.owner.Load() {
+		return
+	}
+	limitGauge.WithLabelValues(l.scope, l.name).Set(float64(l.limit))
+	inflightGauge.WithLabelValues(l.scope, l.name).Set(float64(l.inflight))
+}
+
+// dequeue removes a waiter from the queue. Callers hold l.mu.
+func (l *Limiter) dequeue(w *waiter) {
+	heap.Remove(&l.queue, w.index)
+	queueDepth.WithLabelValues(l.scope, l.name, w.class.Name).Dec()
+}
+
+func (l *Limiter) shed(class Class, reason string) {
+	shedTotal.WithLabelValues(l.scope, l.name, class.Name, reason).Inc()
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Close removes the limiter's metrics, unless a newer limiter with the same scope and
+// name has taken them over.
+func (l *Limiter) Close() {
+	ownersMu.Lock()
+	defer ownersMu.Unlock()
+	if owners[[2]string{l.scope, l.name}] != l {
+		return
+	}
+	delete(owners, [2]string{l.scope, l.name})
+	l.owner.Store(false)
+	labels := prometheus.Labels{"scope": l.scope, "name": l.name}
+	limitGauge.Delete(labels)
+	inflightGauge.Delete(labels)
+	queueDepth.DeletePartialMatch(labels)
+	shedTotal.DeletePartialMatch(labels)
+}
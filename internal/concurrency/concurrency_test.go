@@ -0,0 +1,205 @@
+package concurrency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var (
+	ctx    = context.Background()
+	high   = Class{Name: "high", Level: 0}
+	normal = Class{Name: "normal", Level: 1}
+	low    = Class{Name: "low", Level: 2}
+)
+
+func TestLimiterSheds(t *testing.T) {
+	algo, opts, err := Build(AIMD, Options{Initial: 2, Min: 1, Max: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLimiter("route", "/test-sheds", algo, opts)
+	defer l.Close()
+	r1, ok1 := l.Acquire(ctx, normal)
+	_, ok2 := l.Acquire(ctx, normal)
+	if _, ok := l.Acquire(ctx, normal); !ok1 || !ok2 || ok {
+		t.Fatalf("admitted %v %v %v, want only two", ok1, ok2, ok)
+	}
+	r1(true)
+	if l.Limit() != 1 {
+		t.Fatalf("limit after drop = %d, want 1", l.Limit())
+	}
+	if _, ok := l.Acquire(ctx, normal); ok {
+		t.Fatal("admitted above the reduced limit")
+	}
+}
+
+func TestLimiterTimeout(t *testing.T) {
+	algo, opts, _ := Build(AIMD, Options{Initial: 10, Min: 1, Max: 10, Timeout: 100 * time.Millisecond})
+	l := NewLimiter("route", "/test-timeout", algo, opts)
+	defer l.Close()
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	release, _ := l.Acquire(ctx, normal)
+	now = now.Add(time.Second)
+	release(false)
+	if l.Limit() != 9 {
+		t.Fatalf("limit after slow request = %d, want 9", l.Limit())
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	for _, name := range Algorithms() {
+		t.Run(name, func(t *testing.T) {
+			algo, opts, err := Build(name, Options{Initial: 20, Min: 5, Max: 200})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a fully used limit with steady latency grows
+			limit := opts.Initial
+			for range 200 {
+				limit = algo.Update(10*time.Millisecond, limit, false)
+			}
+			if limit <= opts.Initial {
+				t.Fatalf("limit under steady latency = %d, want above %d", limit, opts.Initial)
+			}
+			grown := limit
+			// latency climbing far above the baseline, with timeouts, shrinks it back to the floor
+			for range 500 {
+				limit = algo.Update(200*time.Millisecond, limit, true)
+			}
+			if limit != opts.Min {
+				t.Fatalf("limit under overload = %d (was %d), want %d", limit, grown, opts.Min)
+			}
+		})
+	}
+	if _, _, err := Build("bbr", Options{}); err != ErrorAlgorithmNotSupported {
+		t.Fatalf("unknown algorithm: %v", err)
+	}
+}
+
+func TestVegasLatency(t *testing.T) {
+	algo, _, _ := Build(Vegas, Options{Initial: 50, Min: 1, Max: 200})
+	limit := algo.Update(10*time.Millisecond, 50, false)
+	// latency doubling means half of the requests are queued
+	for range 20 {
+		limit = algo.Update(20*time.Millisecond, limit, false)
+	}
+	if limit >= 50 {
+		t.Fatalf("limit under doubled latency = %d, want below 50", limit)
+	}
+}
+
+func TestGradientLatency(t *testing.T) {
+	algo, _, _ := Build(Gradient, Options{Initial: 50, Min: 1, Max: 200})
+	limit := 50
+	for range 100 {
+		limit = algo.Update(10*time.Millisecond, limit, false)
+	}
+	grown := limit
+	for range 20 {
+		limit = algo.Update(50*time.Millisecond, limit, false)
+	}
+	if limit >= grown {
+		t.Fatalf("limit under higher latency = %d, want below %d", limit, grown)
+	}
+}
+
+func TestLimiterQueue(t *testing.T) {
+	algo, opts, _ := Build(AIMD, Options{Initial: 1, Min: 1, Max: 1, QueueSize: 2, QueueTimeout: time.Minute})
+	l := NewLimiter("route", "/test-queue", algo, opts)
+	defer l.Close()
+
+	type result struct {
+		name    string
+		release func(bool)
+		ok      bool
+	}
+	results := make(chan result, 4)
+	// enqueue starts a request and waits until it is in the queue (or was rejected)
+	enqueue := func(name string, class Class) {
+		l.mu.Lock()
+		depth := len(l.queue)
+		l.mu.Unlock()
+		go func() {
+			release, ok := l.Acquire(ctx, class)
+			results <- result{name, release, ok}
+		}()
+		for {
+			l.mu.Lock()
+			n := len(l.queue)
+			l.mu.Unlock()
+			if n != depth || len(results) > 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first, _ := l.Acquire(ctx, normal)
+	enqueue("low", low)
+	enqueue("normal", normal)
+	enqueue("high", high) // the queue is full, evicts low
+	if r := <-results; r.name != "low" || r.ok {
+		t.Fatalf("%s ok=%v, want low evicted", r.name, r.ok)
+	}
+	enqueue("low2", low) // nothing less important to evict
+	if r := <-results; r.name != "low2" || r.ok {
+		t.Fatalf("%s ok=%v, want low2 rejected", r.name, r.ok)
+	}
+
+	first(false)
+	r := <-results
+	if r.name != "high" || !r.ok {
+		t.Fatalf("%s ok=%v, want high admitted first", r.name, r.ok)
+	}
+	r.release(false)
+	if r = <-results; r.name != "normal" || !r.ok {
+		t.Fatalf("%s ok=%v, want normal admitted", r.name, r.ok)
+	}
+
+	// the slot is still taken, a waiter gives up after the queue timeout
+	l.opts.QueueTimeout = 10 * time.Millisecond
+	if _, ok := l.Acquire(ctx, high); ok {
+		t.Fatal("admitted past the limit")
+	}
+	r.release(false)
+	if _, ok := l.Acquire(ctx, high); !ok {
+		t.Fatal("not admitted after release")
+	}
+}
+
+func TestLimiterCloseKeepsNewerSeries(t *testing.T) {
+	build := func(initial int) *Limiter {
+		algo, opts, _ := Build(AIMD, Options{Initial: initial, Min: 1, Max: 10})
+		return NewLimiter("route", "/test-reload", algo, opts)
+	}
+	// a reload builds the new chain's limiter before the old chain is released
+	old := build(2)
+	release, _ := old.Acquire(ctx, normal)
+	cur := build(5)
+	defer cur.Close()
+
+	// the old limiter drains without touching the series, and closing it keeps them
+	release(true)
+	old.Close()
+	series := testutil.CollectAndCount(limitGauge)
+	if got := testutil.ToFloat64(limitGauge.WithLabelValues("route", "/test-reload")); got != 5 {
+		t.Fatalf("limit gauge after closing the old limiter = %v, want 5", got)
+	}
+	if testutil.CollectAndCount(limitGauge) != series {
+		t.Fatal("closing the old limiter removed the series of the new one")
+	}
+	r, _ := cur.Acquire(ctx, normal)
+	if got := testutil.ToFloat64(inflightGauge.WithLabelValues("route", "/test-reload")); got != 1 {
+		t.Fatalf("inflight gauge = %v, want 1", got)
+	}
+	r(false)
+
+	cur.Close()
+	if testutil.CollectAndCount(limitGauge) != series-1 {
+		t.Fatal("closing the current limiter kept its series")
+	}
+}
@@ -0,0 +1,66 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+const Gradient = "gradient"
+
+const (
+	// gradientWindow is the number of samples averaged into the long-term latency.
+	gradientWindow = 600
+	// gradientWarmup samples are averaged evenly before switching to the EMA.
+	gradientWarmup = 10
+	// gradientTolerance is how much the short-term latency may exceed the long-term one
+	// before the limit starts to shrink.
+	gradientTolerance = 1.5
+	gradientSmoothing = 0.2
+)
+
+// GradientLimit compares the latency of each request with a long-term average
+// (Netflix's Gradient2). The ratio, clamped to [0.5, 1], scales the limit down
+// as latency grows, and sqrt(limit) is added on top so the limit keeps probing
+// upwards while latency is stable.
+type GradientLimit struct {
+	opts    Options
+	limit   float64
+	longRTT float64 // seconds
+	samples int
+}
+
+func init() {
+	factories[Gradient] = NewGradient
+}
+
+// NewGradient create new Gradient algorithm
+func NewGradient(opts Options) Algorithm {
+	return &GradientLimit{opts: opts, limit: float64(opts.Initial)}
+}
+
+func (g *GradientLimit) Update(rtt time.Duration, inflight int, dropped bool) int {
+	short := rtt.Seconds()
+	g.samples++
+	if g.samples <= gradientWarmup {
+		g.longRTT += (short - g.longRTT) / float64(g.samples)
+	} else {
+		g.longRTT += (short - g.longRTT) / gradientWindow
+	}
+	// latency recovered well below the average, let the average catch up faster
+	if g.longRTT > 2*short {
+		g.longRTT *= 0.95
+	}
+	if !dropped && inflight*2 < int(g.limit) {
+		return int(g.limit)
+	}
+
+	gradient := 1.0
+	if dropped {
+		gradient = 0.5
+	} else if short > 0 {
+		gradient = max(0.5, min(1, gradientTolerance*g.longRTT/short))
+	}
+	next := g.limit*gradient + math.Sqrt(g.limit)
+	g.limit = g.opts.clamp(g.limit*(1-gradientSmoothing) + next*gradientSmoothing)
+	return int(g.limit)
+}
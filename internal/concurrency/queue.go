@@ -0,0 +1,56 @@
+package concurrency
+
+// evicted is sent to a waiter pushed out of a full queue; admitted waiters receive
+// the number of requests in flight, which is always positive.
+const evicted = -1
+
+type waiter struct {
+	class Class
+	seq   uint64
+	index int // position in the queue, -1 once it left the queue
+	ready chan int
+}
+
+// waitQueue is a heap of waiters, most important class first and FIFO within a class.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].class.Level != q[j].class.Level {
+		return q[i].class.Level < q[j].class.Level
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// last returns the waiter that would be admitted last: the newest of the least important class.
+func (q waitQueue) last() *waiter {
+	var w *waiter
+	for _, c := range q {
+		if w == nil || !q.Less(c.index, w.index) {
+			w = c
+		}
+	}
+	return w
+}
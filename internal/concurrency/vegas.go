@@ -0,0 +1,66 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+const Vegas = "vegas"
+
+// vegasProbe re-learns the no-load latency every vegasProbe*limit samples, so
+// that a permanently slower backend does not keep the limit pinned down.
+const vegasProbe = 30
+
+// VegasLimit estimates the queue at the backend as limit * (1 - rttNoLoad/rtt),
+// where rttNoLoad is the lowest latency seen, and keeps it between alpha and
+// beta, both proportional to log10(limit).
+type VegasLimit struct {
+	opts      Options
+	limit     float64
+	rttNoLoad time.Duration
+	samples   int
+}
+
+func init() {
+	factories[Vegas] = NewVegas
+}
+
+// NewVegas create new Vegas algorithm
+func NewVegas(opts Options) Algorithm {
+	return &VegasLimit{opts: opts, limit: float64(opts.Initial)}
+}
+
+func (v *VegasLimit) Update(rtt time.Duration, inflight int, dropped bool) int {
+	v.samples++
+	if v.samples >= vegasProbe*int(v.limit) {
+		v.samples = 0
+		v.rttNoLoad = rtt
+		return int(v.limit)
+	}
+	if v.rttNoLoad == 0 || rtt < v.rttNoLoad {
+		v.rttNoLoad = rtt
+		return int(v.limit)
+	}
+
+	step := max(1, math.Log10(v.limit))
+	switch {
+	case dropped:
+		v.limit -= step
+	case inflight*2 < int(v.limit):
+		// the limit is not being used, latency says nothing about it
+		return int(v.limit)
+	default:
+		queue := math.Ceil(v.limit * (1 - float64(v.rttNoLoad)/float64(rtt)))
+		alpha, beta := 3*step, 6*step
+		switch {
+		case queue <= step:
+			v.limit += beta
+		case queue < alpha:
+			v.limit += step
+		case queue > beta:
+			v.limit -= step
+		}
+	}
+	v.limit = v.opts.clamp(v.limit)
+	return int(v.limit)
+}
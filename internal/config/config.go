@@ -1,63 +1,157 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 // Global 服务全局配置
 type GlobalConfig struct {
-	ListenAddr     string   `mapstructure:"listen_addr"`
-	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	ListenAddr     string   `mapstructure:"listen_addr" json:"listen_addr,omitempty"`
+	TrustedProxies []string `mapstructure:"trusted_proxies" json:"trusted_proxies,omitempty"`
 }
 
 // MiddlewareConfig 通用中间件配置（占位，后续扩展使用）
 type MiddlewareConfig struct {
-	Enabled bool           `mapstructure:"enabled"`
-	Order   int            `mapstructure:"order"`
-	Config  map[string]any `mapstructure:"config"`
+	Enabled bool           `mapstructure:"enabled" json:"enabled,omitempty"`
+	Order   int            `mapstructure:"order" json:"order,omitempty"`
+	Config  map[string]any `mapstructure:"config" json:"config,omitempty"`
 }
 
 // RouteConfig 单条路由规则
 type RouteConfig struct {
 	// 目前仅支持前缀匹配：如 "/api/users/**" 表示匹配以 /api/users/ 开头的所有路径
-	Path    string   `mapstructure:"path"`
-	Methods []string `mapstructure:"methods"`
+	Path    string   `mapstructure:"path" json:"path,omitempty"`
+	Methods []string `mapstructure:"methods" json:"methods,omitempty"`
 	// 可选：将匹配到的前缀重写为该值（如将 /api/users/ 重写为 /users/）
-	Rewrite string `mapstructure:"rewrite"`
+	Rewrite string `mapstructure:"rewrite" json:"rewrite,omitempty"`
 	// Middlewares defines a list of middleware configurations for this specific route.
-	Middlewares []map[string]any `mapstructure:"middlewares"`
+	Middlewares []map[string]any `mapstructure:"middlewares" json:"middlewares,omitempty"`
+	// 可选：主上游没有可用节点时的兜底策略
+	Fallback *FallbackConfig `mapstructure:"fallback" json:"fallback,omitempty"`
+}
+
+// FallbackConfig 路由兜底配置
+// 优先转发到备用上游 Upstream；备用上游同样不可用（或未配置）时返回静态响应
+type FallbackConfig struct {
+	Upstream string            `mapstructure:"upstream" json:"upstream,omitempty"` // 备用上游名称
+	Status   int               `mapstructure:"status" json:"status,omitempty"`     // 静态响应状态码，默认 503
+	Headers  map[string]string `mapstructure:"headers" json:"headers,omitempty"`   // 静态响应头
+	Body     string            `mapstructure:"body" json:"body,omitempty"`         // 静态响应体
 }
 
 // UpstreamConfig 上游服务配置
 type UpstreamConfig struct {
-	Name          string        `mapstructure:"name"`
-	Scheme        string        `mapstructure:"scheme"`         // http 或 https，默认 http
-	Hosts         []string      `mapstructure:"hosts"`          // 形如 ["localhost:8081", "localhost:8082"] 或带 scheme 的完整地址
-	LoadBalancing string        `mapstructure:"load_balancing"` // round_robin/ip_hash（仅预留，当前实现 round_robin）
-	HealthCheck   string        `mapstructure:"health_check"`   // 预留
-	Routes        []RouteConfig `mapstructure:"routes"`
+	Name          string   `mapstructure:"name" json:"name,omitempty"`
+	Scheme        string   `mapstructure:"scheme" json:"scheme,omitempty"`                 // http 或 https，默认 http
+	Hosts         []string `mapstructure:"hosts" json:"hosts,omitempty"`                   // 形如 ["localhost:8081", "localhost:8082"] 或带 scheme 的完整地址
+	LoadBalancing string   `mapstructure:"load_balancing" json:"load_balancing,omitempty"` // round-robin（默认）/p2c/consistent-hash
+	HealthCheck   string   `mapstructure:"health_check" json:"health_check,omitempty"`     // 预留
+	// 可选：节点权重，键为 hosts 中的地址，未列出的节点权重为 1；仅 round-robin 使用
+	Weights map[string]int `mapstructure:"weights" json:"weights,omitempty"`
+	Routes  []RouteConfig  `mapstructure:"routes" json:"routes,omitempty"`
 }
 
 // ConfigSource 配置来源描述
 type ConfigSource struct {
-	Type     string `mapstructure:"type"`      // file|etcd|consul（当前实现 file）
-	FilePath string `mapstructure:"file_path"` // 当 type=file 时生效
-	Etcd     struct {
-		Endpoints []string `mapstructure:"endpoints"`
-		Key       string   `mapstructure:"key"`
-		Watch     bool     `mapstructure:"watch"`
-	} `mapstructure:"etcd"`
+	Type     string       `mapstructure:"type" json:"type,omitempty"`           // file|etcd|consul，默认 file
+	FilePath string       `mapstructure:"file_path" json:"file_path,omitempty"` // 当 type=file 时生效
+	File     FileConfig   `mapstructure:"file" json:"file,omitempty"`
+	Etcd     EtcdConfig   `mapstructure:"etcd" json:"etcd,omitempty"`
+	Consul   ConsulConfig `mapstructure:"consul" json:"consul,omitempty"`
+}
+
+// FileConfig 本地文件配置源
+type FileConfig struct {
+	// 监听配置文件及 include 目录的变化并自动重载
+	Watch bool `mapstructure:"watch" json:"watch,omitempty"`
+	// 合并短时间内的多次写入，默认 500ms
+	Debounce time.Duration `mapstructure:"debounce" json:"debounce,omitempty"`
+}
+
+// ConsulConfig Consul KV 配置源，key 中保存与 etcd 相同格式的配置文档
+type ConsulConfig struct {
+	Address    string        `mapstructure:"address" json:"address,omitempty"` // 默认 http://127.0.0.1:8500
+	Key        string        `mapstructure:"key" json:"key,omitempty"`
+	Watch      bool          `mapstructure:"watch" json:"watch,omitempty"`
+	Token      string        `mapstructure:"token" json:"token,omitempty"` // ACL token
+	Datacenter string        `mapstructure:"datacenter" json:"datacenter,omitempty"`
+	WaitTime   time.Duration `mapstructure:"wait_time" json:"wait_time,omitempty"` // 阻塞查询最长等待时间，默认 5m
+}
+
+// EtcdConfig etcd 配置源
+type EtcdConfig struct {
+	Endpoints []string `mapstructure:"endpoints" json:"endpoints,omitempty"`
+	Key       string   `mapstructure:"key" json:"key,omitempty"`
+	Watch     bool     `mapstructure:"watch" json:"watch,omitempty"`
+	// 可选：按资源拆分的布局，如 /gateway/ 下的 upstreams/<name>、middlewares/<name>、global；
+	// 配置后优先于 key 生效
+	Prefix string `mapstructure:"prefix" json:"prefix,omitempty"`
+
+	// 认证
+	Username string `mapstructure:"username" json:"username,omitempty"`
+	Password string `mapstructure:"password" json:"password,omitempty"`
+	// TLS：配置了任一文件即启用；仅配置 ca_file 时只校验服务端证书
+	CertFile string `mapstructure:"cert_file" json:"cert_file,omitempty"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file,omitempty"`
+	CAFile   string `mapstructure:"ca_file" json:"ca_file,omitempty"`
+	// 连接参数，形如 "5s"；dial_timeout 默认 5s，keepalive 为 0 时使用客户端默认值
+	DialTimeout      time.Duration `mapstructure:"dial_timeout" json:"dial_timeout,omitempty"`
+	KeepAliveTime    time.Duration `mapstructure:"keepalive_time" json:"keepalive_time,omitempty"`
+	KeepAliveTimeout time.Duration `mapstructure:"keepalive_timeout" json:"keepalive_timeout,omitempty"`
+	// 可选：命名空间前缀，所有 key/prefix 都相对该前缀，便于多个网关集群共用一个 etcd
+	Namespace string `mapstructure:"namespace" json:"namespace,omitempty"`
 }
 
 // GatewayConfig 网关完整配置
 type GatewayConfig struct {
-	Global       GlobalConfig                `mapstructure:"global"`
-	Middlewares  map[string]MiddlewareConfig `mapstructure:"middlewares"`
-	Upstreams    []UpstreamConfig            `mapstructure:"upstreams"`
-	ConfigSource ConfigSource                `mapstructure:"config_source"`
+	Global       GlobalConfig                `mapstructure:"global" json:"global,omitempty"`
+	Middlewares  map[string]MiddlewareConfig `mapstructure:"middlewares" json:"middlewares,omitempty"`
+	Upstreams    []UpstreamConfig            `mapstructure:"upstreams" json:"upstreams,omitempty"`
+	Consumers    []ConsumerConfig            `mapstructure:"consumers" json:"consumers,omitempty"`
+	ConfigSource ConfigSource                `mapstructure:"config_source" json:"config_source,omitempty"`
+	// 可选：片段目录（相对路径相对于主配置文件所在目录），其中的 *.yaml 按文件名顺序合并，
+	// 片段只能包含 middlewares 与 upstreams，名称不能与已有配置重复
+	Include string `mapstructure:"include" json:"include,omitempty"`
+	// 已应用配置的历史快照，用于回滚
+	History HistoryConfig `mapstructure:"history" json:"history,omitempty"`
+	// 管理接口，仅在启动时读取
+	Admin AdminConfig `mapstructure:"admin" json:"admin,omitempty"`
+}
+
+// ConsumerConfig 调用方。key_auth 中间件按 API key 识别调用方，
+// 限流、配额、ACL 与访问日志可以按调用方名称、分组与元数据区分
+type ConsumerConfig struct {
+	Name string `mapstructure:"name" json:"name"`
+	// API key 的 SHA-256 摘要，形如 "sha256:<64 位十六进制>"，可用 gateway hash-key 生成；
+	// 配置多个便于轮换
+	Keys     []string          `mapstructure:"keys" json:"keys,omitempty"`
+	Groups   []string          `mapstructure:"groups" json:"groups,omitempty"`
+	Metadata map[string]string `mapstructure:"metadata" json:"metadata,omitempty"`
 }
 
-// LoadConfig 读取并解析配置
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Enabled bool `mapstructure:"enabled" json:"enabled,omitempty"`
+	// 独立监听地址，默认 127.0.0.1:9901
+	ListenAddr string `mapstructure:"listen_addr" json:"listen_addr,omitempty"`
+	// 不使用独立监听，改为挂载到网关监听的 /admin/ 下
+	Embedded bool `mapstructure:"embedded" json:"embedded,omitempty"`
+	// 可选：Bearer token，配置后所有管理接口都需要携带；未配置时写接口、配置与配置历史接口不可用，也不能使用 embedded
+	Token string `mapstructure:"token" json:"token,omitempty"`
+}
+
+// HistoryConfig 配置历史
+type HistoryConfig struct {
+	Size int `mapstructure:"size" json:"size,omitempty"` // 保留的快照数量，默认 10
+	// 可选：同时保存到 etcd 的该前缀下（使用 config_source.etcd 的连接配置），重启后仍可查看
+	EtcdPrefix string `mapstructure:"etcd_prefix" json:"etcd_prefix,omitempty"`
+}
+
+// LoadConfig 读取并解析配置，解析前展开 ${ENV}、${ENV:-default}、${file:/path} 引用
 func LoadConfig(path string) (*GatewayConfig, error) {
 	v := viper.New()
 	v.SetConfigFile(path)
@@ -68,11 +162,99 @@ func LoadConfig(path string) (*GatewayConfig, error) {
 	}
 
 	var conf GatewayConfig
-	if err := v.Unmarshal(&conf); err != nil {
+	if _, err := decode(v, &conf); err != nil {
 		return nil, err
 	}
+	if conf.Include != "" {
+		if err := mergeIncludes(&conf, IncludeDir(path, conf.Include)); err != nil {
+			return nil, err
+		}
+	}
 	return &conf, nil
 }
 
-// 如需 etcd 动态配置，可在此补充 watch 能力
-// func WatchEtcdConfig(conf *ConfigSource, onChange func(newConf *GatewayConfig)) {}
+// ConfigOverlay 来自远程配置源（如 etcd）的配置文档。
+// 文档中出现的顶层配置段（global/middlewares/upstreams/consumers）整体覆盖本地配置，
+// 未出现的配置段保留本地文件中的值；config_source 始终以本地文件为准。
+type ConfigOverlay struct {
+	conf     GatewayConfig
+	sections map[string]bool
+	modRev   int64 // 单 key 模式下文档在 etcd 中的 ModRevision，写回时据此做比较并交换
+}
+
+// overlaySections 可由远程文档覆盖的顶层配置段
+var overlaySections = []string{"global", "middlewares", "upstreams", "consumers"}
+
+// ParseOverlay 解析 JSON 或 YAML 格式的配置文档
+func ParseOverlay(data []byte) (*ConfigOverlay, error) {
+	v, err := readDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &ConfigOverlay{sections: make(map[string]bool)}
+	if v, err = decode(v, &o.conf); err != nil {
+		return nil, err
+	}
+	for _, section := range overlaySections {
+		if v.IsSet(section) {
+			o.sections[section] = true
+		}
+	}
+	if len(o.sections) == 0 {
+		return nil, errors.New("config document contains none of global, middlewares, upstreams, consumers")
+	}
+	return o, nil
+}
+
+// DecodeDocument 将 JSON 或 YAML 文档解码到 out（按 mapstructure 标签）
+func DecodeDocument(data []byte, out any) error {
+	v, err := readDocument(data)
+	if err != nil {
+		return err
+	}
+	_, err = decode(v, out)
+	return err
+}
+
+func readDocument(data []byte) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		v.SetConfigType("json")
+	}
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewConfigOverlay 由各配置段构建文档，global 为 nil 表示不覆盖全局配置
+func NewConfigOverlay(global *GlobalConfig, middlewares map[string]MiddlewareConfig, upstreams []UpstreamConfig) *ConfigOverlay {
+	o := &ConfigOverlay{sections: map[string]bool{"middlewares": true, "upstreams": true}}
+	o.conf.Middlewares = middlewares
+	o.conf.Upstreams = upstreams
+	if global != nil {
+		o.conf.Global = *global
+		o.sections["global"] = true
+	}
+	return o
+}
+
+// Apply 将文档覆盖到 base 之上，返回新的配置，base 本身不被修改
+func (o *ConfigOverlay) Apply(base *GatewayConfig) *GatewayConfig {
+	conf := *base
+	if o.sections["global"] {
+		conf.Global = o.conf.Global
+	}
+	if o.sections["middlewares"] {
+		conf.Middlewares = o.conf.Middlewares
+	}
+	if o.sections["upstreams"] {
+		conf.Upstreams = o.conf.Upstreams
+	}
+	if o.sections["consumers"] {
+		conf.Consumers = o.conf.Consumers
+	}
+	return &conf
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterProvider("consul", newConsulProvider)
+}
+
+// consulKV is a minimal Consul KV client supporting blocking queries on a single key.
+// See https://developer.hashicorp.com/consul/api-docs/features/blocking
+type consulKV struct {
+	conf ConsulConfig
+	http *http.Client
+}
+
+// get reads the key. With index > 0 it blocks until the key's index moves past index
+// or the wait time elapses. found is false when the key does not exist.
+func (c *consulKV) get(ctx context.Context, index uint64) (value []byte, newIndex uint64, found bool, err error) {
+	q := url.Values{}
+	q.Set("raw", "")
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", c.conf.WaitTime.String())
+	}
+	if c.conf.Datacenter != "" {
+		q.Set("dc", c.conf.Datacenter)
+	}
+	u := strings.TrimSuffix(c.conf.Address, "/") + "/v1/kv/" + strings.TrimPrefix(c.conf.Key, "/") + "?" + q.Encode()
+
+	// consul adds up to wait/16 of jitter to blocking queries
+	ctx, cancel := context.WithTimeout(ctx, c.conf.WaitTime+c.conf.WaitTime/16+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if c.conf.Token != "" {
+		req.Header.Set("X-Consul-Token", c.conf.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		found = true
+	case http.StatusNotFound:
+	default:
+		return nil, 0, false, fmt.Errorf("consul: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	newIndex, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("consul: invalid X-Consul-Index: %w", err)
+	}
+	return body, newIndex, found, nil
+}
+
+// consulProvider 本地引导文件 + Consul KV 中的配置文档，通过阻塞查询监听变化
+type consulProvider struct {
+	layeredConfig
+	conf   ConsulConfig
+	kv     *consulKV
+	health *watchHealth
+
+	// reconnect backoff bounds
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	loadMu  sync.Mutex
+	fetched bool
+	index   uint64 // X-Consul-Index of the last applied document
+	last    []byte // last applied document, to skip no-op wakeups
+}
+
+func newConsulProvider(path string, bootstrap *GatewayConfig) (Provider, error) {
+	conf := bootstrap.ConfigSource.Consul
+	if conf.Key == "" {
+		return nil, errors.New("consul config source requires key")
+	}
+	if conf.Address == "" {
+		conf.Address = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(conf.Address, "://") {
+		conf.Address = "http://" + conf.Address
+	}
+	if conf.WaitTime <= 0 {
+		conf.WaitTime = 5 * time.Minute
+	}
+	return &consulProvider{
+		layeredConfig: layeredConfig{path: path, base: bootstrap},
+		conf:          conf,
+		kv:            &consulKV{conf: conf, http: &http.Client{}},
+		health:        newWatchHealth("consul"),
+		minBackoff:    500 * time.Millisecond,
+		maxBackoff:    30 * time.Second,
+	}, nil
+}
+
+// Load 重新读取本地引导文件；Consul 文档只在第一次加载时读取，之后由 Watch 保持最新
+func (p *consulProvider) Load(ctx context.Context) (*GatewayConfig, error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	if !p.fetched {
+		value, index, found, err := p.kv.get(ctx, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch config from consul: %w", err)
+		}
+		if !found {
+			return nil, fmt.Errorf("consul: key %s not found", p.conf.Key)
+		}
+		overlay, err := ParseOverlay(value)
+		if err != nil {
+			return nil, err
+		}
+		p.setOverlay(overlay)
+		p.index, p.last, p.fetched = index, value, true
+	}
+	if err := p.reloadBase(); err != nil {
+		return nil, err
+	}
+	return p.merged(), nil
+}
+
+// Watch 使用阻塞查询监听 key。与 etcd 一致：无效文档和删除 key 都保留当前配置；
+// 请求失败时按指数退避重试，index 回退（如 Consul 快照恢复）时从头开始。
+func (p *consulProvider) Watch(ctx context.Context, onChange func(*GatewayConfig)) error {
+	if !p.conf.Watch {
+		return nil
+	}
+	log.Printf("[gateway] watching consul key %s for config updates", p.conf.Key)
+	defer p.health.set(errors.New("consul watch stopped"))
+
+	backoff := p.minBackoff
+	index := p.index
+	for {
+		value, newIndex, found, err := p.kv.get(ctx, max(index, 1))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			configWatchReconnects.WithLabelValues("consul", "error").Inc()
+			p.health.set(fmt.Errorf("consul watch error: %v", err))
+			log.Printf("[gateway] consul watch on %s failed (%v), retrying in %s", p.conf.Key, err, backoff)
+			delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			backoff = min(backoff*2, p.maxBackoff)
+			continue
+		}
+		backoff = p.minBackoff
+		p.health.set(nil)
+
+		switch {
+		case newIndex < index:
+			// the index went backwards, start over
+			index = 0
+			continue
+		case newIndex == index:
+			// wait time elapsed without changes
+			continue
+		}
+		index = newIndex
+		configWatchRevision.WithLabelValues("consul").Set(float64(index))
+
+		if !found {
+			log.Printf("[gateway] consul key %s was deleted, keeping the last applied config", p.conf.Key)
+			continue
+		}
+		if bytes.Equal(value, p.last) {
+			continue
+		}
+		overlay, err := ParseOverlay(value)
+		if err != nil {
+			log.Printf("[gateway] ignoring invalid config document at consul key %s (index %d): %v", p.conf.Key, index, err)
+			continue
+		}
+		p.last = value
+		onChange(p.setOverlay(overlay))
+	}
+}
+
+// WatchHealthy reports the consul watch health; always healthy when watching is disabled.
+func (p *consulProvider) WatchHealthy() error {
+	if !p.conf.Watch {
+		return nil
+	}
+	return p.health.get()
+}
+
+func (p *consulProvider) Close() error {
+	p.kv.http.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves a single KV key with blocking query semantics.
+type fakeConsul struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every write
+	value   []byte
+	index   uint64
+	token   string
+}
+
+func newFakeConsul(token string) *fakeConsul {
+	return &fakeConsul{changed: make(chan struct{}), index: 1, token: token}
+}
+
+func (f *fakeConsul) set(value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value == "" {
+		f.value = nil
+	} else {
+		f.value = []byte(value)
+	}
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != f.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+
+	f.mu.Lock()
+	cur, changed := f.index, f.changed
+	f.mu.Unlock()
+	if index > 0 && index >= cur {
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if f.value == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(f.value)
+}
+
+func TestConsulProviderLoadAndWatch(t *testing.T) {
+	consul := newFakeConsul("secret")
+	consul.set(`{"upstreams":[{"name":"a","hosts":["a:80"]}]}`)
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	bootstrap := `
+global:
+  listen_addr: ":9000"
+config_source:
+  type: consul
+  consul:
+    address: ` + srv.URL + `
+    key: gateway/config
+    token: secret
+    watch: true
+    wait_time: 200ms
+`
+	if err := os.WriteFile(path, []byte(bootstrap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(path, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conf, err = p.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Global.ListenAddr != ":9000" || len(conf.Upstreams) != 1 || conf.Upstreams[0].Name != "a" {
+		t.Fatalf("unexpected loaded config %+v", conf)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *GatewayConfig, 4)
+	done := make(chan error, 1)
+	go func() { done <- p.Watch(ctx, func(c *GatewayConfig) { updates <- c }) }()
+
+	hc := p.(WatchHealthChecker)
+	waitFor(t, "healthy watch", func() bool { return hc.WatchHealthy() == nil })
+
+	// wait timeouts without changes, invalid documents and deletes keep the last config
+	time.Sleep(300 * time.Millisecond)
+	consul.set(`{not json`)
+	consul.set("")
+	consul.set(`{"upstreams":[{"name":"b","hosts":["b:80"]}]}`)
+	select {
+	case c := <-updates:
+		if c.Global.ListenAddr != ":9000" || len(c.Upstreams) != 1 || c.Upstreams[0].Name != "b" {
+			t.Fatalf("unexpected update %+v", c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a config update")
+	}
+	select {
+	case c := <-updates:
+		t.Fatalf("unexpected extra update %+v", c.Upstreams)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Watch returned %v, want context.Canceled", err)
+	}
+	if hc.WatchHealthy() == nil {
+		t.Fatal("stopped watch still reported healthy")
+	}
+}
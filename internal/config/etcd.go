@@ -2,71 +2,429 @@ package config
 
 import (
 	context "context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
 )
 
-// EtcdClient wraps etcd client for fetching and watching gateway upstream config.
+var (
+	// Whether the config watch is currently established (1) or reconnecting (0).
+	configWatchHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "config_watch_healthy",
+			Help:      "Whether the config source watch is established (1) or reconnecting (0).",
+		},
+		[]string{"source"},
+	)
+
+	// Count of watch reconnects, labelled by what caused them.
+	configWatchReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lens_gateway",
+			Name:      "config_watch_reconnects_total",
+			Help:      "Total number of config source watch reconnects.",
+		},
+		// reason: closed|error|compacted
+		[]string{"source", "reason"},
+	)
+
+	// Last etcd revision applied from the watch.
+	configWatchRevision = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "config_watch_revision",
+			Help:      "Last config source revision seen by the watch.",
+		},
+		[]string{"source"},
+	)
+)
+
+// EtcdClient wraps etcd client for fetching and watching gateway config.
 type EtcdClient struct {
-	cli *clientv3.Client
+	kv      clientv3.KV
+	watcher clientv3.Watcher
+	closer  func() error
+
+	// reconnect backoff bounds
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	health *watchHealth
 }
 
-func NewEtcdClient(endpoints []string) (*EtcdClient, error) {
-	cfg := clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
+// NewEtcdClient connects to etcd with the TLS, authentication, timeout and namespace
+// options from cfg.
+func NewEtcdClient(cfg EtcdConfig) (*EtcdClient, error) {
+	clientCfg := clientv3.Config{
+		Endpoints:            cfg.Endpoints,
+		DialTimeout:          cfg.DialTimeout,
+		DialKeepAliveTime:    cfg.KeepAliveTime,
+		DialKeepAliveTimeout: cfg.KeepAliveTimeout,
+		Username:             cfg.Username,
+		Password:             cfg.Password,
+	}
+	if clientCfg.DialTimeout <= 0 {
+		clientCfg.DialTimeout = 5 * time.Second
 	}
-	cli, err := clientv3.New(cfg)
+	if cfg.CertFile != "" || cfg.KeyFile != "" || cfg.CAFile != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      cfg.CertFile,
+			KeyFile:       cfg.KeyFile,
+			TrustedCAFile: cfg.CAFile,
+		}
+		tlsCfg, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("etcd tls: %w", err)
+		}
+		clientCfg.TLS = tlsCfg
+	}
+	cli, err := clientv3.New(clientCfg)
 	if err != nil {
 		return nil, err
 	}
-	return &EtcdClient{cli: cli}, nil
+	if cfg.Namespace != "" {
+		return newEtcdClient(namespace.NewKV(cli.KV, cfg.Namespace), namespace.NewWatcher(cli.Watcher, cfg.Namespace), cli.Close), nil
+	}
+	return newEtcdClient(cli, cli, cli.Close), nil
 }
 
-// FetchUpstreams reads upstreams JSON from the given key and unmarshals to []UpstreamConfig.
-// Expected JSON shape: {"upstreams": [ ... UpstreamConfig ... ]}
-func (e *EtcdClient) FetchUpstreams(ctx context.Context, key string) ([]UpstreamConfig, error) {
-	resp, err := e.cli.Get(ctx, key)
+func newEtcdClient(kv clientv3.KV, watcher clientv3.Watcher, closer func() error) *EtcdClient {
+	return &EtcdClient{
+		kv:         kv,
+		watcher:    watcher,
+		closer:     closer,
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		health:     newWatchHealth("etcd"),
+	}
+}
+
+// Close closes the underlying etcd connection.
+func (e *EtcdClient) Close() error {
+	return e.closer()
+}
+
+// WatchHealthy reports nil while the watch is established, otherwise why it is not.
+// It backs the gateway readiness check.
+func (e *EtcdClient) WatchHealthy() error {
+	return e.health.get()
+}
+
+// errKeyNotFound is returned by FetchConfig when the config key does not exist.
+var errKeyNotFound = errors.New("etcd: key not found")
+
+// FetchConfig reads the gateway config document (JSON or YAML) stored at key and
+// returns it with the etcd revision it was read at.
+// A document may hold any of global, middlewares and upstreams; the legacy
+// shape {"upstreams": [...]} is simply a document with only upstreams.
+// When the key is missing (errKeyNotFound) or its document is invalid, the revision
+// is still returned, so a watch can resume from there.
+func (e *EtcdClient) FetchConfig(ctx context.Context, key string) (*ConfigOverlay, int64, error) {
+	resp, err := e.kv.Get(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if len(resp.Kvs) == 0 {
-		return nil, errors.New("etcd: key not found")
-	}
-	var payload struct {
-		Upstreams []UpstreamConfig `json:"upstreams"`
+		return nil, resp.Header.Revision, errKeyNotFound
 	}
-	if err := json.Unmarshal(resp.Kvs[0].Value, &payload); err != nil {
-		return nil, err
+	overlay, err := ParseOverlay(resp.Kvs[0].Value)
+	if err != nil {
+		return nil, resp.Header.Revision, err
 	}
-	return payload.Upstreams, nil
+	overlay.modRev = resp.Kvs[0].ModRevision
+	return overlay, resp.Header.Revision, nil
 }
 
-// WatchUpstreams watches the key and calls onUpdate whenever upstreams change.
-func (e *EtcdClient) WatchUpstreams(ctx context.Context, key string, onUpdate func([]UpstreamConfig)) error {
-	w := e.cli.Watch(ctx, key)
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case ev, ok := <-w:
-			if !ok {
-				return errors.New("etcd watch closed")
+// WatchConfig watches the key from revision rev+1 and calls onUpdate whenever a valid
+// document is written. Documents that fail to parse are logged and skipped, keeping
+// the current config. Deleting the key also keeps the last applied config, since an
+// empty gateway is never what the operator wants; write a new document to change it.
+// The same holds when a refetch after compaction finds the key deleted or invalid.
+// The watch survives disconnects, see watchLoop.
+func (e *EtcdClient) WatchConfig(ctx context.Context, key string, rev int64, onUpdate func(*ConfigOverlay)) error {
+	return e.watchLoop(ctx, key, nil, rev,
+		func(ctx context.Context) (int64, error) {
+			overlay, rev, err := e.FetchConfig(ctx, key)
+			switch {
+			case errors.Is(err, errKeyNotFound):
+				log.Printf("[gateway] etcd key %s no longer exists (rev %d), keeping the last applied config", key, rev)
+				return rev, nil
+			case err != nil && rev != 0:
+				log.Printf("[gateway] ignoring invalid config document at etcd key %s (rev %d): %v", key, rev, err)
+				return rev, nil
+			case err != nil:
+				return 0, err
 			}
-			for _, evi := range ev.Events {
+			onUpdate(overlay)
+			return rev, nil
+		},
+		func(resp clientv3.WatchResponse) {
+			for _, evi := range resp.Events {
 				if evi.Kv == nil {
 					continue
 				}
-				var payload struct {
-					Upstreams []UpstreamConfig `json:"upstreams"`
+				if evi.Type == clientv3.EventTypeDelete {
+					log.Printf("[gateway] etcd key %s was deleted (rev %d), keeping the last applied config", key, evi.Kv.ModRevision)
+					continue
 				}
-				if err := json.Unmarshal(evi.Kv.Value, &payload); err == nil && payload.Upstreams != nil {
-					onUpdate(payload.Upstreams)
+				overlay, err := ParseOverlay(evi.Kv.Value)
+				if err != nil {
+					log.Printf("[gateway] ignoring invalid config document at etcd key %s (rev %d): %v", key, evi.Kv.ModRevision, err)
+					continue
 				}
+				overlay.modRev = evi.Kv.ModRevision
+				onUpdate(overlay)
+			}
+		})
+}
+
+// watchLoop keeps a watch on key alive until ctx is done:
+//   - every watch starts at the revision after the last one seen, so no event is lost
+//     or replayed across reconnects;
+//   - when that revision has been compacted away, refetch reads the full state again
+//     and the watch resumes from the revision it was read at;
+//   - closed channels and watch errors reconnect with exponential backoff and jitter.
+//
+// Watch health is exposed through WatchHealthy and the config_watch_* metrics.
+func (e *EtcdClient) watchLoop(ctx context.Context, key string, opts []clientv3.OpOption, rev int64,
+	refetch func(context.Context) (int64, error), handle func(clientv3.WatchResponse)) error {
+	backoff := e.minBackoff
+	defer e.health.set(errors.New("etcd watch stopped"))
+
+	for {
+		reason, err := e.watchOnce(ctx, key, opts, &rev, refetch, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if reason == "compacted" && err == nil {
+			// full state reloaded, resume right away
+			configWatchReconnects.WithLabelValues("etcd", reason).Inc()
+			continue
+		}
+		if reason == "" {
+			// the watch was healthy for a while; start over with the shortest delay
+			backoff = e.minBackoff
+			reason = "closed"
+		}
+		configWatchReconnects.WithLabelValues("etcd", reason).Inc()
+		e.health.set(fmt.Errorf("etcd watch %s: %v", reason, err))
+		log.Printf("[gateway] etcd watch on %s interrupted (%s: %v), reconnecting in %s", key, reason, err, backoff)
+
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, e.maxBackoff)
+	}
+}
+
+// watchOnce runs a single watch until it breaks. It returns an empty reason when
+// the watch had been established and delivered responses before it was closed.
+func (e *EtcdClient) watchOnce(ctx context.Context, key string, opts []clientv3.OpOption, rev *int64,
+	refetch func(context.Context) (int64, error), handle func(clientv3.WatchResponse)) (string, error) {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	watchOpts := append([]clientv3.OpOption{
+		clientv3.WithRev(*rev + 1),
+		clientv3.WithCreatedNotify(),
+		clientv3.WithProgressNotify(),
+	}, opts...)
+	established := false
+	for resp := range e.watcher.Watch(wctx, key, watchOpts...) {
+		if resp.CompactRevision != 0 {
+			// the revision we wanted is gone: reload everything and resume from there
+			log.Printf("[gateway] etcd revision %d of %s was compacted (now %d), refetching", *rev+1, key, resp.CompactRevision)
+			newRev, err := refetch(ctx)
+			if err != nil {
+				return "compacted", err
+			}
+			e.setRevision(rev, newRev)
+			return "compacted", nil
+		}
+		if err := resp.Err(); err != nil {
+			return "error", err
+		}
+		if !established {
+			established = true
+			e.health.set(nil)
+		}
+		switch {
+		case len(resp.Events) > 0:
+			handle(resp)
+			// a catching-up watch may deliver old events in several batches, so the
+			// header revision is not safe to resume from; the last event is
+			e.setRevision(rev, resp.Events[len(resp.Events)-1].Kv.ModRevision)
+		case resp.IsProgressNotify():
+			// progress notifications are only sent once the watch is caught up
+			e.setRevision(rev, resp.Header.Revision)
+		}
+	}
+	if established {
+		return "", errors.New("etcd watch closed")
+	}
+	return "closed", errors.New("etcd watch closed before it was established")
+}
+
+func (e *EtcdClient) setRevision(rev *int64, newRev int64) {
+	if newRev > *rev {
+		*rev = newRev
+		configWatchRevision.WithLabelValues("etcd").Set(float64(newRev))
+	}
+}
+
+func init() {
+	RegisterProvider("etcd", newEtcdProvider)
+}
+
+// etcdProvider 本地引导文件 + etcd 中的配置文档（单 key 或前缀布局）
+type etcdProvider struct {
+	layeredConfig
+	conf EtcdConfig
+	cli  *EtcdClient
+
+	loadMu      sync.Mutex
+	fetched     bool         // 是否已完成初次读取
+	rev         int64        // 单 key 模式下初次读取时的 revision
+	prefixState *PrefixState // 前缀布局
+}
+
+func newEtcdProvider(path string, bootstrap *GatewayConfig) (Provider, error) {
+	conf := bootstrap.ConfigSource.Etcd
+	if len(conf.Endpoints) == 0 {
+		return nil, errors.New("etcd config source requires endpoints")
+	}
+	if conf.Key == "" && conf.Prefix == "" {
+		return nil, errors.New("etcd config source requires key or prefix")
+	}
+	cli, err := NewEtcdClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdProvider{
+		layeredConfig: layeredConfig{path: path, base: bootstrap},
+		conf:          conf,
+		cli:           cli,
+	}, nil
+}
+
+// Load 重新读取本地引导文件；etcd 文档只在第一次加载时读取，之后由 Watch 保持最新
+func (p *etcdProvider) Load(ctx context.Context) (*GatewayConfig, error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	if !p.fetched {
+		var overlay *ConfigOverlay
+		var err error
+		if p.conf.Prefix != "" {
+			p.prefixState, err = p.cli.FetchPrefix(ctx, p.conf.Prefix)
+			if err == nil {
+				overlay = p.prefixState.Overlay()
 			}
+		} else {
+			overlay, p.rev, err = p.cli.FetchConfig(ctx, p.conf.Key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch config from etcd: %w", err)
 		}
+		p.setOverlay(overlay)
+		p.fetched = true
+	}
+	if err := p.reloadBase(); err != nil {
+		return nil, err
+	}
+	return p.merged(), nil
+}
+
+func (p *etcdProvider) Watch(ctx context.Context, onChange func(*GatewayConfig)) error {
+	if !p.conf.Watch {
+		return nil
+	}
+	onUpdate := func(overlay *ConfigOverlay) {
+		onChange(p.setOverlay(overlay))
+	}
+	if p.prefixState != nil {
+		log.Printf("[gateway] watching etcd prefix %s for config updates", p.conf.Prefix)
+		return p.cli.WatchPrefix(ctx, p.prefixState, onUpdate)
+	}
+	log.Printf("[gateway] watching etcd key %s for config updates", p.conf.Key)
+	return p.cli.WatchConfig(ctx, p.conf.Key, p.rev, onUpdate)
+}
+
+// WriteUpstreams writes the upstreams back to etcd: one key per upstream with the prefix
+// layout, otherwise the upstreams section of the config document is replaced in place.
+// Either way the write is a compare-and-swap on the revisions the gateway last applied,
+// so an etcd edit the watch has not delivered yet is never overwritten. When the upstreams
+// come from the local file instead, ErrUpstreamsNotOwned is returned rather than moving
+// them into etcd.
+func (p *etcdProvider) WriteUpstreams(ctx context.Context, upstreams []UpstreamConfig) error {
+	if p.prefixState != nil {
+		return p.cli.PutUpstreams(ctx, p.prefixState, upstreams)
+	}
+	p.mu.Lock()
+	var applied int64
+	owned := false
+	if p.overlay != nil {
+		applied, owned = p.overlay.modRev, p.overlay.sections["upstreams"]
+	}
+	p.mu.Unlock()
+	if !owned {
+		return fmt.Errorf("%w: %s has no upstreams section", ErrUpstreamsNotOwned, p.conf.Key)
+	}
+
+	resp, err := p.cli.kv.Get(ctx, p.conf.Key)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	var modRev int64
+	if len(resp.Kvs) > 0 {
+		data, modRev = resp.Kvs[0].Value, resp.Kvs[0].ModRevision
 	}
+	if modRev != applied {
+		return fmt.Errorf("%w: %s changed at revision %d, the gateway last applied revision %d", ErrRevisionConflict, p.conf.Key, modRev, applied)
+	}
+	out, err := rewriteUpstreams(data, upstreams, nil)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite config document at %s: %w", p.conf.Key, err)
+	}
+	txn, err := p.cli.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(p.conf.Key), "=", applied)).
+		Then(clientv3.OpPut(p.conf.Key, string(out))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !txn.Succeeded {
+		return fmt.Errorf("%w: %s", ErrRevisionConflict, p.conf.Key)
+	}
+	// reflect the write right away, the watch (if any) will deliver the same document
+	if overlay, err := ParseOverlay(out); err == nil {
+		overlay.modRev = txn.Header.Revision
+		p.setOverlay(overlay)
+	}
+	return nil
+}
+
+// WatchHealthy reports the etcd watch health; always healthy when watching is disabled.
+func (p *etcdProvider) WatchHealthy() error {
+	if !p.conf.Watch {
+		return nil
+	}
+	return p.cli.WatchHealthy()
+}
+
+func (p *etcdProvider) Close() error {
+	return p.cli.Close()
 }
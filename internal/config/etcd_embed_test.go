@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// testEtcd is a single-member etcd server running in the test process.
+type testEtcd struct {
+	t         *testing.T
+	dir       string
+	clientURL url.URL
+	peerURL   url.URL
+	srv       *embed.Etcd
+}
+
+// startEtcd starts an embedded etcd on free local ports with its data in a temp dir.
+func startEtcd(t *testing.T) *testEtcd {
+	t.Helper()
+	e := &testEtcd{t: t, dir: t.TempDir(), clientURL: freeURL(t), peerURL: freeURL(t)}
+	e.start()
+	t.Cleanup(e.stop)
+	return e
+}
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+func (e *testEtcd) start() {
+	e.t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = e.dir
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{e.clientURL}, []url.URL{e.clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{e.peerURL}, []url.URL{e.peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, e.peerURL.String())
+	srv, err := embed.StartEtcd(cfg)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	select {
+	case <-srv.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		srv.Close()
+		e.t.Fatal("embedded etcd did not become ready")
+	}
+	e.srv = srv
+}
+
+func (e *testEtcd) stop() {
+	if e.srv != nil {
+		e.srv.Close()
+		e.srv = nil
+	}
+}
+
+// restart stops the server and starts it again on the same ports and data.
+func (e *testEtcd) restart() {
+	e.t.Helper()
+	e.stop()
+	e.start()
+}
+
+// client returns a new client for the server, closed when the test ends.
+func (e *testEtcd) client() *clientv3.Client {
+	e.t.Helper()
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{e.clientURL.String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// gatedWatcher creates real etcd watches, but lets the test cut them off and hold back
+// new ones, so that writes and compactions can happen while the gateway is not watching.
+type gatedWatcher struct {
+	cli *clientv3.Client
+
+	mu   sync.Mutex
+	cur  clientv3.Watcher
+	gate chan struct{} // closed while watches may be created
+}
+
+func newGatedWatcher(cli *clientv3.Client) *gatedWatcher {
+	gate := make(chan struct{})
+	close(gate)
+	return &gatedWatcher{cli: cli, cur: clientv3.NewWatcher(cli), gate: gate}
+}
+
+func (g *gatedWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	g.mu.Lock()
+	gate := g.gate
+	g.mu.Unlock()
+	select {
+	case <-gate:
+	case <-ctx.Done():
+		ch := make(chan clientv3.WatchResponse)
+		close(ch)
+		return ch
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cur.Watch(ctx, key, opts...)
+}
+
+// cut closes the current watches and blocks new ones until open is called.
+func (g *gatedWatcher) cut() {
+	g.mu.Lock()
+	old := g.cur
+	g.cur = clientv3.NewWatcher(g.cli)
+	g.gate = make(chan struct{})
+	g.mu.Unlock()
+	old.Close()
+}
+
+func (g *gatedWatcher) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	close(g.gate)
+}
+
+func (g *gatedWatcher) RequestProgress(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cur.RequestProgress(ctx)
+}
+
+func (g *gatedWatcher) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cur.Close()
+}
+
+// expectNoUpdate fails if an update arrives within a short grace period.
+func expectNoUpdate(t *testing.T, updates chan *ConfigOverlay) {
+	t.Helper()
+	select {
+	case o := <-updates:
+		t.Fatalf("unexpected update %+v", o.conf)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func upstreamNames(o *ConfigOverlay) []string {
+	var names []string
+	for _, up := range o.conf.Upstreams {
+		names = append(names, up.Name)
+	}
+	return names
+}
+
+func TestEtcdWatchAgainstServer(t *testing.T) {
+	const key = "/gw/config"
+	srv := startEtcd(t)
+	cli := srv.client()
+	writer := srv.client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doc := func(name string) string { return fmt.Sprintf(`{"upstreams":[{"name":%q}]}`, name) }
+
+	if _, err := writer.Put(ctx, key, doc("a")); err != nil {
+		t.Fatal(err)
+	}
+	w := newGatedWatcher(cli)
+	e := newEtcdClient(cli, w, func() error { return nil })
+	e.minBackoff, e.maxBackoff = time.Millisecond, 50*time.Millisecond
+	overlay, rev, err := e.FetchConfig(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(overlay); len(names) != 1 || names[0] != "a" {
+		t.Fatalf("fetched upstreams %v", names)
+	}
+
+	updates := make(chan *ConfigOverlay, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.WatchConfig(ctx, key, rev, func(o *ConfigOverlay) { updates <- o })
+	}()
+	waitFor(t, "healthy watch", func() bool { return e.WatchHealthy() == nil })
+
+	if _, err := writer.Put(ctx, key, doc("b")); err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "b" {
+		t.Fatalf("upstreams after put %v", names)
+	}
+
+	// deleting the key keeps the last applied config
+	if _, err := writer.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	expectNoUpdate(t, updates)
+
+	// a server restart is survived and the watch picks up later writes exactly once
+	srv.restart()
+	if _, err := writer.Put(ctx, key, doc("c")); err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "c" {
+		t.Fatalf("upstreams after restart %v", names)
+	}
+	waitFor(t, "healthy watch after restart", func() bool { return e.WatchHealthy() == nil })
+	expectNoUpdate(t, updates)
+
+	// writes made while the watch is down are delivered when it resumes
+	w.cut()
+	waitFor(t, "unhealthy watch", func() bool { return e.WatchHealthy() != nil })
+	if _, err := writer.Put(ctx, key, doc("d")); err != nil {
+		t.Fatal(err)
+	}
+	w.open()
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "d" {
+		t.Fatalf("upstreams after reconnect %v", names)
+	}
+	expectNoUpdate(t, updates)
+
+	// when the missed revisions are compacted away, the key is refetched instead
+	w.cut()
+	waitFor(t, "unhealthy watch", func() bool { return e.WatchHealthy() != nil })
+	for _, name := range []string{"e", "f"} {
+		if _, err := writer.Put(ctx, key, doc(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := writer.Put(ctx, "/other", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+	w.open()
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "f" {
+		t.Fatalf("upstreams after compaction %v", names)
+	}
+	expectNoUpdate(t, updates)
+	waitFor(t, "healthy watch after compaction", func() bool { return e.WatchHealthy() == nil })
+	if _, err := writer.Put(ctx, key, doc("g")); err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "g" {
+		t.Fatalf("upstreams after compaction and put %v", names)
+	}
+
+	// a key deleted while the watch is down and then compacted away is a delete as well
+	w.cut()
+	waitFor(t, "unhealthy watch", func() bool { return e.WatchHealthy() != nil })
+	if _, err := writer.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = writer.Put(ctx, "/other", "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	w.open()
+	waitFor(t, "healthy watch after deletion and compaction", func() bool { return e.WatchHealthy() == nil })
+	expectNoUpdate(t, updates)
+	if _, err := writer.Put(ctx, key, doc("h")); err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(nextUpdate(t, updates)); names[0] != "h" {
+		t.Fatalf("upstreams after re-creating the key %v", names)
+	}
+
+	cancel()
+	<-done
+	if strings.Contains(logs.String(), "interrupted") {
+		t.Fatalf("the refetch of a deleted key was retried:\n%s", logs.String())
+	}
+}
+
+func TestEtcdPrefixWatchAgainstServer(t *testing.T) {
+	srv := startEtcd(t)
+	cli := srv.client()
+	writer := srv.client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	put := func(key, value string) {
+		t.Helper()
+		if _, err := writer.Put(ctx, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("/gw/upstreams/a", `{"hosts":["a:80"]}`)
+	put("/gw/upstreams/b", `{"hosts":["b:80"]}`)
+	w := newGatedWatcher(cli)
+	e := newEtcdClient(cli, w, func() error { return nil })
+	e.minBackoff, e.maxBackoff = time.Millisecond, 50*time.Millisecond
+	state, err := e.FetchPrefix(ctx, "/gw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan *ConfigOverlay, 8)
+	go e.WatchPrefix(ctx, state, func(o *ConfigOverlay) { updates <- o })
+	waitFor(t, "healthy watch", func() bool { return e.WatchHealthy() == nil })
+
+	// deleting one resource key removes only that upstream
+	if _, err := writer.Delete(ctx, "/gw/upstreams/a"); err != nil {
+		t.Fatal(err)
+	}
+	if names := upstreamNames(nextUpdate(t, updates)); len(names) != 1 || names[0] != "b" {
+		t.Fatalf("upstreams after delete %v", names)
+	}
+
+	// deleting the whole prefix keeps the last applied config
+	if _, err := writer.Delete(ctx, "/gw/", clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	expectNoUpdate(t, updates)
+
+	// a compacted resume rebuilds the state from a full read
+	w.cut()
+	waitFor(t, "unhealthy watch", func() bool { return e.WatchHealthy() != nil })
+	put("/gw/upstreams/c", `{"hosts":["c:80"]}`)
+	put("/gw/upstreams/d", `{"hosts":["d:80"]}`)
+	resp, err := writer.Delete(ctx, "/gw/upstreams/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+	w.open()
+	if names := upstreamNames(nextUpdate(t, updates)); len(names) != 1 || names[0] != "d" {
+		t.Fatalf("upstreams after compaction %v", names)
+	}
+	if state.Revision() != resp.Header.Revision {
+		t.Fatalf("state revision = %d, want %d", state.Revision(), resp.Header.Revision)
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrSnapshotExists is returned by the etcd SnapshotStore when another gateway sharing the
+// prefix has already saved a snapshot with the same version.
+var ErrSnapshotExists = errors.New("config snapshot already saved by another gateway")
+
+// etcdSnapshotStore keeps config snapshots under a prefix, one key per version:
+//
+//	<prefix><version, zero padded>   Snapshot as JSON
+//
+// Resolved ${...} values and secret fields written literally (tokens, passwords, secret
+// keys) are redacted before writing, so secrets never reach etcd;
+// snapshots that needed redaction are marked and cannot be rolled back to after a restart.
+// A version key is only ever created, never overwritten: when gateways share the prefix,
+// the first one to save a version keeps it and the others get ErrSnapshotExists.
+type etcdSnapshotStore struct {
+	cli    *EtcdClient
+	prefix string
+	keep   int
+}
+
+// SnapshotStore returns a SnapshotStore keeping the newest keep snapshots under prefix.
+func (e *EtcdClient) SnapshotStore(prefix string, keep int) SnapshotStore {
+	if keep <= 0 {
+		keep = 10
+	}
+	return &etcdSnapshotStore{cli: e, prefix: NormalizePrefix(prefix), keep: keep}
+}
+
+func (s *etcdSnapshotStore) key(version int64) string {
+	return fmt.Sprintf("%s%020d", s.prefix, version)
+}
+
+func (s *etcdSnapshotStore) Save(ctx context.Context, snap Snapshot) error {
+	conf, redacted, err := redactConfig(snap.Config)
+	if err != nil {
+		return err
+	}
+	snap.Redacted = snap.Redacted || redacted
+	snap.Config = nil
+	data, err := json.Marshal(struct {
+		Snapshot
+		Config json.RawMessage `json:"config"`
+	}{snap, conf})
+	if err != nil {
+		return err
+	}
+	key := s.key(snap.Version)
+	resp, err := s.cli.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("%w: version %d at %s", ErrSnapshotExists, snap.Version, key)
+	}
+
+	// prune the oldest snapshots beyond keep
+	keys, err := s.cli.kv.Get(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(keys.Kvs)-s.keep; i++ {
+		if _, err := s.cli.kv.Delete(ctx, string(keys.Kvs[i].Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *etcdSnapshotStore) Load(ctx context.Context) ([]Snapshot, error) {
+	resp, err := s.cli.kv.Get(ctx, s.prefix, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]Snapshot, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var snap Snapshot
+		if err := json.Unmarshal(kv.Value, &snap); err != nil {
+			log.Printf("[gateway] ignoring invalid config snapshot at etcd key %s: %v", kv.Key, err)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+// redactConfig encodes conf as JSON with every resolved ${...} value and every non-empty
+// secret field replaced by "******", and reports whether anything was replaced. Values are
+// redacted before encoding, so JSON escaping cannot hide a secret from Redact.
+func redactConfig(conf *GatewayConfig) (json.RawMessage, bool, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, false, err
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, false, err
+	}
+	redacted := false
+	var walk func(key string, v any) any
+	walk = func(key string, v any) any {
+		switch x := v.(type) {
+		case string:
+			r := Redact(x)
+			if x != "" && isSecretField(key) {
+				r = "******"
+			}
+			redacted = redacted || r != x
+			return r
+		case map[string]any:
+			for k, val := range x {
+				x[k] = walk(k, val)
+			}
+		case []any:
+			for i, val := range x {
+				x[i] = walk(key, val)
+			}
+		}
+		return v
+	}
+	data, err = json.Marshal(walk("", v))
+	return data, redacted, err
+}
+
+// isSecretField reports whether a config key holds a secret, e.g. admin.token,
+// config_source.etcd.password or the secret_key and redis_password of middlewares.
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	return key == "token" || strings.HasSuffix(key, "password") ||
+		strings.HasSuffix(key, "secret") || strings.HasSuffix(key, "secret_key")
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdSnapshotStoreSharedPrefix(t *testing.T) {
+	srv := startEtcd(t)
+	ctx := context.Background()
+	// two gateways sharing the history prefix, each counting versions from 1
+	a := newEtcdClient(srv.client(), nil, func() error { return nil }).SnapshotStore("/gw/history", 10)
+	b := newEtcdClient(srv.client(), nil, func() error { return nil }).SnapshotStore("/gw/history", 10)
+
+	if err := a.Save(ctx, Snapshot{Version: 1, Source: "gateway-a", Config: &GatewayConfig{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Save(ctx, Snapshot{Version: 1, Source: "gateway-b", Config: &GatewayConfig{}}); !errors.Is(err, ErrSnapshotExists) {
+		t.Fatalf("saving an existing version = %v, want ErrSnapshotExists", err)
+	}
+	if err := b.Save(ctx, Snapshot{Version: 2, Source: "gateway-b", Config: &GatewayConfig{}}); err != nil {
+		t.Fatal(err)
+	}
+	snaps, err := a.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].Source != "gateway-a" || snaps[1].Source != "gateway-b" {
+		t.Fatalf("loaded snapshots %+v", snaps)
+	}
+}
+
+func TestEtcdSnapshotStoreRedacts(t *testing.T) {
+	t.Setenv("HIST_SECRET", `p<a&s"s>\word`)
+	resolved, err := Interpolate("${HIST_SECRET}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := startEtcd(t)
+	ctx := context.Background()
+	store := newEtcdClient(srv.client(), nil, func() error { return nil }).SnapshotStore("/gw/history", 10)
+	conf := &GatewayConfig{
+		Middlewares: map[string]MiddlewareConfig{
+			"jwt":          {Enabled: true, Config: map[string]any{"secret_key": "literal-jwt-key", "token_lookup": "header:Authorization"}},
+			"rate_limiter": {Enabled: true, Config: map[string]any{"redis_addr": "redis:6379", "redis_password": resolved}},
+		},
+		ConfigSource: ConfigSource{Etcd: EtcdConfig{Username: "gw", Password: "literal-etcd-pass"}},
+		Admin:        AdminConfig{Token: "literal-admin-token"},
+	}
+	if err := store.Save(ctx, Snapshot{Version: 1, Config: conf}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.client().Get(ctx, "/gw/history/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := string(resp.Kvs[0].Value)
+	for _, secret := range []string{"p<a", `p\u003ca`, "literal-jwt-key", "literal-etcd-pass", "literal-admin-token"} {
+		if strings.Contains(stored, secret) {
+			t.Errorf("stored snapshot contains %q: %s", secret, stored)
+		}
+	}
+	for _, kept := range []string{"header:Authorization", "redis:6379", `"username":"gw"`} {
+		if !strings.Contains(stored, kept) {
+			t.Errorf("stored snapshot lacks %q: %s", kept, stored)
+		}
+	}
+	snaps, err := store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || !snaps[0].Redacted {
+		t.Fatalf("loaded snapshots %+v, want one marked as redacted", snaps)
+	}
+	if conf.Admin.Token != "literal-admin-token" {
+		t.Fatal("saving redacted the live config")
+	}
+}
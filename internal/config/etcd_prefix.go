@@ -0,0 +1,424 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.yaml.in/yaml/v3"
+)
+
+// Prefix layout (config_source.etcd.prefix) stores one key per resource:
+//
+//	<prefix>global             GlobalConfig
+//	<prefix>upstreams/<name>   UpstreamConfig
+//	<prefix>middlewares/<name> MiddlewareConfig
+//	<prefix>consumers/<name>   ConsumerConfig
+//
+// Values are JSON or YAML documents. Teams edit their own keys independently,
+// and writers use ModRevision compare-and-swap so concurrent edits never
+// silently overwrite each other. With this layout etcd owns the upstreams section
+// entirely; global is only overridden when its key exists, and middlewares and
+// consumers only when at least one key of their kind exists.
+
+// ErrRevisionConflict is returned when a compare-and-swap write loses to a concurrent writer.
+var ErrRevisionConflict = errors.New("etcd: revision conflict")
+
+const (
+	prefixGlobal      = "global"
+	prefixUpstreams   = "upstreams/"
+	prefixMiddlewares = "middlewares/"
+	prefixConsumers   = "consumers/"
+)
+
+// NormalizePrefix makes sure the prefix ends with "/" so that "/gateway" never matches "/gateway2/...".
+func NormalizePrefix(prefix string) string {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// UpstreamKey returns the key holding the named upstream under prefix.
+func UpstreamKey(prefix, name string) string {
+	return NormalizePrefix(prefix) + prefixUpstreams + name
+}
+
+// MiddlewareKey returns the key holding the named middleware under prefix.
+func MiddlewareKey(prefix, name string) string {
+	return NormalizePrefix(prefix) + prefixMiddlewares + name
+}
+
+// ConsumerKey returns the key holding the named consumer under prefix.
+func ConsumerKey(prefix, name string) string {
+	return NormalizePrefix(prefix) + prefixConsumers + name
+}
+
+// GlobalKey returns the key holding the global section under prefix.
+func GlobalKey(prefix string) string {
+	return NormalizePrefix(prefix) + prefixGlobal
+}
+
+// PrefixState is the in-memory view of a prefix layout, kept up to date by WatchPrefix.
+type PrefixState struct {
+	mu          sync.RWMutex
+	prefix      string
+	revision    int64 // etcd revision the state reflects
+	global      *GlobalConfig
+	upstreams   map[string]UpstreamConfig
+	middlewares map[string]MiddlewareConfig
+	consumers   map[string]ConsumerConfig
+	modRevs     map[string]int64  // key -> ModRevision
+	rawUps      map[string][]byte // upstream name -> value as stored, with its ${...} references
+}
+
+func newPrefixState(prefix string) *PrefixState {
+	return &PrefixState{
+		prefix:      NormalizePrefix(prefix),
+		upstreams:   make(map[string]UpstreamConfig),
+		middlewares: make(map[string]MiddlewareConfig),
+		consumers:   make(map[string]ConsumerConfig),
+		modRevs:     make(map[string]int64),
+		rawUps:      make(map[string][]byte),
+	}
+}
+
+// Revision returns the etcd revision the state reflects.
+func (s *PrefixState) Revision() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+// ModRevision returns the ModRevision of key, or 0 when the key does not exist.
+// Callers pass it back to PutResource/DeleteResource for compare-and-swap.
+func (s *PrefixState) ModRevision(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modRevs[key]
+}
+
+// Overlay builds a config document from the current state. Upstreams and consumers are sorted by name.
+// Sections without any key under the prefix are left out, so the file's values apply.
+func (s *PrefixState) Overlay() *ConfigOverlay {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.upstreams))
+	for name := range s.upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	upstreams := make([]UpstreamConfig, 0, len(names))
+	for _, name := range names {
+		upstreams = append(upstreams, s.upstreams[name])
+	}
+	middlewares := make(map[string]MiddlewareConfig, len(s.middlewares))
+	for name, mw := range s.middlewares {
+		middlewares[name] = mw
+	}
+	var global *GlobalConfig
+	if s.global != nil {
+		g := *s.global
+		global = &g
+	}
+	o := NewConfigOverlay(global, middlewares, upstreams)
+	if len(middlewares) == 0 {
+		// without any middleware key the file's middlewares stay in effect
+		delete(o.sections, "middlewares")
+		o.conf.Middlewares = nil
+	}
+	if len(s.consumers) > 0 {
+		names = names[:0]
+		for name := range s.consumers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			o.conf.Consumers = append(o.conf.Consumers, s.consumers[name])
+		}
+		o.sections["consumers"] = true
+	}
+	return o
+}
+
+// apply updates a single key. An invalid value leaves the previous value of that key in place.
+func (s *PrefixState) apply(key string, value []byte, modRev int64, deleted bool) error {
+	rel, ok := strings.CutPrefix(key, s.prefix)
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case rel == prefixGlobal:
+		if deleted {
+			s.global = nil
+			break
+		}
+		var g GlobalConfig
+		if err := DecodeDocument(value, &g); err != nil {
+			return err
+		}
+		s.global = &g
+	case strings.HasPrefix(rel, prefixUpstreams):
+		name := strings.TrimPrefix(rel, prefixUpstreams)
+		if deleted {
+			delete(s.upstreams, name)
+			delete(s.rawUps, name)
+			break
+		}
+		var up UpstreamConfig
+		if err := decodeUpstream(value, &up); err != nil {
+			return err
+		}
+		// the key is the source of truth for the name
+		if up.Name != "" && up.Name != name {
+			log.Printf("[gateway] upstream name %q in %s does not match its key, using %q", up.Name, key, name)
+		}
+		up.Name = name
+		s.upstreams[name] = up
+		s.rawUps[name] = value
+	case strings.HasPrefix(rel, prefixMiddlewares):
+		name := strings.TrimPrefix(rel, prefixMiddlewares)
+		if deleted {
+			delete(s.middlewares, name)
+			break
+		}
+		var mw MiddlewareConfig
+		if err := DecodeDocument(value, &mw); err != nil {
+			return err
+		}
+		s.middlewares[name] = mw
+	case strings.HasPrefix(rel, prefixConsumers):
+		name := strings.TrimPrefix(rel, prefixConsumers)
+		if deleted {
+			delete(s.consumers, name)
+			break
+		}
+		var consumer ConsumerConfig
+		if err := DecodeDocument(value, &consumer); err != nil {
+			return err
+		}
+		consumer.Name = name
+		s.consumers[name] = consumer
+	default:
+		return nil
+	}
+
+	if deleted {
+		delete(s.modRevs, key)
+	} else {
+		s.modRevs[key] = modRev
+	}
+	return nil
+}
+
+// replace swaps in the contents of a freshly fetched state.
+func (s *PrefixState) replace(fresh *PrefixState) {
+	fresh.mu.RLock()
+	defer fresh.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revision = fresh.revision
+	s.global = fresh.global
+	s.upstreams = fresh.upstreams
+	s.middlewares = fresh.middlewares
+	s.consumers = fresh.consumers
+	s.modRevs = fresh.modRevs
+	s.rawUps = fresh.rawUps
+}
+
+func (s *PrefixState) setRevision(rev int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rev > s.revision {
+		s.revision = rev
+	}
+}
+
+// FetchPrefix reads every resource under prefix with a single range Get.
+// Invalid resources are logged and skipped.
+func (e *EtcdClient) FetchPrefix(ctx context.Context, prefix string) (*PrefixState, error) {
+	state := newPrefixState(prefix)
+	resp, err := e.kv.Get(ctx, state.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range resp.Kvs {
+		if err := state.apply(string(kv.Key), kv.Value, kv.ModRevision, false); err != nil {
+			log.Printf("[gateway] ignoring invalid config resource at etcd key %s: %v", kv.Key, err)
+		}
+	}
+	state.setRevision(resp.Header.Revision)
+	return state, nil
+}
+
+// WatchPrefix watches every key under the state's prefix, starting right after the
+// revision the state was fetched at, so no change between fetch and watch is lost.
+// Each watch response is applied to the state key by key and then reported once via onUpdate.
+// Deleting a resource key removes that resource; a change set that would leave no
+// upstreams at all (e.g. an accidental "del --prefix") is not reported, keeping the
+// last applied config until upstreams are written again.
+// The watch survives disconnects and compaction, see watchLoop.
+func (e *EtcdClient) WatchPrefix(ctx context.Context, state *PrefixState, onUpdate func(*ConfigOverlay)) error {
+	report := func() {
+		overlay := state.Overlay()
+		if len(overlay.conf.Upstreams) == 0 {
+			log.Printf("[gateway] etcd prefix %s has no upstreams left, keeping the last applied config", state.prefix)
+			return
+		}
+		onUpdate(overlay)
+	}
+	return e.watchLoop(ctx, state.prefix, []clientv3.OpOption{clientv3.WithPrefix()}, state.Revision(),
+		func(ctx context.Context) (int64, error) {
+			fresh, err := e.FetchPrefix(ctx, state.prefix)
+			if err != nil {
+				return 0, err
+			}
+			state.replace(fresh)
+			report()
+			return fresh.Revision(), nil
+		},
+		func(resp clientv3.WatchResponse) {
+			changed := false
+			for _, evi := range resp.Events {
+				if evi.Kv == nil {
+					continue
+				}
+				deleted := evi.Type == clientv3.EventTypeDelete
+				if err := state.apply(string(evi.Kv.Key), evi.Kv.Value, evi.Kv.ModRevision, deleted); err != nil {
+					log.Printf("[gateway] ignoring invalid config resource at etcd key %s (rev %d): %v", evi.Kv.Key, evi.Kv.ModRevision, err)
+					continue
+				}
+				changed = true
+			}
+			state.setRevision(resp.Events[len(resp.Events)-1].Kv.ModRevision)
+			if changed {
+				report()
+			}
+		})
+}
+
+// PutResource writes value as JSON to key if the key's ModRevision still equals expectedRev
+// (0 means the key must not exist yet). It returns the new ModRevision, or ErrRevisionConflict
+// when another writer got there first.
+func (e *EtcdClient) PutResource(ctx context.Context, key string, value any, expectedRev int64) (int64, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := e.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", expectedRev)).
+		Then(clientv3.OpPut(key, string(data))).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, fmt.Errorf("%w: %s", ErrRevisionConflict, key)
+	}
+	return resp.Header.Revision, nil
+}
+
+// PutUpstreams makes the upstreams under the state's prefix match upstreams in a single
+// transaction: changed upstreams are written, missing ones deleted, unchanged keys left alone.
+// Every touched key is compared against the ModRevision the state last saw, so the whole
+// write fails with ErrRevisionConflict if any of them was changed concurrently.
+// Parts of a changed upstream that still match the stored value keep its ${...} references;
+// a change that would store a resolved reference fails with ErrResolvedSecret. Without any
+// upstream key the upstreams come from the local file and ErrUpstreamsNotOwned is returned.
+func (e *EtcdClient) PutUpstreams(ctx context.Context, state *PrefixState, upstreams []UpstreamConfig) error {
+	state.mu.RLock()
+	if len(state.upstreams) == 0 {
+		state.mu.RUnlock()
+		return fmt.Errorf("%w: no keys under %s%s", ErrUpstreamsNotOwned, state.prefix, prefixUpstreams)
+	}
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	wanted := make(map[string]bool, len(upstreams))
+	for _, up := range upstreams {
+		wanted[up.Name] = true
+		if old, ok := state.upstreams[up.Name]; ok && sameJSON(old, up) {
+			continue
+		}
+		data, err := upstreamValue(up, state.rawUps[up.Name])
+		if err != nil {
+			state.mu.RUnlock()
+			return err
+		}
+		key := state.prefix + prefixUpstreams + up.Name
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", state.modRevs[key]))
+		ops = append(ops, clientv3.OpPut(key, string(data)))
+	}
+	for name := range state.upstreams {
+		if !wanted[name] {
+			key := state.prefix + prefixUpstreams + name
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", state.modRevs[key]))
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+	}
+	state.mu.RUnlock()
+	if len(ops) == 0 {
+		return nil
+	}
+
+	resp, err := e.kv.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("%w: %supstreams/", ErrRevisionConflict, state.prefix)
+	}
+	// reflect the write right away, the watch (if any) will see the same events
+	for _, op := range ops {
+		if err := state.apply(string(op.KeyBytes()), op.ValueBytes(), resp.Header.Revision, op.IsDelete()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteResource deletes key if its ModRevision still equals expectedRev.
+func (e *EtcdClient) DeleteResource(ctx context.Context, key string, expectedRev int64) error {
+	resp, err := e.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", expectedRev)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("%w: %s", ErrRevisionConflict, key)
+	}
+	return nil
+}
+
+// upstreamValue encodes up as JSON for its key, keeping the ${...} references of the
+// previously stored value raw (if any) where the values are unchanged.
+func upstreamValue(up UpstreamConfig, raw []byte) ([]byte, error) {
+	var old *yaml.Node
+	if len(raw) > 0 {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(raw, &doc); err == nil && len(doc.Content) > 0 {
+			old = doc.Content[0]
+		}
+	}
+	node, err := upstreamNode(up, old)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestPrefixStateOverlay(t *testing.T) {
+	base := &GatewayConfig{
+		Global:      GlobalConfig{ListenAddr: ":8080"},
+		Middlewares: map[string]MiddlewareConfig{"cors": {Enabled: true}},
+		Upstreams:   []UpstreamConfig{{Name: "file", Hosts: []string{"file:80"}}},
+		Consumers:   []ConsumerConfig{{Name: "alice"}},
+	}
+	state := newPrefixState("/gw")
+	apply := func(key, value string, rev int64, deleted bool) {
+		t.Helper()
+		if err := state.apply(key, []byte(value), rev, deleted); err != nil {
+			t.Fatalf("apply %s: %v", key, err)
+		}
+	}
+
+	// only upstream keys: every other section comes from the file
+	apply("/gw/upstreams/b", `{"name":"other","hosts":["b:80"]}`, 2, false)
+	apply("/gw/upstreams/a", `hosts: ["a:80"]`, 3, false)
+	apply("/gw2/middlewares/ignored", `{"enabled":true}`, 4, false)
+	conf := state.Overlay().Apply(base)
+	if len(conf.Upstreams) != 2 || conf.Upstreams[0].Name != "a" || conf.Upstreams[1].Name != "b" {
+		t.Fatalf("upstreams %+v, want a and b named after their keys", conf.Upstreams)
+	}
+	if _, ok := conf.Middlewares["cors"]; !ok || len(conf.Middlewares) != 1 {
+		t.Fatalf("file middlewares replaced without any middleware key: %+v", conf.Middlewares)
+	}
+	if conf.Global.ListenAddr != ":8080" || len(conf.Consumers) != 1 {
+		t.Fatalf("file global/consumers replaced: %+v %+v", conf.Global, conf.Consumers)
+	}
+
+	// keys of a section replace that section as a whole
+	apply("/gw/global", `{"listen_addr":":9090"}`, 5, false)
+	apply("/gw/middlewares/rate_limiter", `{"enabled":true,"order":2}`, 6, false)
+	apply("/gw/consumers/bob", `{"name":"mallory"}`, 7, false)
+	conf = state.Overlay().Apply(base)
+	if conf.Global.ListenAddr != ":9090" {
+		t.Fatalf("global %+v", conf.Global)
+	}
+	if _, ok := conf.Middlewares["rate_limiter"]; !ok || len(conf.Middlewares) != 1 {
+		t.Fatalf("middlewares %+v, want only rate_limiter", conf.Middlewares)
+	}
+	if len(conf.Consumers) != 1 || conf.Consumers[0].Name != "bob" {
+		t.Fatalf("consumers %+v, want bob", conf.Consumers)
+	}
+
+	// an invalid value keeps the previous one
+	if err := state.apply("/gw/upstreams/a", []byte(`{"hosts":`), 8, false); err == nil {
+		t.Fatal("invalid upstream was accepted")
+	}
+	if got := state.ModRevision(UpstreamKey("/gw", "a")); got != 3 {
+		t.Fatalf("mod revision of upstream a = %d, want 3", got)
+	}
+
+	// deleting the last middleware key hands the section back to the file
+	apply("/gw/middlewares/rate_limiter", "", 9, true)
+	conf = state.Overlay().Apply(base)
+	if _, ok := conf.Middlewares["cors"]; !ok || len(conf.Middlewares) != 1 {
+		t.Fatalf("middlewares after deleting every key %+v, want the file's", conf.Middlewares)
+	}
+	if got := state.ModRevision(MiddlewareKey("/gw", "rate_limiter")); got != 0 {
+		t.Fatalf("mod revision of a deleted key = %d, want 0", got)
+	}
+}
+
+func TestEtcdPutUpstreamsConflict(t *testing.T) {
+	srv := startEtcd(t)
+	cli := srv.client()
+	writer := srv.client()
+	ctx := context.Background()
+	for key, value := range map[string]string{
+		"/gw/upstreams/a": `{"hosts":["a:80"]}`,
+		"/gw/upstreams/b": `{"hosts":["b:80"]}`,
+	} {
+		if _, err := writer.Put(ctx, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := newEtcdClient(cli, cli, func() error { return nil })
+	state, err := e.FetchPrefix(ctx, "/gw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreams := func() []UpstreamConfig { return state.Overlay().conf.Upstreams }
+	values := func() map[string]string {
+		t.Helper()
+		resp, err := writer.Get(ctx, "/gw/upstreams/", clientv3.WithPrefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			m[string(kv.Key)] = string(kv.Value)
+		}
+		return m
+	}
+
+	// change a, drop b, add c in one transaction; consecutive writes need no watch
+	ups := upstreams()
+	ups[0].Hosts = []string{"a:81"}
+	ups = append(ups[:1], UpstreamConfig{Name: "c", Hosts: []string{"c:80"}})
+	if err := e.PutUpstreams(ctx, state, ups); err != nil {
+		t.Fatal(err)
+	}
+	if got := values(); len(got) != 2 || got["/gw/upstreams/b"] != "" || got["/gw/upstreams/c"] == "" {
+		t.Fatalf("etcd after write %v", got)
+	}
+	ups = upstreams()
+	ups[1].Hosts = []string{"c:81"}
+	if err := e.PutUpstreams(ctx, state, ups); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+
+	// a concurrent edit of a touched key fails the whole write
+	if _, err := writer.Put(ctx, "/gw/upstreams/c", `{"hosts":["c:99"]}`); err != nil {
+		t.Fatal(err)
+	}
+	before := values()
+	ups = upstreams()
+	ups[0].Hosts = []string{"a:82"}
+	ups[1].Hosts = []string{"c:82"}
+	if err := e.PutUpstreams(ctx, state, ups); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("write over a concurrent edit = %v, want ErrRevisionConflict", err)
+	}
+	after := values()
+	for key, value := range before {
+		if after[key] != value {
+			t.Fatalf("%s changed by a failed write: %s -> %s", key, value, after[key])
+		}
+	}
+
+	// a key created concurrently is not overwritten either
+	if _, err := writer.Put(ctx, "/gw/upstreams/d", `{"hosts":["d:99"]}`); err != nil {
+		t.Fatal(err)
+	}
+	ups = append(upstreams(), UpstreamConfig{Name: "d", Hosts: []string{"d:80"}})
+	if err := e.PutUpstreams(ctx, state, ups); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("create over a concurrent create = %v, want ErrRevisionConflict", err)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdWriteUpstreamsComparesAppliedRevision(t *testing.T) {
+	const key = "/gw/config"
+	srv := startEtcd(t)
+	writer := srv.client()
+	ctx := context.Background()
+	if _, err := writer.Put(ctx, key, `{"upstreams":[{"name":"a","hosts":["a:80"]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`config_source:
+  type: etcd
+  etcd:
+    endpoints: [%q]
+    key: %q
+`, srv.clientURL.String(), key)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newProvider := func() Provider {
+		t.Helper()
+		base, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewProvider(path, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { p.Close() })
+		if _, err := p.Load(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	upstreams := func(names ...string) []UpstreamConfig {
+		var ups []UpstreamConfig
+		for _, name := range names {
+			ups = append(ups, UpstreamConfig{Name: name, Hosts: []string{name + ":80"}})
+		}
+		return ups
+	}
+
+	// consecutive writes succeed without waiting for the watch
+	p := newProvider()
+	w := p.(UpstreamWriter)
+	if err := w.WriteUpstreams(ctx, upstreams("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteUpstreams(ctx, upstreams("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// an edit the gateway has not applied yet is not overwritten
+	if _, err := writer.Put(ctx, key, `{"upstreams":[{"name":"c","hosts":["c:80"]}]}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteUpstreams(ctx, upstreams("d")); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("write over an unseen edit = %v, want ErrRevisionConflict", err)
+	}
+	resp, err := writer.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.Kvs[0].Value), `"c"`) {
+		t.Fatalf("unseen edit was overwritten: %s", resp.Kvs[0].Value)
+	}
+}
+
+func TestEtcdWriteUpstreamsRequiresOwnership(t *testing.T) {
+	srv := startEtcd(t)
+	writer := srv.client()
+	ctx := context.Background()
+	if _, err := writer.Put(ctx, "/gw/config", `{"global":{"listen_addr":":9090"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Put(ctx, "/gwp/global", `{"listen_addr":":9090"}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []string{"key: /gw/config", "prefix: /gwp/"} {
+		path := filepath.Join(t.TempDir(), "gateway.yaml")
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(`config_source:
+  type: etcd
+  etcd:
+    endpoints: [%q]
+    %s
+upstreams:
+  - name: file
+    hosts: ["file:80"]
+`, srv.clientURL.String(), layout)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		base, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewProvider(path, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		conf, err := p.Load(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the upstreams come from the file, writing them to etcd would move them there for good
+		err = p.(UpstreamWriter).WriteUpstreams(ctx, append(conf.Upstreams, UpstreamConfig{Name: "new", Hosts: []string{"new:80"}}))
+		if !errors.Is(err, ErrUpstreamsNotOwned) {
+			t.Fatalf("%s: write of file upstreams = %v, want ErrUpstreamsNotOwned", layout, err)
+		}
+	}
+	resp, err := writer.Get(ctx, "/gw", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range resp.Kvs {
+		if strings.Contains(string(kv.Value), "file:80") || strings.Contains(string(kv.Key), "upstreams") {
+			t.Fatalf("upstreams written to etcd: %s = %s", kv.Key, kv.Value)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeKV serves Get from a single in-memory key; other KV methods are not used.
+type fakeKV struct {
+	clientv3.KV
+	mu    sync.Mutex
+	kvs   []*mvccpb.KeyValue
+	rev   int64
+	calls int
+}
+
+func (f *fakeKV) set(rev int64, kvs ...*mvccpb.KeyValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev, f.kvs = rev, kvs
+}
+
+func (f *fakeKV) Get(_ context.Context, _ string, _ ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: f.rev}, Kvs: f.kvs}, nil
+}
+
+type watchCall struct {
+	rev int64
+	ch  chan clientv3.WatchResponse
+}
+
+// fakeWatcher hands every Watch call to the test, which drives the returned channel.
+type fakeWatcher struct {
+	calls chan watchCall
+}
+
+func (f *fakeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	ch := make(chan clientv3.WatchResponse, 8)
+	f.calls <- watchCall{rev: clientv3.OpGet(key, opts...).Rev(), ch: ch}
+	return ch
+}
+
+func (f *fakeWatcher) RequestProgress(context.Context) error { return nil }
+func (f *fakeWatcher) Close() error                          { return nil }
+
+func put(key, value string, modRev int64) *clientv3.Event {
+	return &clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: modRev}}
+}
+
+func del(key string, modRev int64) *clientv3.Event {
+	return &clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: modRev}}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func nextCall(t *testing.T, w *fakeWatcher) watchCall {
+	t.Helper()
+	select {
+	case c := <-w.calls:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a watch")
+		return watchCall{}
+	}
+}
+
+func nextUpdate(t *testing.T, updates chan *ConfigOverlay) *ConfigOverlay {
+	t.Helper()
+	select {
+	case o := <-updates:
+		return o
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a config update")
+		return nil
+	}
+}
+
+func TestEtcdWatchResumesAndRefetches(t *testing.T) {
+	const key = "/gw/config"
+	kv := &fakeKV{}
+	w := &fakeWatcher{calls: make(chan watchCall, 4)}
+	e := newEtcdClient(kv, w, func() error { return nil })
+	e.minBackoff, e.maxBackoff = time.Millisecond, 5*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *ConfigOverlay, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.WatchConfig(ctx, key, 5, func(o *ConfigOverlay) { updates <- o })
+	}()
+
+	// the first watch starts right after the fetched revision
+	c := nextCall(t, w)
+	if c.rev != 6 {
+		t.Fatalf("first watch rev = %d, want 6", c.rev)
+	}
+	if e.WatchHealthy() == nil {
+		t.Fatal("watch reported healthy before it was created")
+	}
+	c.ch <- clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: 10}, Created: true}
+	waitFor(t, "healthy watch", func() bool { return e.WatchHealthy() == nil })
+
+	c.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{put(key, `{"upstreams":[{"name":"a"}]}`, 7)}}
+	if o := nextUpdate(t, updates); o.conf.Upstreams[0].Name != "a" {
+		t.Fatalf("unexpected upstreams %+v", o.conf.Upstreams)
+	}
+
+	// invalid documents and deletes keep the last applied config
+	c.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{put(key, `{not json`, 8), del(key, 9)}}
+
+	// a dropped watch reconnects from the last seen revision, not the created header
+	close(c.ch)
+	c = nextCall(t, w)
+	if c.rev != 10 {
+		t.Fatalf("resumed watch rev = %d, want 10", c.rev)
+	}
+	select {
+	case o := <-updates:
+		t.Fatalf("unexpected update %+v", o.conf)
+	default:
+	}
+
+	// compaction triggers a full refetch and resumes after the fetched revision
+	kv.set(25, &mvccpb.KeyValue{Key: []byte(key), Value: []byte(`{"upstreams":[{"name":"b"}]}`), ModRevision: 24})
+	c.ch <- clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: 30}, CompactRevision: 20}
+	if o := nextUpdate(t, updates); o.conf.Upstreams[0].Name != "b" {
+		t.Fatalf("unexpected upstreams after refetch %+v", o.conf.Upstreams)
+	}
+	c = nextCall(t, w)
+	if c.rev != 26 {
+		t.Fatalf("watch after compaction rev = %d, want 26", c.rev)
+	}
+
+	cancel()
+	close(c.ch)
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("WatchConfig returned %v, want context.Canceled", err)
+	}
+	if e.WatchHealthy() == nil {
+		t.Fatal("stopped watch still reported healthy")
+	}
+}
+
+func TestEtcdPrefixWatchAppliesIncrementally(t *testing.T) {
+	kv := &fakeKV{}
+	kv.set(3,
+		&mvccpb.KeyValue{Key: []byte("/gw/upstreams/a"), Value: []byte(`{"hosts":["a:80"]}`), ModRevision: 2},
+		&mvccpb.KeyValue{Key: []byte("/gw/middlewares/cors"), Value: []byte(`{"enabled":true}`), ModRevision: 3},
+	)
+	w := &fakeWatcher{calls: make(chan watchCall, 4)}
+	e := newEtcdClient(kv, w, func() error { return nil })
+	e.minBackoff, e.maxBackoff = time.Millisecond, 5*time.Millisecond
+
+	state, err := e.FetchPrefix(context.Background(), "/gw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.ModRevision(UpstreamKey("/gw", "a")); got != 2 {
+		t.Fatalf("mod revision of upstream a = %d, want 2", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *ConfigOverlay, 4)
+	go e.WatchPrefix(ctx, state, func(o *ConfigOverlay) { updates <- o })
+
+	c := nextCall(t, w)
+	if c.rev != 4 {
+		t.Fatalf("prefix watch rev = %d, want 4", c.rev)
+	}
+	c.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{
+		put("/gw/upstreams/b", `{"name":"b","hosts":["b:80"]}`, 4),
+		del("/gw/middlewares/cors", 5),
+	}}
+	o := nextUpdate(t, updates)
+	if len(o.conf.Upstreams) != 2 || o.conf.Upstreams[1].Name != "b" || o.sections["middlewares"] {
+		t.Fatalf("unexpected state after incremental update %+v", o.conf)
+	}
+
+	// removing every upstream is not applied
+	c.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{del("/gw/upstreams/a", 6), del("/gw/upstreams/b", 7)}}
+	c.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{put("/gw/upstreams/c", `{"hosts":["c:80"]}`, 8)}}
+	o = nextUpdate(t, updates)
+	if len(o.conf.Upstreams) != 1 || o.conf.Upstreams[0].Name != "c" {
+		t.Fatalf("unexpected upstreams %+v", o.conf.Upstreams)
+	}
+	if state.Revision() != 8 {
+		t.Fatalf("state revision = %d, want 8", state.Revision())
+	}
+	cancel()
+	close(c.ch)
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// IncludeDir resolves the include directory of the config file at path;
+// relative directories are relative to the config file's directory.
+func IncludeDir(path, include string) string {
+	if filepath.IsAbs(include) {
+		return filepath.Clean(include)
+	}
+	return filepath.Join(filepath.Dir(path), include)
+}
+
+func isFragment(name string) bool {
+	ext := filepath.Ext(name)
+	return (ext == ".yaml" || ext == ".yml") && !strings.HasPrefix(filepath.Base(name), ".")
+}
+
+// mergeIncludes 按文件名顺序将 dir 下的 *.yaml 片段合并到 conf。
+// 片段只能包含 middlewares 与 upstreams；与已有配置同名时报错，避免不同团队的文件互相覆盖。
+func mergeIncludes(conf *GatewayConfig, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read include dir: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && isFragment(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	// 记录每个名称的来源，便于报错时定位
+	upstreams := make(map[string]string, len(conf.Upstreams))
+	for _, up := range conf.Upstreams {
+		upstreams[up.Name] = "main config"
+	}
+	middlewares := make(map[string]string, len(conf.Middlewares))
+	for name := range conf.Middlewares {
+		middlewares[name] = "main config"
+	}
+
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		v, err := readDocument(data)
+		if err != nil {
+			return fmt.Errorf("include %s: %w", file, err)
+		}
+		for _, key := range v.AllKeys() {
+			if section, _, _ := strings.Cut(key, "."); section != "middlewares" && section != "upstreams" {
+				return fmt.Errorf("include %s: section %q is not allowed in fragments", file, section)
+			}
+		}
+		var frag GatewayConfig
+		if _, err := decode(v, &frag); err != nil {
+			return fmt.Errorf("include %s: %w", file, err)
+		}
+
+		for _, up := range frag.Upstreams {
+			if up.Name == "" {
+				return fmt.Errorf("include %s: upstream without name", file)
+			}
+			if from, ok := upstreams[up.Name]; ok {
+				return fmt.Errorf("include %s: upstream %q is already defined in %s", file, up.Name, from)
+			}
+			upstreams[up.Name] = file
+			conf.Upstreams = append(conf.Upstreams, up)
+		}
+		for mwName, mw := range frag.Middlewares {
+			if from, ok := middlewares[mwName]; ok {
+				return fmt.Errorf("include %s: middleware %q is already defined in %s", file, mwName, from)
+			}
+			middlewares[mwName] = file
+			if conf.Middlewares == nil {
+				conf.Middlewares = make(map[string]MiddlewareConfig)
+			}
+			conf.Middlewares[mwName] = mw
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterProvider("file", func(path string, bootstrap *GatewayConfig) (Provider, error) {
+		conf := bootstrap.ConfigSource.File
+		if conf.Debounce <= 0 {
+			conf.Debounce = 500 * time.Millisecond
+		}
+		return &fileProvider{path: path, conf: conf, last: bootstrap}, nil
+	})
+}
+
+// fileProvider 读取本地配置文件（及 include 目录），可选通过 fsnotify 监听变化；SIGHUP 始终可用
+type fileProvider struct {
+	path string
+	conf FileConfig
+	last *GatewayConfig // 最近一次成功加载的配置，仅由 Watch 使用
+
+	writeMu sync.Mutex // 串行化写回
+}
+
+func (p *fileProvider) Load(context.Context) (*GatewayConfig, error) {
+	return LoadConfig(p.path)
+}
+
+// Watch 监听配置文件所在目录与 include 目录（编辑器和 Kubernetes ConfigMap 通常以
+// 重命名方式替换文件，直接监听文件会丢失后续事件）。短时间内的多次变化合并为一次重新加载，
+// 解析失败或内容未变化时不回调；语义校验由回调方在应用前完成（见 cmd/gateway reload）。
+func (p *fileProvider) Watch(ctx context.Context, onChange func(*GatewayConfig)) error {
+	if !p.conf.Watch {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	path := filepath.Clean(p.path)
+	mainDir := filepath.Dir(path)
+	if err := w.Add(mainDir); err != nil {
+		return err
+	}
+	includeDir := ""
+	watchInclude := func(conf *GatewayConfig) {
+		dir := ""
+		if conf.Include != "" {
+			dir = IncludeDir(path, conf.Include)
+		}
+		if dir == includeDir {
+			return
+		}
+		if includeDir != "" && includeDir != mainDir {
+			w.Remove(includeDir)
+		}
+		includeDir = ""
+		if dir != "" && dir != mainDir {
+			if err := w.Add(dir); err != nil {
+				log.Printf("[gateway] failed to watch include dir %s: %v", dir, err)
+				return
+			}
+		}
+		includeDir = dir
+	}
+	watchInclude(p.last)
+	log.Printf("[gateway] watching %s for config changes", path)
+
+	relevant := func(name string) bool {
+		name = filepath.Clean(name)
+		dir := filepath.Dir(name)
+		switch {
+		case name == path:
+			return true
+		case dir == mainDir && strings.HasPrefix(filepath.Base(name), "..data"):
+			// Kubernetes ConfigMap volumes swap the ..data symlink
+			return true
+		case includeDir != "" && dir == includeDir:
+			return isFragment(name)
+		}
+		return false
+	}
+
+	debounce := time.NewTimer(p.conf.Debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Chmod) || !relevant(ev.Name) {
+				continue
+			}
+			debounce.Reset(p.conf.Debounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("[gateway] config file watch error: %v", err)
+		case <-debounce.C:
+			conf, err := LoadConfig(path)
+			if err != nil {
+				log.Printf("[gateway] ignoring invalid config file change: %v", err)
+				continue
+			}
+			watchInclude(conf)
+			if reflect.DeepEqual(conf, p.last) {
+				continue
+			}
+			p.last = conf
+			onChange(conf)
+		}
+	}
+}
+
+// WriteUpstreams 改写主配置文件中的 upstreams 段（先写临时文件再重命名）。
+// 来自 include 片段的上游不能通过管理接口修改或删除，需直接编辑片段文件。
+func (p *fileProvider) WriteUpstreams(_ context.Context, upstreams []UpstreamConfig) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	var main GatewayConfig
+	if err := DecodeDocument(data, &main); err != nil {
+		return err
+	}
+	skip := make(map[string]bool)
+	if main.Include != "" {
+		var frags GatewayConfig
+		if err := mergeIncludes(&frags, IncludeDir(p.path, main.Include)); err != nil {
+			return err
+		}
+		wanted := make(map[string]UpstreamConfig, len(upstreams))
+		for _, up := range upstreams {
+			wanted[up.Name] = up
+		}
+		for _, frag := range frags.Upstreams {
+			up, ok := wanted[frag.Name]
+			if !ok || !sameJSON(up, frag) {
+				return fmt.Errorf("upstream %q is defined in an include fragment, edit the fragment instead", frag.Name)
+			}
+			skip[frag.Name] = true
+		}
+	}
+
+	out, err := rewriteUpstreams(data, upstreams, skip)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), "."+filepath.Base(p.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	log.Printf("[gateway] writing upstreams back to %s", p.path)
+	return os.Rename(tmp.Name(), p.path)
+}
+
+func (p *fileProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigMergesIncludes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gateway.yaml")
+	writeFile(t, path, `
+include: conf.d
+middlewares:
+  cors:
+    enabled: true
+upstreams:
+  - name: main
+    hosts: ["main:80"]
+`)
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "conf.d", "20-orders.yaml"), `
+upstreams:
+  - name: orders
+    hosts: ["orders:80"]
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "10-users.yaml"), `
+upstreams:
+  - name: users
+    hosts: ["users:80"]
+middlewares:
+  request_logger:
+    enabled: true
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "README.md"), "not a fragment")
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, up := range conf.Upstreams {
+		names = append(names, up.Name)
+	}
+	if got := strings.Join(names, ","); got != "main,users,orders" {
+		t.Fatalf("upstreams = %s, want main,users,orders", got)
+	}
+	if _, ok := conf.Middlewares["request_logger"]; !ok || len(conf.Middlewares) != 2 {
+		t.Fatalf("unexpected middlewares %+v", conf.Middlewares)
+	}
+
+	// duplicate names and non-mergeable sections are rejected
+	writeFile(t, filepath.Join(dir, "conf.d", "30-dup.yaml"), "upstreams:\n  - name: users\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `upstream "users" is already defined`) {
+		t.Fatalf("duplicate upstream: got %v", err)
+	}
+	writeFile(t, filepath.Join(dir, "conf.d", "30-dup.yaml"), "global:\n  listen_addr: \":1\"\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `section "global"`) {
+		t.Fatalf("global in fragment: got %v", err)
+	}
+}
+
+func TestFileProviderWatchDebounces(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gateway.yaml")
+	incDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(incDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, `
+include: conf.d
+config_source:
+  file:
+    watch: true
+    debounce: 100ms
+upstreams:
+  - name: main
+`)
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(path, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *GatewayConfig, 4)
+	done := make(chan error, 1)
+	go func() { done <- p.Watch(ctx, func(c *GatewayConfig) { updates <- c }) }()
+	time.Sleep(50 * time.Millisecond) // let the watcher start
+
+	// a burst of writes results in a single reload
+	for i := 0; i < 3; i++ {
+		writeFile(t, filepath.Join(incDir, "users.yaml"), "upstreams:\n  - name: users"+strings.Repeat("x", i)+"\n")
+	}
+	select {
+	case c := <-updates:
+		if len(c.Upstreams) != 2 || c.Upstreams[1].Name != "usersxx" {
+			t.Fatalf("unexpected upstreams %+v", c.Upstreams)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a config update")
+	}
+
+	// invalid changes are not applied
+	writeFile(t, path, "upstreams: [")
+	select {
+	case c := <-updates:
+		t.Fatalf("unexpected update %+v", c.Upstreams)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Watch returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Currently applied config version, as labels on a constant 1.
+var configInfo = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "lens_gateway",
+		Name:      "config_info",
+		Help:      "The active config version, content hash and source; always 1.",
+	},
+	[]string{"version", "hash", "source"},
+)
+
+// Snapshot 一份成功应用过的配置
+type Snapshot struct {
+	Version int64     `json:"version"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // startup|sighup|file|etcd|consul|rollback:<version>|...
+	Hash    string    `json:"hash"`
+	// Redacted 表示该快照来自持久化存储，其中的密钥已被脱敏，不能用于回滚
+	Redacted bool           `json:"redacted,omitempty"`
+	Config   *GatewayConfig `json:"config,omitempty"`
+}
+
+// SnapshotStore 快照的持久化存储（可选）
+type SnapshotStore interface {
+	// Save 保存快照，并自行清理超出保留数量的旧快照
+	Save(ctx context.Context, snap Snapshot) error
+	// Load 按版本升序返回已保存的快照
+	Load(ctx context.Context) ([]Snapshot, error)
+}
+
+// ConfigHash 返回配置内容的哈希（sha256 前 16 个十六进制字符）
+func ConfigHash(conf *GatewayConfig) string {
+	data, _ := json.Marshal(conf)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// History 有界的配置历史，版本号单调递增
+type History struct {
+	mu    sync.RWMutex
+	size  int
+	snaps []Snapshot // 按版本升序
+	next  int64
+	store SnapshotStore
+}
+
+// NewHistory 创建最多保留 size 份快照的历史，size <= 0 时为 10
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = 10
+	}
+	return &History{size: size, next: 1}
+}
+
+// Attach 挂载持久化存储：载入已保存的快照，并把内存中的快照接续编号后写入存储。
+// 应在网关开始服务前调用。
+func (h *History) Attach(ctx context.Context, store SnapshotStore) error {
+	persisted, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	var offset int64
+	if len(persisted) > 0 {
+		offset = persisted[len(persisted)-1].Version
+	}
+	local := h.snaps
+	for i := range local {
+		local[i].Version += offset
+	}
+	h.snaps = append(persisted, local...)
+	h.trim()
+	h.next += offset
+	h.store = store
+	h.mu.Unlock()
+
+	for _, snap := range local {
+		if err := store.Save(ctx, snap); err != nil {
+			return err
+		}
+	}
+	if len(local) > 0 {
+		setConfigInfo(local[len(local)-1])
+	}
+	return nil
+}
+
+// Record 记录一份新应用的配置并返回其快照
+func (h *History) Record(conf *GatewayConfig, source string) Snapshot {
+	h.mu.Lock()
+	snap := Snapshot{Version: h.next, Time: time.Now(), Source: source, Hash: ConfigHash(conf), Config: conf}
+	h.next++
+	h.snaps = append(h.snaps, snap)
+	h.trim()
+	store := h.store
+	h.mu.Unlock()
+
+	setConfigInfo(snap)
+	if store != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := store.Save(ctx, snap); err != nil {
+				log.Printf("[gateway] failed to persist config version %d: %v", snap.Version, err)
+			}
+		}()
+	}
+	return snap
+}
+
+func (h *History) trim() {
+	if n := len(h.snaps) - h.size; n > 0 {
+		h.snaps = append([]Snapshot(nil), h.snaps[n:]...)
+	}
+}
+
+// List 按版本升序返回全部快照
+func (h *History) List() []Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]Snapshot(nil), h.snaps...)
+}
+
+// Get 返回指定版本的快照
+func (h *History) Get(version int64) (Snapshot, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, snap := range h.snaps {
+		if snap.Version == version {
+			return snap, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// Current 返回当前生效的快照
+func (h *History) Current() Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.snaps) == 0 {
+		return Snapshot{}
+	}
+	return h.snaps[len(h.snaps)-1]
+}
+
+func setConfigInfo(snap Snapshot) {
+	configInfo.Reset()
+	configInfo.WithLabelValues(strconv.FormatInt(snap.Version, 10), snap.Hash, snap.Source).Set(1)
+}
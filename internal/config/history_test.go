@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"testing"
+)
+
+// memSnapshotStore is an in-memory SnapshotStore.
+type memSnapshotStore struct {
+	snaps []Snapshot
+	saved chan Snapshot
+}
+
+func (m *memSnapshotStore) Save(_ context.Context, snap Snapshot) error {
+	m.saved <- snap
+	return nil
+}
+
+func (m *memSnapshotStore) Load(context.Context) ([]Snapshot, error) {
+	return m.snaps, nil
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	a := &GatewayConfig{Global: GlobalConfig{ListenAddr: ":1"}}
+	b := &GatewayConfig{Global: GlobalConfig{ListenAddr: ":2"}}
+	h.Record(a, "startup")
+
+	// persisted snapshots come first and local versions continue after them
+	store := &memSnapshotStore{
+		snaps: []Snapshot{{Version: 7, Source: "sighup", Redacted: true}},
+		saved: make(chan Snapshot, 8),
+	}
+	if err := h.Attach(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	if saved := <-store.saved; saved.Version != 8 || saved.Source != "startup" {
+		t.Fatalf("attached snapshot saved as %+v", saved)
+	}
+
+	for i, conf := range []*GatewayConfig{b, a, b} {
+		snap := h.Record(conf, "etcd")
+		if snap.Version != int64(9+i) {
+			t.Fatalf("version = %d, want %d", snap.Version, 9+i)
+		}
+		<-store.saved
+	}
+
+	list := h.List()
+	if len(list) != 3 || list[0].Version != 9 || h.Current().Version != 11 {
+		t.Fatalf("unexpected history %+v", list)
+	}
+	if _, ok := h.Get(8); ok {
+		t.Fatal("version 8 should have been trimmed")
+	}
+	if s9, _ := h.Get(9); s9.Hash != h.Current().Hash || s9.Hash == ConfigHash(a) {
+		t.Fatal("hash should depend on content only")
+	}
+}
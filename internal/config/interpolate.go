@@ -0,0 +1,193 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Configuration strings may reference the environment and secret files:
+//
+//	${NAME}            value of the environment variable NAME, which must be set
+//	${NAME:-default}   value of NAME, or default when NAME is unset or empty
+//	${file:/path}      contents of the file (e.g. /run/secrets/jwt), trailing newline trimmed
+//	$${                a literal "${"
+//
+// A "$" not followed by "{" is kept as is, so regex replacements like "$1" are unaffected.
+// References are resolved before decoding, so numeric and boolean fields can use them too.
+// Middlewares with enabled: false are not resolved, so a disabled middleware never
+// requires its secrets to exist.
+
+// minSecretLen 过短的值（端口、布尔值等）不参与脱敏，否则会误伤无关文本
+const minSecretLen = 6
+
+var secrets sync.Map // resolved reference value -> struct{}
+
+// Redact replaces every value resolved from a ${...} reference in s with "******".
+// Use it for anything that may echo configuration values into logs or responses.
+func Redact(s string) string {
+	secrets.Range(func(k, _ any) bool {
+		s = strings.ReplaceAll(s, k.(string), "******")
+		return true
+	})
+	return s
+}
+
+// Interpolate resolves the ${...} references in s.
+func Interpolate(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			// escaped: "$${" -> "${"
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		val, err := resolve(ref)
+		if err != nil {
+			return "", err
+		}
+		if len(val) >= minSecretLen {
+			secrets.Store(val, struct{}{})
+		}
+		b.WriteString(s[:i])
+		b.WriteString(val)
+		s = s[i+end+1:]
+	}
+}
+
+func resolve(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("${file:%s}: %w", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if name, def, ok := strings.Cut(ref, ":-"); ok {
+		if val := os.Getenv(name); val != "" {
+			return val, nil
+		}
+		return def, nil
+	}
+	if ref == "" {
+		return "", errors.New("empty reference ${}")
+	}
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s referenced by ${%s} is not set", ref, ref)
+	}
+	return val, nil
+}
+
+// interpolateViper resolves references in every string setting of v and returns
+// a new viper holding the result.
+func interpolateViper(v *viper.Viper) (*viper.Viper, error) {
+	settings := v.AllSettings()
+	if mws, ok := settings["middlewares"].(map[string]any); ok {
+		for name, mw := range mws {
+			if m, ok := mw.(map[string]any); ok && !isTrue(m["enabled"]) {
+				continue
+			}
+			resolved, err := interpolateValue(mw, "middlewares."+name)
+			if err != nil {
+				return nil, err
+			}
+			mws[name] = resolved
+		}
+	}
+	for key, val := range settings {
+		if key == "middlewares" {
+			continue
+		}
+		resolved, err := interpolateValue(val, key)
+		if err != nil {
+			return nil, err
+		}
+		settings[key] = resolved
+	}
+
+	out := viper.New()
+	if err := out.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func interpolateValue(val any, path string) (any, error) {
+	switch x := val.(type) {
+	case string:
+		s, err := Interpolate(x)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return s, nil
+	case map[string]any:
+		for k, v := range x {
+			resolved, err := interpolateValue(v, path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = resolved
+		}
+	case []any:
+		for i, v := range x {
+			resolved, err := interpolateValue(v, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			x[i] = resolved
+		}
+	case []string:
+		for i, v := range x {
+			s, err := Interpolate(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", path, i, err)
+			}
+			x[i] = s
+		}
+	}
+	return val, nil
+}
+
+func isTrue(v any) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case string:
+		s, err := Interpolate(x)
+		return err == nil && (s == "true" || s == "1")
+	}
+	return false
+}
+
+// decode interpolates v and unmarshals it into out; decode errors are redacted
+// since they may quote resolved values.
+func decode(v *viper.Viper, out any) (*viper.Viper, error) {
+	v, err := interpolateViper(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Unmarshal(out); err != nil {
+		return nil, errors.New(Redact(err.Error()))
+	}
+	return v, nil
+}
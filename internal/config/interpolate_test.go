@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("GW_TEST_SECRET", "s3cr3t-value")
+	t.Setenv("GW_TEST_EMPTY", "")
+	secretFile := filepath.Join(t.TempDir(), "jwt")
+	writeFile(t, secretFile, "from-file-secret\n")
+
+	cases := []struct {
+		in, want string
+	}{
+		{"${GW_TEST_SECRET}", "s3cr3t-value"},
+		{"Bearer ${GW_TEST_SECRET}!", "Bearer s3cr3t-value!"},
+		{"${GW_TEST_UNSET:-fallback}", "fallback"},
+		{"${GW_TEST_EMPTY:-fallback}", "fallback"},
+		{"${GW_TEST_EMPTY}", ""},
+		{"${file:" + secretFile + "}", "from-file-secret"},
+		{"$${GW_TEST_SECRET}", "${GW_TEST_SECRET}"},
+		{"/internal/profile?user_id=$1", "/internal/profile?user_id=$1"},
+	}
+	for _, c := range cases {
+		got, err := Interpolate(c.in)
+		if err != nil {
+			t.Fatalf("Interpolate(%q): %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("Interpolate(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	for _, in := range []string{"${GW_TEST_UNSET}", "${file:/does/not/exist}", "${GW_TEST_SECRET", "${}"} {
+		if _, err := Interpolate(in); err == nil {
+			t.Errorf("Interpolate(%q) succeeded, want error", in)
+		}
+	}
+
+	if got := Redact("key=s3cr3t-value file=from-file-secret"); got != "key=****** file=******" {
+		t.Errorf("Redact = %q", got)
+	}
+}
+
+func TestLoadConfigInterpolates(t *testing.T) {
+	t.Setenv("GW_TEST_PORT", "9100")
+	t.Setenv("GW_TEST_JWT", "jwt-signing-key")
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeFile(t, path, `
+global:
+  listen_addr: ":${GW_TEST_PORT}"
+middlewares:
+  auth_jwt:
+    enabled: true
+    order: ${GW_TEST_ORDER:-2}
+    config:
+      secret_key: "${GW_TEST_JWT}"
+  disabled_mw:
+    enabled: false
+    config:
+      secret_key: "${GW_TEST_UNSET}"
+upstreams:
+  - name: users
+    hosts: ["${GW_TEST_HOST:-localhost}:8081"]
+    routes:
+      - path: /api/**
+        middlewares:
+          - name: auth_jwt
+            config:
+              secret_key: "${GW_TEST_JWT}"
+`)
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Global.ListenAddr != ":9100" {
+		t.Errorf("listen_addr = %q", conf.Global.ListenAddr)
+	}
+	jwt := conf.Middlewares["auth_jwt"]
+	if jwt.Order != 2 || jwt.Config["secret_key"] != "jwt-signing-key" {
+		t.Errorf("auth_jwt = %+v", jwt)
+	}
+	if got := conf.Middlewares["disabled_mw"].Config["secret_key"]; got != "${GW_TEST_UNSET}" {
+		t.Errorf("disabled middleware was interpolated: %v", got)
+	}
+	up := conf.Upstreams[0]
+	if up.Hosts[0] != "localhost:8081" {
+		t.Errorf("hosts = %v", up.Hosts)
+	}
+	if cfg, _ := up.Routes[0].Middlewares[0]["config"].(map[string]any); cfg["secret_key"] != "jwt-signing-key" {
+		t.Errorf("route middleware = %+v", up.Routes[0].Middlewares[0])
+	}
+
+	// unresolved required references fail loudly and name the setting, not a value
+	data, _ := os.ReadFile(path)
+	writeFile(t, path, strings.Replace(string(data), "${GW_TEST_JWT}", "${GW_TEST_MISSING}", 1))
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "GW_TEST_MISSING") || !strings.Contains(err.Error(), "middlewares.auth_jwt") {
+		t.Fatalf("LoadConfig with unset variable: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Provider 配置源：负责加载完整配置，并在配置变化时推送新配置。
+// 所有配置源都以本地引导文件（-conf）为基础，远程配置源在其上覆盖远程文档。
+type Provider interface {
+	// Load 读取完整配置；远程配置源会重新读取本地引导文件，并与最近一次远程文档合并
+	Load(ctx context.Context) (*GatewayConfig, error)
+	// Watch 阻塞监听配置变化，每次变化回调完整配置，直到 ctx 结束；
+	// 未开启监听时立即返回 nil。必须在第一次 Load 成功之后调用
+	Watch(ctx context.Context, onChange func(*GatewayConfig)) error
+	// Close 释放连接等资源
+	Close() error
+}
+
+// WatchHealthChecker 由支持监听的配置源实现，监听中断时返回非 nil，用于就绪检查
+type WatchHealthChecker interface {
+	WatchHealthy() error
+}
+
+// ProviderFactory 根据引导文件路径及其配置创建配置源
+type ProviderFactory func(path string, bootstrap *GatewayConfig) (Provider, error)
+
+var providers = make(map[string]ProviderFactory)
+
+// RegisterProvider 注册一种配置源类型
+func RegisterProvider(typ string, factory ProviderFactory) {
+	if _, exists := providers[typ]; exists {
+		panic(fmt.Sprintf("config source %s is already registered", typ))
+	}
+	providers[typ] = factory
+}
+
+// NewProvider 按 config_source.type 创建配置源，未配置时使用 file
+func NewProvider(path string, bootstrap *GatewayConfig) (Provider, error) {
+	typ := bootstrap.ConfigSource.Type
+	if typ == "" {
+		typ = "file"
+	}
+	factory, ok := providers[typ]
+	if !ok {
+		return nil, fmt.Errorf("config source %q not supported", typ)
+	}
+	return factory(path, bootstrap)
+}
+
+// layeredConfig 本地引导文件 + 远程配置文档，供远程配置源复用
+type layeredConfig struct {
+	path    string
+	mu      sync.Mutex
+	base    *GatewayConfig
+	overlay *ConfigOverlay
+}
+
+// reloadBase 重新读取本地引导文件，config_source 以启动时为准
+func (l *layeredConfig) reloadBase() error {
+	base, err := LoadConfig(l.path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.base != nil {
+		base.ConfigSource = l.base.ConfigSource
+	}
+	l.base = base
+	return nil
+}
+
+func (l *layeredConfig) setOverlay(overlay *ConfigOverlay) *GatewayConfig {
+	l.mu.Lock()
+	l.overlay = overlay
+	l.mu.Unlock()
+	return l.merged()
+}
+
+func (l *layeredConfig) merged() *GatewayConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.overlay == nil {
+		return l.base
+	}
+	return l.overlay.Apply(l.base)
+}
+
+// watchHealth 记录配置源监听状态，并同步到 config_watch_healthy 指标
+type watchHealth struct {
+	source string
+	mu     sync.RWMutex
+	err    error // nil while the watch is established
+}
+
+func newWatchHealth(source string) *watchHealth {
+	return &watchHealth{source: source, err: fmt.Errorf("%s watch not started", source)}
+}
+
+func (h *watchHealth) get() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.err
+}
+
+func (h *watchHealth) set(err error) {
+	h.mu.Lock()
+	h.err = err
+	h.mu.Unlock()
+	if err == nil {
+		configWatchHealthy.WithLabelValues(h.source).Set(1)
+	} else {
+		configWatchHealthy.WithLabelValues(h.source).Set(0)
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError is a single validation problem located by its config path,
+// e.g. "upstreams[0].routes[1].path".
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError collects every problem found in a config.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d config error(s):", len(e)))
+	for _, fe := range e {
+		lines = append(lines, "  "+fe.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UnknownKeys reports keys in the config file at path, and in its include fragments,
+// that do not map to any GatewayConfig field. Viper silently drops such keys, so a typo
+// like "load_balance" would otherwise go unnoticed.
+func UnknownKeys(path string) ([]FieldError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := readDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	var errs []FieldError
+	checkKeys(reflect.TypeOf(GatewayConfig{}), v.AllSettings(), "", &errs)
+
+	include, _ := v.Get("include").(string)
+	if include == "" {
+		return errs, nil
+	}
+	dir := IncludeDir(path, include)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// reported by LoadConfig
+		return errs, nil
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isFragment(e.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		v, err := readDocument(data)
+		if err != nil {
+			continue
+		}
+		checkKeys(reflect.TypeOf(GatewayConfig{}), v.AllSettings(), filepath.Join(include, e.Name())+":", &errs)
+	}
+	return errs, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// checkKeys walks value alongside t, following mapstructure tags. Free-form values
+// (map[string]any such as middleware configs) are not checked.
+func checkKeys(t reflect.Type, value any, path string, errs *[]FieldError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if value == nil || t == durationType {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+			if tag == "" {
+				tag = strings.ToLower(f.Name)
+			}
+			fields[tag] = f.Type
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft, ok := fields[k]
+			if !ok {
+				*errs = append(*errs, FieldError{Path: joinPath(path, k), Message: "unknown key"})
+				continue
+			}
+			checkKeys(ft, m[k], joinPath(path, k), errs)
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]any)
+		if !ok || t.Elem().Kind() == reflect.Interface {
+			return
+		}
+		for k, v := range m {
+			checkKeys(t.Elem(), v, joinPath(path, k), errs)
+		}
+	case reflect.Slice:
+		s, ok := value.([]any)
+		if !ok || t.Elem().Kind() == reflect.Interface {
+			return
+		}
+		for i, v := range s {
+			checkKeys(t.Elem(), v, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" || strings.HasSuffix(path, ":") {
+		return path + key
+	}
+	return path + "." + key
+}
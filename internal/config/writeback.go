@@ -0,0 +1,248 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// UpstreamWriter 由支持写回的配置源实现，管理接口通过它持久化运行时的上游变更
+type UpstreamWriter interface {
+	// WriteUpstreams 用 upstreams 替换配置源中的上游配置段。内容未变化的上游保持原样
+	// （包括其中的 ${...} 引用与注释），修改过的上游中未变化的字段同样保留引用；
+	// 配置源在此期间被其他写入者修改时返回 ErrRevisionConflict，变更会写入已展开的密钥时返回
+	// ErrResolvedSecret，配置源不持有 upstreams 配置段时返回 ErrUpstreamsNotOwned
+	WriteUpstreams(ctx context.Context, upstreams []UpstreamConfig) error
+}
+
+// ErrResolvedSecret 变更后的上游包含由 ${...} 展开的值，且无法对应回原配置中的引用，
+// 写回会把密钥明文保存到配置源
+var ErrResolvedSecret = errors.New("the change contains a value resolved from a ${...} reference and would store it in plain text")
+
+// ErrUpstreamsNotOwned 配置源不持有 upstreams 配置段（上游来自本地引导文件），
+// 写回会把文件中的上游搬到配置源并从此覆盖文件
+var ErrUpstreamsNotOwned = errors.New("the config source does not own the upstreams section")
+
+// decodeUpstream 解码单个上游文档。文档放在 upstreams 列表中解码，
+// 避免 weights 中形如 10.0.0.1:80 的键被 viper 按 "." 拆分为嵌套键
+func decodeUpstream(data []byte, up *UpstreamConfig) error {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	wrapped, err := json.Marshal(map[string]any{"upstreams": []any{v}})
+	if err != nil {
+		return err
+	}
+	var conf GatewayConfig
+	if err := DecodeDocument(wrapped, &conf); err != nil {
+		return err
+	}
+	if len(conf.Upstreams) != 1 {
+		return errors.New("empty upstream document")
+	}
+	*up = conf.Upstreams[0]
+	return nil
+}
+
+// sameJSON 按 JSON 表示比较两个值，避免 YAML 整数与 JSON 浮点数等解码差异
+func sameJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// rewriteUpstreams 替换 YAML 或 JSON 配置文档中的 upstreams 段，其余内容（包括注释）保持不变。
+// skip 中的上游不写入文档（例如来自 include 片段的上游）。
+func rewriteUpstreams(data []byte, upstreams []UpstreamConfig, skip map[string]bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("config document is not a mapping")
+	}
+
+	var seq *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "upstreams" {
+			seq = root.Content[i+1]
+		}
+	}
+	if seq == nil {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "upstreams"}, seq)
+	}
+	if seq.Kind != yaml.SequenceNode {
+		// e.g. "upstreams: null" or "upstreams: []" written in flow style
+		*seq = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+
+	// 已有的上游节点，按名称索引；解码时会展开 ${...}，因此与运行时配置可直接比较
+	existing := make(map[string]*yaml.Node, len(seq.Content))
+	decoded := make(map[string]UpstreamConfig, len(seq.Content))
+	for _, item := range seq.Content {
+		raw, err := yaml.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var up UpstreamConfig
+		if err := decodeUpstream(raw, &up); err != nil {
+			continue
+		}
+		existing[up.Name], decoded[up.Name] = item, up
+	}
+
+	items := make([]*yaml.Node, 0, len(upstreams))
+	for _, up := range upstreams {
+		if skip[up.Name] {
+			continue
+		}
+		if node, ok := existing[up.Name]; ok && sameJSON(decoded[up.Name], up) {
+			items = append(items, node)
+			continue
+		}
+		node, err := upstreamNode(up, existing[up.Name])
+		if err != nil {
+			return nil, err
+		}
+		if old, ok := existing[up.Name]; ok {
+			// keep the comments attached to the replaced entry
+			node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		}
+		items = append(items, node)
+	}
+	seq.Content = items
+	seq.Style = 0
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var v any
+		if err := doc.Decode(&v); err != nil {
+			return nil, err
+		}
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config document: %w", err)
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+// toNode 将值转换为块格式的 YAML 节点，字段顺序与结构体定义一致
+func toNode(v any) (*yaml.Node, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var clear func(*yaml.Node)
+	clear = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			clear(c)
+		}
+	}
+	clear(doc.Content[0])
+	return doc.Content[0], nil
+}
+
+// upstreamNode 生成上游的 YAML 节点。运行时配置中的 ${...} 已被展开，因此与原节点 old（可为 nil）
+// 展开后相同的部分沿用原节点，保留其中的引用；其余部分仍包含已展开的密钥时返回 ErrResolvedSecret
+func upstreamNode(up UpstreamConfig, old *yaml.Node) (*yaml.Node, error) {
+	node, err := toNode(up)
+	if err != nil {
+		return nil, err
+	}
+	node = restoreRefs(node, old)
+	if err := checkResolved(node, old); err != nil {
+		return nil, fmt.Errorf("upstream %q: %w", up.Name, err)
+	}
+	return node, nil
+}
+
+// restoreRefs 返回 node，其中展开后与 old 相同的子节点替换为 old 中的原节点
+func restoreRefs(node, old *yaml.Node) *yaml.Node {
+	if old == nil {
+		return node
+	}
+	if sameResolved(node, old) {
+		return old
+	}
+	switch {
+	case node.Kind == yaml.MappingNode && old.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			for j := 0; j+1 < len(old.Content); j += 2 {
+				if old.Content[j].Value == node.Content[i].Value {
+					node.Content[i+1] = restoreRefs(node.Content[i+1], old.Content[j+1])
+					break
+				}
+			}
+		}
+	case node.Kind == yaml.SequenceNode && old.Kind == yaml.SequenceNode:
+		for i := range node.Content {
+			if i < len(old.Content) {
+				node.Content[i] = restoreRefs(node.Content[i], old.Content[i])
+			}
+		}
+	}
+	return node
+}
+
+// sameResolved 比较 node 与展开 ${...} 之后的 old
+func sameResolved(node, old *yaml.Node) bool {
+	var a, b any
+	if node.Decode(&a) != nil || old.Decode(&b) != nil {
+		return false
+	}
+	b, err := interpolateValue(b, "")
+	return err == nil && sameJSON(a, b)
+}
+
+// checkResolved 检查 node 中不属于原节点 old 的标量是否包含已展开的密钥
+func checkResolved(node, old *yaml.Node) error {
+	kept := make(map[*yaml.Node]bool)
+	var mark func(*yaml.Node)
+	mark = func(n *yaml.Node) {
+		kept[n] = true
+		for _, c := range n.Content {
+			mark(c)
+		}
+	}
+	if old != nil {
+		mark(old)
+	}
+	var check func(*yaml.Node) error
+	check = func(n *yaml.Node) error {
+		if kept[n] {
+			return nil
+		}
+		if n.Kind == yaml.ScalarNode && Redact(n.Value) != n.Value {
+			return ErrResolvedSecret
+		}
+		for _, c := range n.Content {
+			if err := check(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(node)
+}
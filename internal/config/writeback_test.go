@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRewriteUpstreams(t *testing.T) {
+	t.Setenv("USERS_HOST", "users:80")
+	doc := `# gateway config
+global:
+  listen_addr: ":7000" # keep me
+upstreams:
+  # the users service
+  - name: users
+    hosts: ["${USERS_HOST}"]
+  - name: legacy
+    hosts: ["legacy:80"]
+`
+	upstreams := []UpstreamConfig{
+		{Name: "users", Hosts: []string{"users:80"}},
+		{Name: "orders", Hosts: []string{"10.0.0.1:80", "10.0.0.2:80"}, Weights: map[string]int{"10.0.0.1:80": 2}},
+	}
+	out, err := rewriteUpstreams([]byte(doc), upstreams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out)
+	// unchanged upstreams keep their ${...} references and comments, removed ones are gone
+	for _, want := range []string{"# gateway config", "# keep me", "# the users service", "${USERS_HOST}", "name: orders", `10.0.0.1:80: 2`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rewritten document lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "legacy") {
+		t.Errorf("removed upstream still present:\n%s", got)
+	}
+	var conf GatewayConfig
+	if err := DecodeDocument(out, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(conf.Upstreams, upstreams) || conf.Global.ListenAddr != ":7000" {
+		t.Fatalf("decoded %+v", conf)
+	}
+	// rewriting with the same upstreams is a no-op
+	if again, err := rewriteUpstreams(out, upstreams, nil); err != nil || string(again) != got {
+		t.Fatalf("second rewrite changed the document (%v):\n%s", err, again)
+	}
+
+	// JSON documents stay JSON; skipped upstreams are left out
+	out, err = rewriteUpstreams([]byte(`{"global": {"listen_addr": ":7000"}}`), upstreams, map[string]bool{"users": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf = GatewayConfig{}
+	if err := DecodeDocument(out, &conf); err != nil || !strings.HasPrefix(string(out), "{") {
+		t.Fatalf("JSON document rewritten as %s (%v)", out, err)
+	}
+	if len(conf.Upstreams) != 1 || conf.Upstreams[0].Name != "orders" || conf.Global.ListenAddr != ":7000" {
+		t.Fatalf("decoded %+v", conf)
+	}
+}
+
+func TestRewriteUpstreamsKeepsReferences(t *testing.T) {
+	t.Setenv("WB_JWT_SECRET", "s3cr3t-<&>")
+	doc := `upstreams:
+  - name: users
+    hosts: ["users:80"]
+    routes:
+      - path: "/users/**"
+        middlewares:
+          - name: jwt
+            config:
+              secret_key: "${WB_JWT_SECRET}"
+`
+	var conf GatewayConfig
+	if err := DecodeDocument([]byte(doc), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if got := conf.Upstreams[0].Routes[0].Middlewares[0]["config"].(map[string]any)["secret_key"]; got != "s3cr3t-<&>" {
+		t.Fatalf("secret_key decoded as %v", got)
+	}
+
+	// changing another field keeps the reference instead of writing the secret
+	up := conf.Upstreams[0]
+	up.Hosts = []string{"users:81"}
+	out, err := rewriteUpstreams([]byte(doc), []UpstreamConfig{up}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out)
+	if !strings.Contains(got, "${WB_JWT_SECRET}") || strings.Contains(got, "s3cr3t") || !strings.Contains(got, "users:81") {
+		t.Fatalf("rewritten document:\n%s", got)
+	}
+	// the same for the JSON value stored under an etcd prefix key
+	data, err := upstreamValue(up, []byte(`{"hosts":["users:80"],"routes":[{"path":"/users/**","middlewares":[{"name":"jwt","config":{"secret_key":"${WB_JWT_SECRET}"}}]}]}`))
+	if err != nil || !strings.Contains(string(data), "${WB_JWT_SECRET}") || strings.Contains(string(data), "s3cr3t") {
+		t.Fatalf("prefix value %s (%v)", data, err)
+	}
+
+	// a resolved secret in a changed part cannot be mapped back to its reference
+	up.Routes = append(up.Routes, RouteConfig{Path: "/admin/**", Middlewares: up.Routes[0].Middlewares})
+	if _, err := rewriteUpstreams([]byte(doc), []UpstreamConfig{up}, nil); !errors.Is(err, ErrResolvedSecret) {
+		t.Fatalf("copying a resolved secret = %v, want ErrResolvedSecret", err)
+	}
+	if _, err := upstreamValue(up, nil); !errors.Is(err, ErrResolvedSecret) {
+		t.Fatalf("new prefix value with a resolved secret = %v, want ErrResolvedSecret", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Package consumer 保存已配置的调用方，按 API key 识别调用方。
+// 配置中只保存 key 的 SHA-256 摘要，识别时对请求携带的 key 求摘要后查找。
+package consumer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"LensGateway.com/internal/config"
+)
+
+const hashPrefix = "sha256:"
+
+// Consumer 调用方
+type Consumer struct {
+	Name     string
+	Groups   []string
+	Metadata map[string]string
+}
+
+// InGroup 报告调用方是否属于 group
+func (c *Consumer) InGroup(group string) bool {
+	return slices.Contains(c.Groups, group)
+}
+
+// HashKey 返回 API key 在配置中的形式 "sha256:<hex>"
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// validHash 报告 s 是否为 HashKey 的结果
+func validHash(s string) bool {
+	digest, ok := strings.CutPrefix(s, hashPrefix)
+	if !ok || len(digest) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// Check 校验调用方配置：名称必填且唯一，key 必须是摘要且不能被多个调用方共用
+func Check(consumers []config.ConsumerConfig) []config.FieldError {
+	var errs []config.FieldError
+	names := make(map[string]int, len(consumers))
+	keys := make(map[string]string)
+	for i, c := range consumers {
+		path := fmt.Sprintf("consumers[%d]", i)
+		if c.Name == "" {
+			errs = append(errs, config.FieldError{Path: path + ".name", Message: "required"})
+		} else if first, dup := names[c.Name]; dup {
+			errs = append(errs, config.FieldError{Path: path + ".name", Message: fmt.Sprintf("duplicate consumer name %q (also consumers[%d])", c.Name, first)})
+		} else {
+			names[c.Name] = i
+		}
+		for j, key := range c.Keys {
+			keyPath := fmt.Sprintf("%s.keys[%d]", path, j)
+			key = strings.ToLower(key)
+			switch owner, dup := keys[key]; {
+			case !validHash(key):
+				// 不回显配置的值，它可能是误填的明文 key
+				errs = append(errs, config.FieldError{Path: keyPath, Message: `must be a SHA-256 digest "sha256:<64 hex digits>", see gateway hash-key`})
+			case dup:
+				errs = append(errs, config.FieldError{Path: keyPath, Message: fmt.Sprintf("key is already used by consumer %q", owner)})
+			default:
+				keys[key] = c.Name
+			}
+		}
+	}
+	return errs
+}
+
+// Registry 按 key 摘要索引的调用方，创建后只读
+type Registry struct {
+	byKey  map[string]*Consumer
+	byName map[string]*Consumer
+}
+
+// NewRegistry 由配置创建调用方注册表，配置有误时返回第一个问题
+func NewRegistry(consumers []config.ConsumerConfig) (*Registry, error) {
+	if errs := Check(consumers); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid consumer config: %s", errs[0])
+	}
+	r := &Registry{byKey: make(map[string]*Consumer), byName: make(map[string]*Consumer, len(consumers))}
+	for _, c := range consumers {
+		consumer := &Consumer{Name: c.Name, Groups: c.Groups, Metadata: c.Metadata}
+		r.byName[c.Name] = consumer
+		for _, key := range c.Keys {
+			r.byKey[strings.ToLower(key)] = consumer
+		}
+	}
+	return r, nil
+}
+
+// Authenticate 返回持有 API key 的调用方
+func (r *Registry) Authenticate(key string) (*Consumer, bool) {
+	c, ok := r.byKey[HashKey(key)]
+	return c, ok
+}
+
+// Get 按名称查找调用方
+func (r *Registry) Get(name string) (*Consumer, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// Len 返回调用方数量
+func (r *Registry) Len() int { return len(r.byName) }
+
+// current 网关当前生效的注册表，随配置重新加载整体替换
+var current atomic.Pointer[Registry]
+
+// Set 替换当前生效的注册表
+func Set(r *Registry) {
+	current.Store(r)
+}
+
+// Current 返回当前生效的注册表，未设置时为空注册表
+func Current() *Registry {
+	if r := current.Load(); r != nil {
+		return r
+	}
+	return &Registry{}
+}
@@ -0,0 +1,40 @@
+package consumer
+
+import (
+	"testing"
+
+	"LensGateway.com/internal/config"
+)
+
+func TestRegistry(t *testing.T) {
+	r, err := NewRegistry([]config.ConsumerConfig{
+		{Name: "billing", Keys: []string{HashKey("old-key"), HashKey("new-key")}, Groups: []string{"internal"}},
+		{Name: "acme", Keys: []string{HashKey("acme-key")}, Metadata: map[string]string{"plan": "pro"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"old-key": "billing", "new-key": "billing", "acme-key": "acme"} {
+		if c, ok := r.Authenticate(key); !ok || c.Name != want {
+			t.Errorf("Authenticate(%q) = %v, %v; want %s", key, c, ok, want)
+		}
+	}
+	if _, ok := r.Authenticate(HashKey("acme-key")); ok {
+		t.Error("the digest itself must not authenticate")
+	}
+	if c, _ := r.Get("billing"); !c.InGroup("internal") || c.InGroup("partners") {
+		t.Errorf("groups of billing: %v", c.Groups)
+	}
+
+	if _, err := NewRegistry([]config.ConsumerConfig{{Name: "plain", Keys: []string{"secret"}}}); err == nil {
+		t.Error("a plain-text key should be rejected")
+	}
+	if Current().Len() != 0 {
+		t.Error("the registry before Set should be empty")
+	}
+	Set(r)
+	defer Set(nil)
+	if _, ok := Current().Authenticate("acme-key"); !ok {
+		t.Error("Current does not return the registry passed to Set")
+	}
+}
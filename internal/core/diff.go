@@ -0,0 +1,293 @@
+package core
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"LensGateway.com/internal/balancer"
+	"LensGateway.com/internal/config"
+)
+
+// Change 一条配置变更。Path 形如 upstreams/user-service/routes[/api/users/**]，
+// Detail 为可读说明；中间件配置只列出变化的键，不输出值，避免泄露密钥。
+type Change struct {
+	Kind   string // added | removed | changed
+	Path   string
+	Detail string
+}
+
+func (c Change) String() string {
+	if c.Detail == "" {
+		return c.Kind + " " + c.Path
+	}
+	return c.Kind + " " + c.Path + ": " + c.Detail
+}
+
+// Diff 比较当前生效配置与候选配置，返回按配置段排序的变更列表；无变化时返回 nil
+func Diff(oldConf, newConf *config.GatewayConfig) []Change {
+	d := &differ{}
+	d.global(oldConf.Global, newConf.Global)
+	d.middlewares("middlewares", oldConf.Middlewares, newConf.Middlewares)
+	d.upstreams(oldConf.Upstreams, newConf.Upstreams)
+	d.consumers(oldConf.Consumers, newConf.Consumers)
+	return d.changes
+}
+
+// Diff 比较网关当前生效的配置与候选配置
+func (g *Gateway) Diff(newConf *config.GatewayConfig) []Change {
+	return Diff(g.Config(), newConf)
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(kind, path, format string, args ...any) {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Detail: config.Redact(fmt.Sprintf(format, args...))})
+}
+
+func (d *differ) global(o, n config.GlobalConfig) {
+	if o.ListenAddr != n.ListenAddr {
+		d.add("changed", "global/listen_addr", "%q -> %q", o.ListenAddr, n.ListenAddr)
+	}
+	if !slices.Equal(o.TrustedProxies, n.TrustedProxies) {
+		d.add("changed", "global/trusted_proxies", "%v -> %v", o.TrustedProxies, n.TrustedProxies)
+	}
+}
+
+func (d *differ) middlewares(path string, o, n map[string]config.MiddlewareConfig) {
+	for _, name := range unionKeys(o, n) {
+		om, inOld := o[name]
+		nm, inNew := n[name]
+		p := path + "/" + name
+		switch {
+		case !inOld:
+			d.add("added", p, "enabled=%v order=%d", nm.Enabled, nm.Order)
+		case !inNew:
+			d.add("removed", p, "")
+		default:
+			var details []string
+			if om.Enabled != nm.Enabled {
+				details = append(details, fmt.Sprintf("enabled %v -> %v", om.Enabled, nm.Enabled))
+			}
+			if om.Order != nm.Order {
+				details = append(details, fmt.Sprintf("order %d -> %d", om.Order, nm.Order))
+			}
+			if keys := changedKeys(om.Config, nm.Config); len(keys) > 0 {
+				details = append(details, "config keys changed: "+strings.Join(keys, ", "))
+			}
+			if len(details) > 0 {
+				d.add("changed", p, "%s", strings.Join(details, "; "))
+			}
+		}
+	}
+}
+
+func (d *differ) upstreams(o, n []config.UpstreamConfig) {
+	oldUps := make(map[string]config.UpstreamConfig, len(o))
+	for _, up := range o {
+		oldUps[up.Name] = up
+	}
+	newUps := make(map[string]config.UpstreamConfig, len(n))
+	for _, up := range n {
+		newUps[up.Name] = up
+	}
+	for _, name := range unionKeys(oldUps, newUps) {
+		ou, inOld := oldUps[name]
+		nu, inNew := newUps[name]
+		p := "upstreams/" + name
+		switch {
+		case !inOld:
+			d.add("added", p, "hosts %v, %d route(s)", nu.Hosts, len(nu.Routes))
+			d.routes(p, nil, nu.Routes)
+		case !inNew:
+			d.add("removed", p, "hosts %v, %d route(s)", ou.Hosts, len(ou.Routes))
+			d.routes(p, ou.Routes, nil)
+		default:
+			d.upstream(p, ou, nu)
+		}
+	}
+}
+
+func (d *differ) upstream(p string, o, n config.UpstreamConfig) {
+	if normalizeScheme(o.Scheme) != normalizeScheme(n.Scheme) {
+		d.add("changed", p+"/scheme", "%s -> %s", normalizeScheme(o.Scheme), normalizeScheme(n.Scheme))
+	}
+	if normalizeAlgo(o.LoadBalancing) != normalizeAlgo(n.LoadBalancing) {
+		d.add("changed", p+"/load_balancing", "%s -> %s", normalizeAlgo(o.LoadBalancing), normalizeAlgo(n.LoadBalancing))
+	}
+	if o.HealthCheck != n.HealthCheck {
+		d.add("changed", p+"/health_check", "%q -> %q", o.HealthCheck, n.HealthCheck)
+	}
+	for _, h := range n.Hosts {
+		if !slices.Contains(o.Hosts, h) {
+			d.add("added", p+"/hosts["+h+"]", "")
+		}
+	}
+	for _, h := range o.Hosts {
+		if !slices.Contains(n.Hosts, h) {
+			d.add("removed", p+"/hosts["+h+"]", "")
+		}
+	}
+	for _, h := range n.Hosts {
+		if ow, nw := max(nodeWeight(o.Weights, h), 1), max(nodeWeight(n.Weights, h), 1); slices.Contains(o.Hosts, h) && ow != nw {
+			d.add("changed", p+"/weights["+h+"]", "%d -> %d", ow, nw)
+		}
+	}
+	d.routes(p, o.Routes, n.Routes)
+}
+
+// routes 以路径标识路由；同一路径按方法拆分为多条路由时，以 "方法 路径" 标识
+func (d *differ) routes(p string, o, n []config.RouteConfig) {
+	count := make(map[string]int)
+	for _, r := range o {
+		count["o"+r.Path]++
+	}
+	for _, r := range n {
+		count["n"+r.Path]++
+	}
+	key := func(r config.RouteConfig) string {
+		if count["o"+r.Path] > 1 || count["n"+r.Path] > 1 {
+			return routeMethods(r) + " " + r.Path
+		}
+		return r.Path
+	}
+	oldRoutes := make(map[string]config.RouteConfig, len(o))
+	for _, r := range o {
+		oldRoutes[key(r)] = r
+	}
+	newRoutes := make(map[string]config.RouteConfig, len(n))
+	for _, r := range n {
+		newRoutes[key(r)] = r
+	}
+	for _, k := range unionKeys(oldRoutes, newRoutes) {
+		or, inOld := oldRoutes[k]
+		nr, inNew := newRoutes[k]
+		rp := p + "/routes[" + k + "]"
+		switch {
+		case !inOld:
+			d.add("added", rp, "methods %s", routeMethods(nr))
+		case !inNew:
+			d.add("removed", rp, "")
+		default:
+			var details []string
+			if routeMethods(or) != routeMethods(nr) {
+				details = append(details, fmt.Sprintf("methods %s -> %s", routeMethods(or), routeMethods(nr)))
+			}
+			if or.Rewrite != nr.Rewrite {
+				details = append(details, fmt.Sprintf("rewrite %q -> %q", or.Rewrite, nr.Rewrite))
+			}
+			if middlewareNames(or.Middlewares) != middlewareNames(nr.Middlewares) {
+				details = append(details, fmt.Sprintf("middlewares [%s] -> [%s]", middlewareNames(or.Middlewares), middlewareNames(nr.Middlewares)))
+			} else if !reflect.DeepEqual(or.Middlewares, nr.Middlewares) {
+				details = append(details, "middleware config changed")
+			}
+			if !reflect.DeepEqual(or.Fallback, nr.Fallback) {
+				details = append(details, "fallback changed")
+			}
+			if len(details) > 0 {
+				d.add("changed", rp, "%s", strings.Join(details, "; "))
+			}
+		}
+	}
+}
+
+// routeMethods 返回排序后的方法列表，未限制方法时为 *
+func routeMethods(r config.RouteConfig) string {
+	if len(r.Methods) == 0 {
+		return "*"
+	}
+	ms := make([]string, len(r.Methods))
+	for i, m := range r.Methods {
+		ms[i] = strings.ToUpper(m)
+	}
+	sort.Strings(ms)
+	return strings.Join(ms, ",")
+}
+
+func middlewareNames(mws []map[string]any) string {
+	out := make([]string, 0, len(mws))
+	for _, mw := range mws {
+		name, _ := mw["name"].(string)
+		out = append(out, name)
+	}
+	return strings.Join(out, ", ")
+}
+
+// consumers 只报告 key 的数量变化，不输出摘要
+func (d *differ) consumers(o, n []config.ConsumerConfig) {
+	oldConsumers := make(map[string]config.ConsumerConfig, len(o))
+	for _, c := range o {
+		oldConsumers[c.Name] = c
+	}
+	newConsumers := make(map[string]config.ConsumerConfig, len(n))
+	for _, c := range n {
+		newConsumers[c.Name] = c
+	}
+	for _, name := range unionKeys(oldConsumers, newConsumers) {
+		oc, inOld := oldConsumers[name]
+		nc, inNew := newConsumers[name]
+		p := "consumers/" + name
+		switch {
+		case !inOld:
+			d.add("added", p, "%d key(s), groups %v", len(nc.Keys), nc.Groups)
+		case !inNew:
+			d.add("removed", p, "")
+		default:
+			var details []string
+			if !slices.Equal(oc.Keys, nc.Keys) {
+				details = append(details, fmt.Sprintf("keys changed, %d -> %d key(s)", len(oc.Keys), len(nc.Keys)))
+			}
+			if !slices.Equal(oc.Groups, nc.Groups) {
+				details = append(details, fmt.Sprintf("groups %v -> %v", oc.Groups, nc.Groups))
+			}
+			if !maps.Equal(oc.Metadata, nc.Metadata) {
+				details = append(details, "metadata changed")
+			}
+			if len(details) > 0 {
+				d.add("changed", p, "%s", strings.Join(details, "; "))
+			}
+		}
+	}
+}
+
+func changedKeys(o, n map[string]any) []string {
+	var keys []string
+	for _, k := range unionKeys(o, n) {
+		if !reflect.DeepEqual(o[k], n[k]) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := slices.Collect(maps.Keys(a))
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeScheme 返回实际使用的协议，默认 http
+func normalizeScheme(s string) string {
+	if s == "" {
+		return "http"
+	}
+	return strings.ToLower(s)
+}
+
+// normalizeAlgo 返回实际使用的负载均衡算法，默认 round-robin
+func normalizeAlgo(s string) string {
+	if s == "" {
+		return balancer.R2Balancer
+	}
+	return strings.ToLower(s)
+}
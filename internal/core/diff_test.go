@@ -0,0 +1,80 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"LensGateway.com/internal/config"
+)
+
+func TestDiff(t *testing.T) {
+	oldConf := &config.GatewayConfig{
+		Global: config.GlobalConfig{ListenAddr: ":7000"},
+		Middlewares: map[string]config.MiddlewareConfig{
+			"cors":     {Enabled: true, Config: map[string]any{"max_age": "600", "allow_origin": "*"}},
+			"auth_jwt": {Enabled: true, Config: map[string]any{"secret_key": "old-secret"}},
+			"acl":      {Enabled: true},
+		},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "users", Hosts: []string{"a:80", "b:80"}, Routes: []config.RouteConfig{
+				{Path: "/api/users/**", Methods: []string{"GET", "POST"}},
+				{Path: "/api/admin/**", Rewrite: "/admin/"},
+			}},
+			{Name: "legacy", Hosts: []string{"l:80"}},
+		},
+		Consumers: []config.ConsumerConfig{
+			{Name: "billing", Keys: []string{"sha256:aa"}, Groups: []string{"internal"}},
+			{Name: "legacy-app", Keys: []string{"sha256:bb"}},
+		},
+	}
+	newConf := &config.GatewayConfig{
+		Global: config.GlobalConfig{ListenAddr: ":7000"},
+		Middlewares: map[string]config.MiddlewareConfig{
+			"cors":     {Enabled: true, Order: 1, Config: map[string]any{"max_age": "60", "allow_origin": "*"}},
+			"auth_jwt": {Enabled: true, Config: map[string]any{"secret_key": "new-secret"}},
+			"logging":  {Enabled: true},
+		},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "users", LoadBalancing: "p2c", Hosts: []string{"b:80", "c:80"}, Weights: map[string]int{"b:80": 3}, Routes: []config.RouteConfig{
+				{Path: "/api/users/**", Methods: []string{"post", "get"}},
+				{Path: "/api/admin/**", Rewrite: "/internal/admin/"},
+				{Path: "/api/me"},
+			}},
+			{Name: "orders", Hosts: []string{"o:80"}, Routes: []config.RouteConfig{{Path: "/api/orders/**"}}},
+		},
+		Consumers: []config.ConsumerConfig{
+			{Name: "acme", Keys: []string{"sha256:cc"}, Groups: []string{"partners"}},
+			{Name: "billing", Keys: []string{"sha256:aa", "sha256:dd"}, Groups: []string{"internal"}, Metadata: map[string]string{"plan": "pro"}},
+		},
+	}
+
+	var got []string
+	for _, c := range Diff(oldConf, newConf) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"removed middlewares/acl",
+		"changed middlewares/auth_jwt: config keys changed: secret_key",
+		"changed middlewares/cors: order 0 -> 1; config keys changed: max_age",
+		"added middlewares/logging: enabled=true order=0",
+		"removed upstreams/legacy: hosts [l:80], 0 route(s)",
+		"added upstreams/orders: hosts [o:80], 1 route(s)",
+		"added upstreams/orders/routes[/api/orders/**]: methods *",
+		"changed upstreams/users/load_balancing: round-robin -> p2c",
+		"added upstreams/users/hosts[c:80]",
+		"removed upstreams/users/hosts[a:80]",
+		"changed upstreams/users/weights[b:80]: 1 -> 3",
+		`changed upstreams/users/routes[/api/admin/**]: rewrite "/admin/" -> "/internal/admin/"`,
+		"added upstreams/users/routes[/api/me]: methods *",
+		"added consumers/acme: 1 key(s), groups [partners]",
+		"changed consumers/billing: keys changed, 1 -> 2 key(s); metadata changed",
+		"removed consumers/legacy-app",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Diff =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if changes := Diff(oldConf, oldConf); len(changes) != 0 {
+		t.Fatalf("Diff of identical configs = %v", changes)
+	}
+}
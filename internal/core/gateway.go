@@ -0,0 +1,348 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/consumer"
+	"LensGateway.com/internal/middleware"
+	"LensGateway.com/internal/observe"
+	"github.com/gin-gonic/gin"
+)
+
+// Gateway 持有当前生效的完整配置，负责构建 gin 引擎、管理监听器，并支持整体热重载。
+// 每次重载都会构建一个全新的引擎（全局中间件链、可信代理等），构建成功后原子替换。
+type Gateway struct {
+	mu      sync.Mutex // 串行化 Reload / Shutdown
+	conf    *config.GatewayConfig
+	rm      *RouterManager
+	chain   *middleware.Chain
+	handler atomic.Value // stores *gin.Engine
+
+	srv      *http.Server
+	serveErr chan error
+	done     chan struct{}
+	stopOnce sync.Once
+
+	readyMu sync.RWMutex
+	ready   map[string]func() error // 就绪检查，如配置源 watch 状态
+
+	history *config.History // 成功应用过的配置，用于回滚
+	admin   http.Handler    // 可选：挂载到 /admin/ 的管理接口
+}
+
+// NewGateway 根据配置构建网关，但不开始监听
+func NewGateway(conf *config.GatewayConfig) (*Gateway, error) {
+	consumers, err := consumer.NewRegistry(conf.Consumers)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := middleware.BuildChain(conf.Middlewares)
+	if err != nil {
+		return nil, err
+	}
+	rm, err := NewRouterManager(conf.Upstreams, conf.ConfigSource)
+	if err != nil {
+		chain.Release()
+		return nil, err
+	}
+	g := &Gateway{
+		conf:     conf,
+		rm:       rm,
+		chain:    chain,
+		serveErr: make(chan error, 1),
+		done:     make(chan struct{}),
+		ready:    make(map[string]func() error),
+		history:  config.NewHistory(conf.History.Size),
+	}
+	engine, err := g.newEngine(conf, chain)
+	if err != nil {
+		chain.Release()
+		return nil, err
+	}
+	g.handler.Store(engine)
+	consumer.Set(consumers)
+	g.history.Record(conf, "startup")
+	return g, nil
+}
+
+// newEngine 构建一个完整的 gin 引擎：内置端点 + 路由预匹配 + 全局中间件 + 兜底转发
+func (g *Gateway) newEngine(conf *config.GatewayConfig, chain *middleware.Chain) (*gin.Engine, error) {
+	// Initialize router using gin.New() for full middleware control.
+	router := gin.New()
+	// set trusted proxies if configured
+	trustedProxies := conf.Global.TrustedProxies
+	if len(trustedProxies) == 0 {
+		trustedProxies = []string{"127.0.0.1", "::1"}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("failed to set trusted proxies: %w", err)
+	}
+
+	// gateway health check endpoint, with the active config version
+	router.GET("/healthz", func(c *gin.Context) {
+		snap := g.history.Current()
+		c.JSON(200, gin.H{"status": "ok", "config_version": snap.Version, "config_hash": snap.Hash})
+	})
+	// readiness endpoint, fails while any readiness check fails
+	router.GET("/readyz", g.handleReady)
+	// Prometheus metrics endpoint
+	router.GET("/metrics", observe.MetricsHandler())
+	// embedded admin API, registered before the global middlewares like the endpoints above
+	if g.admin != nil {
+		router.Any("/admin/*path", gin.WrapH(g.admin))
+	}
+
+	// register pre-match middleware for route prefix matching
+	router.Use(g.rm.PreMatchMiddleware())
+	// register other global middlewares
+	router.Use(chain.Handlers()...)
+	// capture all routes except configured ones(e.g. /healthz)
+	router.NoRoute(g.rm.HandleRequest)
+	return router, nil
+}
+
+// AddReadinessCheck 注册一个就绪检查，check 返回非 nil 时 /readyz 返回 503
+func (g *Gateway) AddReadinessCheck(name string, check func() error) {
+	g.readyMu.Lock()
+	defer g.readyMu.Unlock()
+	g.ready[name] = check
+}
+
+func (g *Gateway) handleReady(c *gin.Context) {
+	g.readyMu.RLock()
+	defer g.readyMu.RUnlock()
+	failed := gin.H{}
+	for name, check := range g.ready {
+		if err := check(); err != nil {
+			failed[name] = err.Error()
+		}
+	}
+	if len(failed) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": failed})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// ServeHTTP 将请求交给当前生效的引擎处理
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.Load().(*gin.Engine).ServeHTTP(w, r)
+}
+
+// Config 返回当前生效的配置（只读）
+func (g *Gateway) Config() *config.GatewayConfig {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.conf
+}
+
+// SetAdminHandler 将管理接口挂载到网关监听的 /admin/ 下
+func (g *Gateway) SetAdminHandler(h http.Handler) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.admin = h
+	engine, err := g.newEngine(g.conf, g.chain)
+	if err != nil {
+		return err
+	}
+	g.handler.Store(engine)
+	return nil
+}
+
+// History 返回配置历史
+func (g *Gateway) History() *config.History {
+	return g.history
+}
+
+// RouterManager 返回网关的路由管理器
+func (g *Gateway) RouterManager() *RouterManager {
+	return g.rm
+}
+
+// ListenAndServe 监听配置的地址并阻塞，直到 Shutdown 被调用或服务出错。
+// 重载更换监听地址时会在新地址上启动服务并优雅关闭旧服务，不会导致本方法返回。
+func (g *Gateway) ListenAndServe() error {
+	g.mu.Lock()
+	ln, err := net.Listen("tcp", g.conf.Global.ListenAddr)
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.serve(ln)
+	g.mu.Unlock()
+
+	select {
+	case err := <-g.serveErr:
+		return err
+	case <-g.done:
+		return http.ErrServerClosed
+	}
+}
+
+// serve 在给定监听器上启动新的 http.Server，调用方需持有 g.mu
+func (g *Gateway) serve(ln net.Listener) {
+	srv := &http.Server{Handler: g}
+	g.srv = srv
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			select {
+			case g.serveErr <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// Shutdown 优雅关闭当前服务并释放中间件资源
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopOnce.Do(func() { close(g.done) })
+	var err error
+	if g.srv != nil {
+		err = g.srv.Shutdown(ctx)
+	}
+	g.chain.Release()
+	return err
+}
+
+// ErrVersionConflict 当前配置版本与调用方期望的版本不一致
+var ErrVersionConflict = errors.New("config version has changed")
+
+// UpdateUpstreams 仅替换上游与路由（例如来自管理接口的变更），其余配置保持不变。
+// ifVersion 非 0 时仅在当前配置版本仍为 ifVersion 时应用，否则返回 ErrVersionConflict。
+// persist 不为 nil 时在版本检查通过之后、生效之前调用（持有配置锁，期间不会有其他重载），
+// 用于写回配置源；它返回错误时不做任何变更。
+// 成功后新配置以 source 记入配置历史，并返回其快照。
+func (g *Gateway) UpdateUpstreams(upstreams []config.UpstreamConfig, source string, ifVersion int64, persist func() error) (config.Snapshot, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.done:
+		return config.Snapshot{}, errors.New("gateway is shutting down")
+	default:
+	}
+	if current := g.history.Current().Version; ifVersion != 0 && current != ifVersion {
+		return config.Snapshot{}, fmt.Errorf("%w: expected version %d, current is %d", ErrVersionConflict, ifVersion, current)
+	}
+	tbl, err := buildRoutingTable(upstreams)
+	if err != nil {
+		return config.Snapshot{}, err
+	}
+	if persist != nil {
+		if err := persist(); err != nil {
+			tbl.release()
+			return config.Snapshot{}, err
+		}
+	}
+
+	conf := *g.conf
+	conf.Upstreams = upstreams
+	changes := Diff(g.conf, &conf)
+	g.rm.swap(tbl)
+	g.conf = &conf
+
+	snap := g.history.Record(&conf, source)
+	for _, c := range changes {
+		log.Printf("[gateway] upstreams updated as version %d (%s): %s", snap.Version, source, c)
+	}
+	return snap, nil
+}
+
+// Reload 使用新配置整体重载网关：路由表、全局中间件链、全局设置以及监听地址。
+// 所有部分先构建完成，任一部分失败都会丢弃新构建的资源并保留旧配置。
+// 成功后新配置以 source（如 sighup、etcd、rollback:3）记入配置历史。
+func (g *Gateway) Reload(newConf *config.GatewayConfig, source string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.done:
+		return errors.New("gateway is shutting down")
+	default:
+	}
+
+	// 1) 构建阶段：不影响当前生效的配置
+	consumers, err := consumer.NewRegistry(newConf.Consumers)
+	if err != nil {
+		return err
+	}
+	tbl, err := buildRoutingTable(newConf.Upstreams)
+	if err != nil {
+		return err
+	}
+	chain, err := middleware.BuildChain(newConf.Middlewares)
+	if err != nil {
+		tbl.release()
+		return err
+	}
+	engine, err := g.newEngine(newConf, chain)
+	if err != nil {
+		tbl.release()
+		chain.Release()
+		return err
+	}
+	var ln net.Listener
+	if g.srv != nil && newConf.Global.ListenAddr != g.conf.Global.ListenAddr {
+		ln, err = net.Listen("tcp", newConf.Global.ListenAddr)
+		if err != nil {
+			tbl.release()
+			chain.Release()
+			return fmt.Errorf("failed to listen on %s: %w", newConf.Global.ListenAddr, err)
+		}
+	}
+
+	// 2) 提交阶段：原子替换
+	changes := Diff(g.conf, newConf)
+	consumer.Set(consumers)
+	g.rm.swap(tbl)
+	g.handler.Store(engine)
+	oldChain := g.chain
+	g.chain = chain
+	g.conf = newConf
+	oldChain.Release()
+
+	if ln != nil {
+		oldSrv := g.srv
+		g.serve(ln)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := oldSrv.Shutdown(ctx); err != nil {
+				log.Printf("[gateway] failed to shutdown old listener: %v", err)
+			}
+		}()
+	}
+
+	snap := g.history.Record(newConf, source)
+	if len(changes) == 0 {
+		log.Printf("[gateway] configuration reloaded as version %d (%s), no changes detected", snap.Version, source)
+	}
+	for _, c := range changes {
+		log.Printf("[gateway] configuration reloaded as version %d (%s): %s", snap.Version, source, c)
+	}
+	return nil
+}
+
+// Rollback 通过正常的重载流程重新应用历史中的第 version 版配置。
+// 回滚只影响当前进程；配置源随后的变更（etcd watch、SIGHUP 等）会覆盖回滚结果。
+func (g *Gateway) Rollback(version int64) error {
+	snap, ok := g.history.Get(version)
+	if !ok {
+		return fmt.Errorf("config version %d not found in history", version)
+	}
+	if snap.Redacted || snap.Config == nil {
+		return fmt.Errorf("config version %d was restored from persisted history with secrets redacted and cannot be rolled back to", version)
+	}
+	return g.Reload(snap.Config, fmt.Sprintf("rollback:%d", version))
+}
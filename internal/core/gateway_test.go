@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LensGateway.com/internal/config"
+)
+
+func TestGatewayRollback(t *testing.T) {
+	v1 := &config.GatewayConfig{
+		Global:    config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{{Name: "a", Hosts: []string{"127.0.0.1:1"}}},
+	}
+	gw, err := NewGateway(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2 := &config.GatewayConfig{
+		Global:    config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{{Name: "b", Hosts: []string{"127.0.0.1:2"}}},
+	}
+	if err := gw.Reload(v2, "sighup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Rollback(42); err == nil {
+		t.Fatal("rollback to an unknown version succeeded")
+	}
+
+	list := gw.History().List()
+	if len(list) != 3 {
+		t.Fatalf("history has %d snapshots, want 3", len(list))
+	}
+	cur := list[2]
+	if cur.Version != 3 || cur.Source != "rollback:1" || cur.Hash != list[0].Hash {
+		t.Fatalf("unexpected current snapshot %+v", cur)
+	}
+	if gw.Config().Upstreams[0].Name != "a" {
+		t.Fatalf("rollback did not restore upstreams: %+v", gw.Config().Upstreams)
+	}
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"config_version":3`) {
+		t.Fatalf("/healthz = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGatewayUpdateUpstreamsPersist(t *testing.T) {
+	gw, err := NewGateway(&config.GatewayConfig{
+		Global:    config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{{Name: "a", Hosts: []string{"127.0.0.1:1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []config.UpstreamConfig{{Name: "b", Hosts: []string{"127.0.0.1:2"}}}
+	persisted := 0
+	persist := func() error { persisted++; return nil }
+
+	// a stale version is rejected before anything is written back
+	if _, err := gw.UpdateUpstreams(b, "admin", 7, persist); !errors.Is(err, ErrVersionConflict) || persisted != 0 {
+		t.Fatalf("stale version: err %v, persisted %d times", err, persisted)
+	}
+	// a failed write-back leaves the config unchanged
+	failed := errors.New("etcd unavailable")
+	if _, err := gw.UpdateUpstreams(b, "admin", 1, func() error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("failed write-back: %v", err)
+	}
+	if gw.Config().Upstreams[0].Name != "a" || gw.History().Current().Version != 1 {
+		t.Fatalf("config changed after a failed write-back: %+v", gw.Config().Upstreams)
+	}
+	snap, err := gw.UpdateUpstreams(b, "admin", 1, persist)
+	if err != nil || persisted != 1 || snap.Version != 2 || gw.Config().Upstreams[0].Name != "b" {
+		t.Fatalf("update: snapshot %+v, err %v, persisted %d times", snap, err, persisted)
+	}
+}
+
+func TestGatewayReloadKeepsConfigOnRouteMiddlewareError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1"))
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+
+	gw, err := NewGateway(&config.GatewayConfig{
+		Global: config.GlobalConfig{ListenAddr: ":0"},
+		Upstreams: []config.UpstreamConfig{{Name: "a", Hosts: []string{host}, Routes: []config.RouteConfig{
+			{Path: "/api/**"},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []config.UpstreamConfig{{Name: "b", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{
+		{Path: "/api/**", Middlewares: []map[string]any{
+			{"name": "acl"},
+			{"name": "rate_limiter", "config": map[string]any{"strategy": "bogus"}},
+		}},
+	}}}
+	err = gw.Reload(&config.GatewayConfig{Global: config.GlobalConfig{ListenAddr: ":0"}, Upstreams: bad}, "sighup")
+	if err == nil || !strings.Contains(err.Error(), "rate_limiter") {
+		t.Fatalf("Reload with a bad route middleware = %v", err)
+	}
+	persisted := false
+	if _, err := gw.UpdateUpstreams(bad, "admin", 1, func() error { persisted = true; return nil }); err == nil || persisted {
+		t.Fatalf("UpdateUpstreams with a bad route middleware = %v, persisted %v", err, persisted)
+	}
+
+	if cur := gw.History().Current(); cur.Version != 1 || gw.Config().Upstreams[0].Name != "a" {
+		t.Fatalf("config changed after failed reloads: version %d, upstreams %+v", cur.Version, gw.Config().Upstreams)
+	}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "v1" {
+		t.Fatalf("GET /api/x after failed reloads = %d %q", resp.StatusCode, body)
+	}
+}
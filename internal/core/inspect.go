@@ -0,0 +1,93 @@
+package core
+
+import (
+	"slices"
+	"sort"
+
+	"LensGateway.com/internal/balancer"
+)
+
+// 运行时路由表的只读视图，供管理接口使用。均从 RouterManager 的原子路由表读取，
+// 反映的是实际生效的状态（例如被跳过的无效上游不会出现）。
+
+// RouteInfo 路由表中的一条路由
+type RouteInfo struct {
+	Prefix      string        `json:"prefix"`
+	Methods     []string      `json:"methods,omitempty"` // 为空表示全部方法
+	Rewrite     string        `json:"rewrite,omitempty"`
+	Upstream    string        `json:"upstream"`
+	Middlewares []string      `json:"middlewares,omitempty"`
+	Fallback    *FallbackInfo `json:"fallback,omitempty"`
+}
+
+// FallbackInfo 路由兜底策略
+type FallbackInfo struct {
+	Upstream string `json:"upstream,omitempty"`
+	Status   int    `json:"status,omitempty"` // 仅配置了静态响应时
+}
+
+// UpstreamInfo 上游及其节点
+type UpstreamInfo struct {
+	Name      string     `json:"name"`
+	Algorithm string     `json:"algorithm"`
+	Nodes     []NodeInfo `json:"nodes"`
+}
+
+// NodeInfo 上游节点状态
+type NodeInfo struct {
+	URL      string `json:"url"`
+	Weight   int    `json:"weight"`
+	Healthy  bool   `json:"healthy"`   // 健康检查摘除后为 false
+	InFlight int64  `json:"in_flight"` // 在途请求数，即 P2C 看到的节点负载
+}
+
+// Routes 按匹配优先级（最长前缀优先）返回当前路由表
+func (rm *RouterManager) Routes() []RouteInfo {
+	tbl, _ := rm.table.Load().(routingTable)
+	routes := make([]RouteInfo, 0, len(tbl.routes))
+	for _, rt := range tbl.routes {
+		info := RouteInfo{
+			Prefix:      rt.prefix,
+			Rewrite:     rt.rewrite,
+			Middlewares: rt.mwNames,
+		}
+		for m := range rt.methods {
+			info.Methods = append(info.Methods, m)
+		}
+		sort.Strings(info.Methods)
+		if rt.balancerIdx >= 0 && rt.balancerIdx < len(tbl.balancers) {
+			info.Upstream = tbl.balancers[rt.balancerIdx].Name()
+		}
+		if fb := rt.fallback; fb != nil {
+			info.Fallback = &FallbackInfo{}
+			if fb.balancerIdx >= 0 {
+				info.Fallback.Upstream = tbl.balancers[fb.balancerIdx].Name()
+			}
+			if fb.static {
+				info.Fallback.Status = fb.status
+			}
+		}
+		routes = append(routes, info)
+	}
+	return routes
+}
+
+// Upstreams 返回全部上游及节点状态
+func (rm *RouterManager) Upstreams() []UpstreamInfo {
+	tbl, _ := rm.table.Load().(routingTable)
+	upstreams := make([]UpstreamInfo, 0, len(tbl.balancers))
+	for i, b := range tbl.balancers {
+		inRotation := b.Hosts()
+		info := UpstreamInfo{Name: b.Name(), Algorithm: b.Algo()}
+		for _, n := range tbl.upstreams[i].nodes {
+			healthy := slices.ContainsFunc(inRotation, func(h balancer.UpstreamNode) bool { return h.Url.Host == n.Url.Host })
+			node := NodeInfo{URL: n.Url.String(), Weight: max(n.Weight, 1), Healthy: healthy}
+			if c := tbl.upstreams[i].inflight[n.Url.Host]; c != nil {
+				node.InFlight = c.Load()
+			}
+			info.Nodes = append(info.Nodes, node)
+		}
+		upstreams = append(upstreams, info)
+	}
+	return upstreams
+}
@@ -2,13 +2,16 @@ package core
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -16,6 +19,7 @@ import (
 	"LensGateway.com/internal/balancer"
 	"LensGateway.com/internal/config"
 	"LensGateway.com/internal/middleware"
+	"LensGateway.com/internal/observe"
 	"github.com/gin-gonic/gin"
 )
 
@@ -26,6 +30,19 @@ type routeEntry struct {
 	methods     map[string]struct{}
 	rewrite     string // 将 prefix 重写为 rewrite
 	middlewares []gin.HandlerFunc
+	mwNames     []string       // 路由级中间件名称，供管理接口展示
+	fallback    *routeFallback // 可选兜底策略
+	primaryDown *atomic.Bool   // 主上游是否无可用节点，只在状态变化时记录日志
+}
+
+// routeFallback 路由兜底：备用上游 + 静态响应
+type routeFallback struct {
+	upstream    string // 备用上游名称（构建完成后解析为 balancerIdx）
+	balancerIdx int    // -1 表示无可用的备用上游
+	static      bool   // 是否配置了静态响应
+	status      int
+	headers     map[string]string
+	body        []byte
 }
 
 // RouterManager 核心路由管理器
@@ -36,14 +53,25 @@ type RouterManager struct {
 
 type routingTable struct {
 	balancers []balancer.Balancer
+	upstreams []upstreamEntry // 与 balancers 一一对应
 	routes    []routeEntry
+	releases  []func() // 路由级中间件的释放函数
+}
+
+// upstreamEntry 上游的配置节点及各节点的在途请求数（健康检查摘除的节点仍保留在这里）
+type upstreamEntry struct {
+	nodes    []balancer.UpstreamNode
+	inflight map[string]*atomic.Int64 // host -> 在途请求数
 }
 
 // NewRouterManager 根据配置构建路由表与上游节点
 func NewRouterManager(upstreams []config.UpstreamConfig, cfgSrc config.ConfigSource) (*RouterManager, error) {
+	tbl, err := buildRoutingTable(upstreams)
+	if err != nil {
+		return nil, err
+	}
 	rm := &RouterManager{configSource: cfgSrc}
-	tbl := buildRoutingTable(upstreams)
-	rm.table.Store(tbl)
+	rm.swap(tbl)
 	return rm, nil
 }
 
@@ -51,7 +79,16 @@ func NewRouterManager(upstreams []config.UpstreamConfig, cfgSrc config.ConfigSou
 // 该方法不做任何转发，仅用于在中间件链前段标注 route.prefix 以供路由级限流等功能使用。
 func (rm *RouterManager) PreMatch(method, path string) (string, bool) {
 	tbl, _ := rm.table.Load().(routingTable)
-	for _, rt := range tbl.routes {
+	if rt := tbl.match(method, path); rt != nil {
+		return rt.prefix, true
+	}
+	return "", false
+}
+
+// match 返回第一个（即前缀最长的）命中的路由
+func (tbl routingTable) match(method, path string) *routeEntry {
+	for i := range tbl.routes {
+		rt := &tbl.routes[i]
 		if !matchPrefix(path, rt.prefix) {
 			continue
 		}
@@ -60,17 +97,29 @@ func (rm *RouterManager) PreMatch(method, path string) (string, bool) {
 				continue
 			}
 		}
-		return rt.prefix, true
+		return rt
 	}
-	return "", false
+	return nil
+}
+
+// upstreamName 返回路由的主上游名称
+func (tbl routingTable) upstreamName(rt *routeEntry) string {
+	if rt.balancerIdx < 0 || rt.balancerIdx >= len(tbl.balancers) {
+		return ""
+	}
+	return tbl.balancers[rt.balancerIdx].Name()
 }
 
-// PreMatchMiddleware 在请求进入业务中间件前尝试匹配路由，并把命中的前缀放入上下文。
+// PreMatchMiddleware 在请求进入业务中间件前尝试匹配路由，并把命中的前缀与主上游名称放入上下文。
 // 这样像 rate_limiter 这样的前置中间件就可以基于 route.prefix 做路由级限流。
+// 路由级指标也在这里记录，被全局中间件拦截的请求（如 429、401）同样计入所属路由。
 func (rm *RouterManager) PreMatchMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if prefix, ok := rm.PreMatch(c.Request.Method, c.Request.URL.Path); ok {
-			c.Set("route.prefix", prefix)
+		tbl, _ := rm.table.Load().(routingTable)
+		if rt := tbl.match(c.Request.Method, c.Request.URL.Path); rt != nil {
+			c.Set("route.prefix", rt.prefix)
+			c.Set("route.upstream", tbl.upstreamName(rt))
+			defer observeRoute(c, rt.prefix, time.Now())
 		}
 		c.Next()
 	}
@@ -95,6 +144,8 @@ func (rm *RouterManager) HandleRequest(c *gin.Context) {
 
 		// 命中路由，执行该路由专属的中间件链
 		c.Set("route.prefix", rt.prefix) // 确保路由级中间件能拿到前缀
+		c.Set("route.upstream", tbl.upstreamName(&rt))
+		defer middleware.BeginRoute(c)()
 		for _, mw := range rt.middlewares {
 			mw(c)
 			if c.IsAborted() {
@@ -110,8 +161,21 @@ func (rm *RouterManager) HandleRequest(c *gin.Context) {
 		balancerx := tbl.balancers[rt.balancerIdx]
 		ip := c.ClientIP()
 		node, err := balancerx.Balance(ip)
+		rt.setPrimaryDown(balancerx.Name(), err)
+		if err != nil && rt.fallback != nil && rt.fallback.balancerIdx >= 0 {
+			// 主上游无可用节点，切换到备用上游
+			observe.RouteFailoverTotal.WithLabelValues(rt.prefix, "upstream").Inc()
+			c.Set("route.fallback", "upstream")
+			balancerx = tbl.balancers[rt.fallback.balancerIdx]
+			node, err = balancerx.Balance(ip)
+		}
 		if err != nil {
-			log.Printf("failed to balance upstream: %v", err)
+			if rt.fallback != nil && rt.fallback.static {
+				observe.RouteFailoverTotal.WithLabelValues(rt.prefix, "static").Inc()
+				rt.fallback.serve(c)
+				return
+			}
+			observe.RouteFailoverTotal.WithLabelValues(rt.prefix, "none").Inc()
 			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "no healthy upstream node available"})
 			return
 		}
@@ -129,6 +193,19 @@ func (rm *RouterManager) HandleRequest(c *gin.Context) {
 		c.Set("upstream.host", node.Url.String())
 		// 设置 path（Director 中也会校正）
 		c.Request.URL.Path = newPath
+		// 记录在途请求数（P2C 据此选择负载较低的节点）
+		host := node.Url.Host
+		balancerx.Inc(host)
+		counter := tbl.inflight(balancerx, host)
+		if counter != nil {
+			counter.Add(1)
+		}
+		defer func() {
+			balancerx.Done(host)
+			if counter != nil {
+				counter.Add(-1)
+			}
+		}()
 		proxy.ServeHTTP(c.Writer, c.Request)
 		return
 	}
@@ -137,13 +214,66 @@ func (rm *RouterManager) HandleRequest(c *gin.Context) {
 	c.JSON(http.StatusNotFound, gin.H{"error": "no route matched"})
 }
 
-// UpdateUpstreams 用新的上游配置重建表并原子替换
-func (rm *RouterManager) UpdateUpstreams(upstreams []config.UpstreamConfig) {
-	tbl := buildRoutingTable(upstreams)
-	rm.table.Store(tbl)
+// setPrimaryDown 记录主上游的可用状态。故障期间每个请求都会走兜底，
+// 因此只在状态变化时记录日志，请求数见 route_failover_total 指标
+func (rt *routeEntry) setPrimaryDown(upstream string, err error) {
+	if err == nil {
+		if rt.primaryDown.CompareAndSwap(true, false) {
+			log.Printf("[gateway] upstream %q of route %s is available again", upstream, rt.prefix)
+		}
+		return
+	}
+	if !rt.primaryDown.CompareAndSwap(false, true) {
+		return
+	}
+	switch {
+	case rt.fallback != nil && rt.fallback.balancerIdx >= 0:
+		log.Printf("[gateway] upstream %q of route %s unavailable (%v), failing over to %q", upstream, rt.prefix, err, rt.fallback.upstream)
+	case rt.fallback != nil && rt.fallback.static:
+		log.Printf("[gateway] upstream %q of route %s unavailable (%v), serving the static fallback", upstream, rt.prefix, err)
+	default:
+		log.Printf("[gateway] upstream %q of route %s unavailable (%v), answering 502", upstream, rt.prefix, err)
+	}
+}
+
+// UpdateUpstreams 用新的上游配置重建表并原子替换；构建失败时保留当前路由表
+func (rm *RouterManager) UpdateUpstreams(upstreams []config.UpstreamConfig) error {
+	tbl, err := buildRoutingTable(upstreams)
+	if err != nil {
+		return err
+	}
+	rm.swap(tbl)
+	return nil
 }
 
-func buildRoutingTable(upstreams []config.UpstreamConfig) routingTable {
+// swap 原子替换路由表：为新表启动健康检查，并释放旧表的路由级中间件
+func (rm *RouterManager) swap(tbl routingTable) {
+	old, _ := rm.table.Swap(tbl).(routingTable)
+	// start health check
+	balancer.HealthCheckAll(tbl.balancers, 30)
+	old.release()
+}
+
+// inflight 返回节点的在途请求计数器，未知节点返回 nil
+func (tbl routingTable) inflight(b balancer.Balancer, host string) *atomic.Int64 {
+	for i, bb := range tbl.balancers {
+		if bb == b {
+			return tbl.upstreams[i].inflight[host]
+		}
+	}
+	return nil
+}
+
+// release 释放路由表持有的中间件资源
+func (tbl routingTable) release() {
+	for _, release := range tbl.releases {
+		release()
+	}
+}
+
+// buildRoutingTable 构建路由表。无效的节点与上游记录日志后跳过；
+// 路由级中间件创建失败时释放已创建的中间件并返回错误，调用方应保留当前路由表
+func buildRoutingTable(upstreams []config.UpstreamConfig) (routingTable, error) {
 	var tbl routingTable
 
 	for _, up := range upstreams {
@@ -166,24 +296,24 @@ func buildRoutingTable(upstreams []config.UpstreamConfig) routingTable {
 			} else {
 				u = &url.URL{Scheme: scheme, Host: host}
 			}
-			nodes = append(nodes, balancer.UpstreamNode{Url: u})
+			nodes = append(nodes, balancer.UpstreamNode{Url: u, Weight: nodeWeight(up.Weights, host)})
 		}
 		if len(nodes) == 0 {
 			log.Printf("upstream %q has no valid nodes; skipping", up.Name)
 			continue
 		}
 
-		algo := strings.ToLower(up.LoadBalancing)
-		if algo == "" {
-			algo = "round_robin"
-		}
-
-		balancerx, err := balancer.Build(up.Name, algo, nodes)
+		balancerx, err := balancer.Build(up.Name, normalizeAlgo(up.LoadBalancing), nodes)
 		if err != nil {
 			log.Printf("failed to build balancer for upstream %q: %v", up.Name, err)
 			continue
 		}
 		tbl.balancers = append(tbl.balancers, balancerx)
+		inflight := make(map[string]*atomic.Int64, len(nodes))
+		for _, n := range nodes {
+			inflight[n.Url.Host] = new(atomic.Int64)
+		}
+		tbl.upstreams = append(tbl.upstreams, upstreamEntry{nodes: nodes, inflight: inflight})
 
 		// parse node
 		for _, r := range up.Routes {
@@ -195,27 +325,22 @@ func buildRoutingTable(upstreams []config.UpstreamConfig) routingTable {
 
 			// 创建路由级中间件
 			var routeMiddlewares []gin.HandlerFunc
+			var mwNames []string
 			for _, mwConf := range r.Middlewares {
 				mwName, _ := mwConf["name"].(string)
 				if mwName == "" {
-					log.Printf("skip middleware with empty name on route %s", prefix)
-					continue
-				}
-				creator, err := middleware.GetCreator(mwName)
-				if err != nil {
-					log.Printf("skip unregistered middleware %q on route %s", mwName, prefix)
-					continue
+					tbl.release()
+					return routingTable{}, fmt.Errorf("route %s: middleware without a name", prefix)
 				}
 				mwCfg, _ := mwConf["config"].(map[string]any)
-				if mwCfg == nil {
-					mwCfg = make(map[string]any)
-				}
-				handler, err := creator(mwCfg)
+				handler, release, err := middleware.New(mwName, mwCfg)
 				if err != nil {
-					log.Printf("failed to create middleware %q on route %s: %v", mwName, prefix, err)
-					continue
+					tbl.release()
+					return routingTable{}, fmt.Errorf("route %s: failed to create middleware %s: %v", prefix, mwName, err)
 				}
 				routeMiddlewares = append(routeMiddlewares, handler)
+				mwNames = append(mwNames, mwName)
+				tbl.releases = append(tbl.releases, release)
 			}
 
 			tbl.routes = append(tbl.routes, routeEntry{
@@ -224,16 +349,90 @@ func buildRoutingTable(upstreams []config.UpstreamConfig) routingTable {
 				methods:     methods,
 				rewrite:     r.Rewrite,
 				middlewares: routeMiddlewares,
+				mwNames:     mwNames,
+				fallback:    newRouteFallback(r.Fallback),
+				primaryDown: new(atomic.Bool),
 			})
 		}
 	}
 
-	// start health check
-	balancer.HealthCheckAll(tbl.balancers, 30)
+	// 所有上游构建完成后，再解析备用上游名称
+	balancerIdx := make(map[string]int, len(tbl.balancers))
+	for i, b := range tbl.balancers {
+		balancerIdx[b.Name()] = i
+	}
+	for _, rt := range tbl.routes {
+		if rt.fallback == nil || rt.fallback.upstream == "" {
+			continue
+		}
+		idx, ok := balancerIdx[rt.fallback.upstream]
+		if !ok {
+			log.Printf("fallback upstream %q of route %s not found; ignoring", rt.fallback.upstream, rt.prefix)
+			continue
+		}
+		rt.fallback.balancerIdx = idx
+	}
 
 	// perfer match route with longer prefix
 	sort.Slice(tbl.routes, func(i, j int) bool { return len(tbl.routes[i].prefix) > len(tbl.routes[j].prefix) })
-	return tbl
+	return tbl, nil
+}
+
+// observeRoute 记录路由级请求数与耗时（包括被全局或路由级中间件拦截的请求）
+func observeRoute(c *gin.Context, prefix string, start time.Time) {
+	observe.RouteRequestsTotal.WithLabelValues(prefix, strconv.Itoa(c.Writer.Status())).Inc()
+	observe.RouteRequestDurationSeconds.WithLabelValues(prefix).Observe(time.Since(start).Seconds())
+}
+
+// nodeWeight 返回节点权重；配置键可能被 viper 转为小写，因此也按小写查找
+func nodeWeight(weights map[string]int, host string) int {
+	if w, ok := weights[host]; ok {
+		return w
+	}
+	return weights[strings.ToLower(host)]
+}
+
+// newRouteFallback 将兜底配置转换为路由表项，未配置时返回 nil
+func newRouteFallback(fc *config.FallbackConfig) *routeFallback {
+	if fc == nil {
+		return nil
+	}
+	fb := &routeFallback{
+		upstream:    fc.Upstream,
+		balancerIdx: -1,
+		static:      fc.Status != 0 || fc.Body != "" || len(fc.Headers) > 0,
+		status:      fc.Status,
+		headers:     fc.Headers,
+		body:        []byte(fc.Body),
+	}
+	if fb.status == 0 {
+		fb.status = http.StatusServiceUnavailable
+	}
+	if fb.upstream == "" && !fb.static {
+		return nil
+	}
+	return fb
+}
+
+// serve 输出静态兜底响应
+func (fb *routeFallback) serve(c *gin.Context) {
+	contentType := ""
+	for k, v := range fb.headers {
+		if strings.EqualFold(k, "Content-Type") {
+			contentType = v
+			continue
+		}
+		c.Header(k, v)
+	}
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+		if json.Valid(fb.body) {
+			contentType = "application/json; charset=utf-8"
+		}
+	}
+	c.Set("route.fallback", "static")
+	c.Data(fb.status, contentType, fb.body)
+	c.Abort()
 }
 
 // 将 pattern 转换为标准前缀（去掉 /** 并确保以 / 结尾，便于前缀替换）
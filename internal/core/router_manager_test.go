@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/observe"
+)
+
+// fallbackTestServer 启动一个只挂载 HandleRequest 的网关，返回其地址与路由管理器
+func fallbackTestServer(t *testing.T, upstreams []config.UpstreamConfig) (string, *RouterManager) {
+	t.Helper()
+	rm, err := NewRouterManager(upstreams, config.ConfigSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(rm.HandleRequest)
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		tbl, _ := rm.table.Load().(routingTable)
+		tbl.release()
+	})
+	return srv.URL, rm
+}
+
+// takeDown 把上游的所有节点移出负载均衡，模拟健康检查全部摘除
+func takeDown(t *testing.T, rm *RouterManager, name string) {
+	t.Helper()
+	tbl, _ := rm.table.Load().(routingTable)
+	for _, b := range tbl.balancers {
+		if b.Name() != name {
+			continue
+		}
+		for _, n := range b.Hosts() {
+			b.Remove(n)
+		}
+		return
+	}
+	t.Fatalf("upstream %q not found", name)
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestFallbackToBackupUpstream(t *testing.T) {
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "from backup")
+	}))
+	defer backup.Close()
+
+	url, rm := fallbackTestServer(t, []config.UpstreamConfig{
+		{Name: "primary", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{
+			{Path: "/fb-upstream/**", Fallback: &config.FallbackConfig{Upstream: "backup"}},
+		}},
+		{Name: "backup", Hosts: []string{strings.TrimPrefix(backup.URL, "http://")}},
+	})
+	takeDown(t, rm, "primary")
+
+	counter := observe.RouteFailoverTotal.WithLabelValues("/fb-upstream/", "upstream")
+	before := testutil.ToFloat64(counter)
+	for i := 0; i < 3; i++ {
+		resp, body := get(t, url+"/fb-upstream/x")
+		if resp.StatusCode != http.StatusOK || body != "from backup" {
+			t.Fatalf("request %d = %d %q", i, resp.StatusCode, body)
+		}
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 3 {
+		t.Fatalf("route_failover_total{action=upstream} grew by %v, want 3", got)
+	}
+}
+
+func TestFallbackStaticResponse(t *testing.T) {
+	url, rm := fallbackTestServer(t, []config.UpstreamConfig{
+		{Name: "primary", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{
+			{Path: "/fb-static/**", Fallback: &config.FallbackConfig{
+				Status:  http.StatusServiceUnavailable,
+				Headers: map[string]string{"Retry-After": "30"},
+				Body:    "maintenance",
+			}},
+		}},
+	})
+	takeDown(t, rm, "primary")
+
+	resp, body := get(t, url+"/fb-static/x")
+	if resp.StatusCode != http.StatusServiceUnavailable || body != "maintenance" || resp.Header.Get("Retry-After") != "30" {
+		t.Fatalf("static fallback = %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	if got := testutil.ToFloat64(observe.RouteFailoverTotal.WithLabelValues("/fb-static/", "static")); got != 1 {
+		t.Fatalf("route_failover_total{action=static} = %v, want 1", got)
+	}
+}
+
+func TestFallbackAllBackendsDown(t *testing.T) {
+	url, rm := fallbackTestServer(t, []config.UpstreamConfig{
+		{Name: "primary", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{
+			{Path: "/fb-down/**", Fallback: &config.FallbackConfig{Upstream: "backup"}},
+		}},
+		{Name: "backup", Hosts: []string{"127.0.0.1:2"}},
+	})
+	takeDown(t, rm, "primary")
+	takeDown(t, rm, "backup")
+
+	resp, body := get(t, url+"/fb-down/x")
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, "no healthy upstream") {
+		t.Fatalf("all backends down = %d %q", resp.StatusCode, body)
+	}
+	if got := testutil.ToFloat64(observe.RouteFailoverTotal.WithLabelValues("/fb-down/", "none")); got != 1 {
+		t.Fatalf("route_failover_total{action=none} = %v, want 1", got)
+	}
+}
+
+func TestFailoverLoggedOncePerStateChange(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	url, rm := fallbackTestServer(t, []config.UpstreamConfig{
+		{Name: "primary", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{
+			{Path: "/fb-log/**", Fallback: &config.FallbackConfig{Body: "down"}},
+		}},
+	})
+	takeDown(t, rm, "primary")
+	for i := 0; i < 5; i++ {
+		get(t, url+"/fb-log/x")
+	}
+	if n := strings.Count(buf.String(), "unavailable"); n != 1 {
+		t.Fatalf("logged %d failovers for 5 requests:\n%s", n, buf.String())
+	}
+
+	tbl, _ := rm.table.Load().(routingTable)
+	tbl.routes[0].setPrimaryDown("primary", nil)
+	tbl.routes[0].setPrimaryDown("primary", nil)
+	if n := strings.Count(buf.String(), "available again"); n != 1 {
+		t.Fatalf("logged %d recoveries:\n%s", n, buf.String())
+	}
+}
+
+func TestRouteMetricsCountGloballyRejectedRequests(t *testing.T) {
+	rm, err := NewRouterManager([]config.UpstreamConfig{
+		{Name: "primary", Hosts: []string{"127.0.0.1:1"}, Routes: []config.RouteConfig{{Path: "/metrics-route/**"}}},
+	}, config.ConfigSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	takeDown(t, rm, "primary")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(rm.PreMatchMiddleware(), func(c *gin.Context) {
+		// 模拟全局中间件（如 key_auth）拦截请求
+		if c.GetHeader("Authorization") == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	r.NoRoute(rm.HandleRequest)
+
+	rejected := observe.RouteRequestsTotal.WithLabelValues("/metrics-route/", "401")
+	forwarded := observe.RouteRequestsTotal.WithLabelValues("/metrics-route/", "502")
+	beforeRejected, beforeForwarded := testutil.ToFloat64(rejected), testutil.ToFloat64(forwarded)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics-route/x", nil))
+	req := httptest.NewRequest(http.MethodGet, "/metrics-route/x", nil)
+	req.Header.Set("Authorization", "Bearer t")
+	rec2 := httptest.NewRecorder()
+	r.ServeHTTP(rec2, req)
+	if rec.Code != http.StatusUnauthorized || rec2.Code != http.StatusBadGateway {
+		t.Fatalf("responses %d %d", rec.Code, rec2.Code)
+	}
+	if got := testutil.ToFloat64(rejected) - beforeRejected; got != 1 {
+		t.Fatalf("rejected requests counted %v times, want 1", got)
+	}
+	if got := testutil.ToFloat64(forwarded) - beforeForwarded; got != 1 {
+		t.Fatalf("forwarded requests counted %v times, want 1", got)
+	}
+}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"LensGateway.com/internal/balancer"
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/consumer"
+	"LensGateway.com/internal/middleware"
+)
+
+// buildRoutingTable 和 BuildChain 对部分错误配置是宽容的（无效节点、未注册的全局中间件记录日志后跳过），
+// Validate 则把这些问题全部找出来，连同配置路径一起返回，供 validate 子命令与严格模式使用。
+
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true,
+	http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// ValidateFile 加载并校验配置文件（含 include 片段），包括未知配置项检查。
+// 加载失败时返回加载错误；否则返回的 error 为 config.ValidationError 或 nil。
+func ValidateFile(path string) (*config.GatewayConfig, error) {
+	conf, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	errs, err := config.UnknownKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	errs = append(errs, Validate(conf)...)
+	if len(errs) > 0 {
+		return conf, config.ValidationError(errs)
+	}
+	return conf, nil
+}
+
+// Validate 校验完整配置，返回全部问题
+func Validate(conf *config.GatewayConfig) []config.FieldError {
+	v := &validator{}
+	v.global(conf.Global)
+
+	names := make([]string, 0, len(conf.Middlewares))
+	for name := range conf.Middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mw := conf.Middlewares[name]
+		// 未启用的中间件不会被创建，不做检查
+		if mw.Enabled {
+			v.middleware("middlewares."+name, name, mw.Config)
+		}
+	}
+
+	v.upstreams(conf.Upstreams)
+	v.errs = append(v.errs, consumer.Check(conf.Consumers)...)
+	if a := conf.Admin; a.Enabled && a.Embedded && a.Token == "" {
+		v.addf("admin.token", "required with admin.embedded, the admin API would be served unauthenticated on the gateway listener")
+	}
+	return v.errs
+}
+
+type validator struct {
+	errs []config.FieldError
+}
+
+func (v *validator) addf(path, format string, args ...any) {
+	v.errs = append(v.errs, config.FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) global(g config.GlobalConfig) {
+	if g.ListenAddr == "" {
+		v.addf("global.listen_addr", "required")
+	} else if _, _, err := net.SplitHostPort(g.ListenAddr); err != nil {
+		v.addf("global.listen_addr", "invalid address %q: %v", g.ListenAddr, err)
+	}
+	for i, p := range g.TrustedProxies {
+		if net.ParseIP(p) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			v.addf(fmt.Sprintf("global.trusted_proxies[%d]", i), "%q is neither an IP nor a CIDR", p)
+		}
+	}
+}
+
+// middleware 检查中间件已注册并校验其配置（含拼写错误的配置项），不打开中间件持有的存储、连接等资源
+func (v *validator) middleware(path, name string, cfg map[string]any) {
+	if !middleware.Registered(name) {
+		v.addf(path, "middleware %q is not registered", name)
+		return
+	}
+	for _, key := range middleware.UnknownKeys(name, cfg) {
+		v.addf(path+".config."+key, "unknown key")
+	}
+	if err := middleware.Check(name, cfg); err != nil {
+		v.addf(path+".config", "%v", err)
+	}
+}
+
+func (v *validator) upstreams(upstreams []config.UpstreamConfig) {
+	names := make(map[string]int, len(upstreams))
+	for i, up := range upstreams {
+		if up.Name == "" {
+			continue
+		}
+		if _, dup := names[up.Name]; !dup {
+			names[up.Name] = i
+		}
+	}
+
+	type routeRef struct {
+		path    string
+		methods map[string]bool // 为空表示全部方法
+	}
+	routes := make(map[string][]routeRef) // 规范化后的前缀 -> 路由
+
+	for i, up := range upstreams {
+		path := fmt.Sprintf("upstreams[%d]", i)
+		switch first, ok := names[up.Name]; {
+		case up.Name == "":
+			v.addf(path+".name", "required")
+		case ok && first != i:
+			v.addf(path+".name", "duplicate upstream name %q (also upstreams[%d])", up.Name, first)
+		}
+
+		scheme := strings.ToLower(up.Scheme)
+		if scheme != "" && scheme != "http" && scheme != "https" {
+			v.addf(path+".scheme", "unsupported scheme %q, want http or https", up.Scheme)
+			scheme = ""
+		}
+		if scheme == "" {
+			scheme = "http"
+		}
+		if len(up.Hosts) == 0 {
+			v.addf(path+".hosts", "at least one host is required")
+		}
+		for j, host := range up.Hosts {
+			if err := validateHost(scheme, host); err != nil {
+				v.addf(fmt.Sprintf("%s.hosts[%d]", path, j), "%v", err)
+			}
+		}
+
+		if !slices.Contains(balancer.Algorithms(), normalizeAlgo(up.LoadBalancing)) {
+			v.addf(path+".load_balancing", "unknown algorithm %q (supported: %s)", up.LoadBalancing, strings.Join(balancer.Algorithms(), ", "))
+		}
+		for _, host := range slices.Sorted(maps.Keys(up.Weights)) {
+			wpath := path + ".weights." + host
+			if !slices.ContainsFunc(up.Hosts, func(h string) bool { return strings.EqualFold(h, host) }) {
+				v.addf(wpath, "host %q is not in hosts", host)
+			}
+			if up.Weights[host] < 1 {
+				v.addf(wpath, "weight must be at least 1")
+			}
+		}
+		if len(up.Weights) > 0 && normalizeAlgo(up.LoadBalancing) != balancer.R2Balancer {
+			v.addf(path+".weights", "weights are only honoured by %s", balancer.R2Balancer)
+		}
+
+		for j, r := range up.Routes {
+			rpath := fmt.Sprintf("%s.routes[%d]", path, j)
+			if !v.routePath(rpath, r) {
+				continue
+			}
+			methods := make(map[string]bool, len(r.Methods))
+			for k, m := range r.Methods {
+				m = strings.ToUpper(m)
+				if !httpMethods[m] {
+					v.addf(fmt.Sprintf("%s.methods[%d]", rpath, k), "unknown HTTP method %q", r.Methods[k])
+				}
+				methods[m] = true
+			}
+
+			// 相同前缀且方法有交集的路由，只有一条能被匹配到
+			prefix := normalizePrefix(r.Path)
+			for _, other := range routes[prefix] {
+				if methodsOverlap(methods, other.methods) {
+					v.addf(rpath, "route %q is shadowed by %s with the same prefix", r.Path, other.path)
+					break
+				}
+			}
+			routes[prefix] = append(routes[prefix], routeRef{path: rpath, methods: methods})
+
+			for k, mwConf := range r.Middlewares {
+				mpath := fmt.Sprintf("%s.middlewares[%d]", rpath, k)
+				for _, key := range slices.Sorted(maps.Keys(mwConf)) {
+					if key != "name" && key != "config" {
+						v.addf(mpath+"."+key, "unknown key")
+					}
+				}
+				name, _ := mwConf["name"].(string)
+				if name == "" {
+					v.addf(mpath+".name", "required")
+					continue
+				}
+				cfg, ok := mwConf["config"].(map[string]any)
+				if !ok && mwConf["config"] != nil {
+					v.addf(mpath+".config", "must be a map")
+					continue
+				}
+				v.middleware(mpath, name, cfg)
+			}
+
+			if fb := r.Fallback; fb != nil {
+				if fb.Upstream != "" {
+					if _, ok := names[fb.Upstream]; !ok {
+						v.addf(rpath+".fallback.upstream", "upstream %q not found", fb.Upstream)
+					} else if fb.Upstream == up.Name {
+						v.addf(rpath+".fallback.upstream", "route cannot fall back to its own upstream")
+					}
+				}
+				if fb.Status != 0 && (fb.Status < 100 || fb.Status > 599) {
+					v.addf(rpath+".fallback.status", "invalid HTTP status %d", fb.Status)
+				}
+			}
+		}
+	}
+}
+
+// routePath 检查路由路径与重写目标，路径无效时返回 false
+func (v *validator) routePath(path string, r config.RouteConfig) bool {
+	if r.Rewrite != "" && !strings.HasPrefix(r.Rewrite, "/") {
+		v.addf(path+".rewrite", "must start with /")
+	}
+	switch {
+	case r.Path == "":
+		v.addf(path+".path", "required")
+	case !strings.HasPrefix(r.Path, "/"):
+		v.addf(path+".path", "must start with /")
+	case strings.Contains(strings.TrimSuffix(r.Path, "/**"), "*"):
+		// 仅支持前缀匹配，通配符只能以 /** 结尾出现
+		v.addf(path+".path", "wildcards are only supported as a trailing /**")
+	default:
+		return true
+	}
+	return false
+}
+
+func methodsOverlap(a, b map[string]bool) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for m := range a {
+		if b[m] {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHost 与 buildRoutingTable 的解析规则一致：带 scheme 的完整地址，或 host[:port]
+func validateHost(scheme, host string) error {
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return fmt.Errorf("invalid host %q: %v", host, err)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("invalid host %q: missing host", host)
+		}
+		return nil
+	}
+	u, err := url.Parse(scheme + "://" + host)
+	if err != nil || u.Host != host || u.Hostname() == "" {
+		return fmt.Errorf("invalid host %q, want host[:port] or a full http(s) URL", host)
+	}
+	if port := u.Port(); port != "" {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("invalid port in host %q", host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"LensGateway.com/internal/config"
+)
+
+func TestValidateFileReportsEveryProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	err := os.WriteFile(path, []byte(`
+global:
+  listen_addr: "7000"
+  trusted_proxies: ["10.0.0.0/8", "not-an-ip"]
+middlewares:
+  cors:
+    enabled: true
+    config:
+      allow_origns: "*"
+  url_rewriter:
+    enabled: true
+  unknown_but_disabled:
+    enabled: false
+upstreams:
+  - name: users
+    hosts: ["localhost:8081", "http://"]
+    load_balancing: round_robin
+    load_balance: p2c
+    routes:
+      - path: /api/users/**
+        methods: ["GET", "FETCH"]
+        middlewares:
+          - name: acl
+            conf: {}
+          - name: nope
+          - name: rate_limiter
+            config:
+              requets_per_second: 5
+      - path: /api/users
+        methods: ["get"]
+        fallback:
+          upstream: orders
+          status: 99
+  - name: users
+    hosts: ["localhost:9091"]
+    routes:
+      - path: api/orders/**
+consumers:
+  - name: billing
+    keys: ["sha256:1ec1c26b50d5d3c58d9583181af8076655fe00756bf7285940ba3670f99fcba0", "plain-key"]
+  - name: billing
+    keys: ["SHA256:1EC1C26B50D5D3C58D9583181AF8076655FE00756BF7285940BA3670F99FCBA0"]
+    group: ["x"]
+admin:
+  enabled: true
+  embedded: true
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ValidateFile(path)
+	var verr config.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ValidateFile returned %v, want a ValidationError", err)
+	}
+	got := make(map[string]string, len(verr))
+	for _, fe := range verr {
+		got[fe.Path] = fe.Message
+	}
+	want := map[string]string{
+		"global.listen_addr":                                              "invalid address",
+		"global.trusted_proxies[1]":                                       "neither an IP nor a CIDR",
+		"middlewares.cors.config.allow_origns":                            "unknown key",
+		"middlewares.url_rewriter":                                        "not registered",
+		"upstreams[0].load_balance":                                       "unknown key",
+		"upstreams[0].hosts[1]":                                           "missing host",
+		"upstreams[0].load_balancing":                                     `unknown algorithm "round_robin"`,
+		"upstreams[0].routes[0].methods[1]":                               "unknown HTTP method",
+		"upstreams[0].routes[0].middlewares[0].conf":                      "unknown key",
+		"upstreams[0].routes[0].middlewares[1]":                           "not registered",
+		"upstreams[0].routes[0].middlewares[2].config.requets_per_second": "unknown key",
+		"upstreams[0].routes[1]":                                          "shadowed by upstreams[0].routes[0]",
+		"upstreams[0].routes[1].fallback.upstream":                        `"orders" not found`,
+		"upstreams[0].routes[1].fallback.status":                          "invalid HTTP status",
+		"upstreams[1].name":                                               "duplicate upstream name",
+		"upstreams[1].routes[0].path":                                     "must start with /",
+		"consumers[0].keys[1]":                                            "must be a SHA-256 digest",
+		"consumers[1].name":                                               "duplicate consumer name",
+		"consumers[1].keys[0]":                                            `already used by consumer "billing"`,
+		"consumers[1].group":                                              "unknown key",
+		"admin.token":                                                     "required with admin.embedded",
+	}
+	for path, msg := range want {
+		if !strings.Contains(got[path], msg) {
+			t.Errorf("%s: got %q, want it to contain %q", path, got[path], msg)
+		}
+	}
+	if len(verr) != len(want) {
+		t.Errorf("got %d errors, want %d:\n%v", len(verr), len(want), verr)
+	}
+}
@@ -26,8 +26,9 @@ type Entry struct {
 	} `json:"gateway"`
 
 	Auth struct {
-		UserID string `json:"user_id,omitempty"`
-		Status string `json:"status,omitempty"`
+		UserID   string `json:"user_id,omitempty"`
+		Consumer string `json:"consumer,omitempty"`
+		Status   string `json:"status,omitempty"`
 	} `json:"auth"`
 
 	Error string `json:"error,omitempty"`
@@ -74,6 +75,9 @@ func (e *Entry) MarshalZerologObject(enc *zerolog.Event) {
 	if e.Auth.UserID != "" {
 		enc.Str("user_id", e.Auth.UserID)
 	}
+	if e.Auth.Consumer != "" {
+		enc.Str("consumer", e.Auth.Consumer)
+	}
 	if e.Auth.Status != "" {
 		enc.Str("auth_status", e.Auth.Status)
 	}
@@ -5,15 +5,19 @@ import (
 	"time"
 
 	"LensGateway.com/internal/middleware"
+	"LensGateway.com/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 func init() {
-	middleware.Register("logging", func(cfg map[string]any) (gin.HandlerFunc, error) {
+	middleware.RegisterKeys("logging", "buffer_size", "client_error_as_warn", "latency_warn_ms", "warn_latency_ms")
+	// The factory starts the log writer goroutine; the options need no other checks.
+	middleware.RegisterChecker("logging", func(map[string]any) error { return nil })
+	middleware.RegisterFactory("logging", func(cfg map[string]any) (gin.HandlerFunc, func(), error) {
 
 		// Init logging service.
-		bufferSize := cfg["buffer_size"].(int)
+		bufferSize := util.IntOr(cfg["buffer_size"], 0)
 		loggingService := NewService(bufferSize)
 		loggingService.Start()
 
@@ -55,13 +59,17 @@ func init() {
 				entry.Auth.UserID, _ = sub.(string)
 				entry.Auth.Status = "success"
 			}
+			if name := c.GetString("consumer"); name != "" {
+				entry.Auth.Consumer = name
+				entry.Auth.Status = "success"
+			}
 
 			// Determine level based on errors, upstream status and latency (configurable).
 			entry.Level = determineLevel(c, entry, cfg)
 
 			// hand the filled log entry to the logging service asynchronously.
 			loggingService.Log(entry)
-		}, nil
+		}, loggingService.Stop, nil
 	})
 }
 
@@ -3,6 +3,7 @@ package logging
 import (
 	"log"
 	"os"
+	"sync"
 
 	"github.com/rs/zerolog"
 )
@@ -10,6 +11,8 @@ import (
 // Global Logger Monitor, responsible for asynchronous processing and output of logs.
 type Service struct {
 	logChan chan *Entry
+	stop    chan struct{}
+	once    sync.Once
 	logger  zerolog.Logger
 }
 
@@ -21,6 +24,7 @@ func NewService(bufferSize int) *Service {
 	zlogger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	return &Service{
 		logChan: make(chan *Entry, bufferSize),
+		stop:    make(chan struct{}),
 		logger:  zlogger,
 	}
 }
@@ -28,22 +32,45 @@ func NewService(bufferSize int) *Service {
 // Start background task that continuously consumes logs from the channel.
 func (s *Service) Start() {
 	go func() {
-		for entry := range s.logChan {
-			// Choose log level based on entry.Level; default to info.
-			switch entry.Level {
-			case "error", "err":
-				s.logger.Error().EmbedObject(entry).Msg("")
-			case "warn", "warning":
-				s.logger.Warn().EmbedObject(entry).Msg("")
-			case "debug":
-				s.logger.Debug().EmbedObject(entry).Msg("")
-			default:
-				s.logger.Info().EmbedObject(entry).Msg("")
+		for {
+			select {
+			case entry := <-s.logChan:
+				s.write(entry)
+			case <-s.stop:
+				// Flush whatever is already buffered before exiting.
+				for {
+					select {
+					case entry := <-s.logChan:
+						s.write(entry)
+					default:
+						return
+					}
+				}
 			}
 		}
 	}()
 }
 
+// Stop terminates the background consumer after flushing buffered entries.
+// The channel is never closed, so late Log calls from in-flight requests are safe.
+func (s *Service) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+func (s *Service) write(entry *Entry) {
+	// Choose log level based on entry.Level; default to info.
+	switch entry.Level {
+	case "error", "err":
+		s.logger.Error().EmbedObject(entry).Msg("")
+	case "warn", "warning":
+		s.logger.Warn().EmbedObject(entry).Msg("")
+	case "debug":
+		s.logger.Debug().EmbedObject(entry).Msg("")
+	default:
+		s.logger.Info().EmbedObject(entry).Msg("")
+	}
+}
+
 // Privoide a non-blocking way to send log entries to the channel.
 func (s *Service) Log(entry *Entry) {
 	// select-default scheme for non-blocking send
@@ -3,21 +3,34 @@ package middleware
 import (
 	"net"
 	"net/http"
+	"slices"
 
 	"LensGateway.com/util"
 	"github.com/gin-gonic/gin"
 )
 
-// Simple IP-based ACL. Config:
+// Simple IP and consumer ACL. Config:
 //
 //	whitelist: ["127.0.0.1/32", "10.0.0.0/8"]
 //	blacklist: ["192.168.1.100/32"]
+//	allow_consumers: ["billing"]
+//	allow_groups: ["partners"]
+//	deny_consumers: ["legacy-app"]
+//	deny_groups: ["suspended"]
 //
 // If whitelist not empty, only allow those; blacklist always deny.
+// Consumers are identified by key_auth. If any allow list is set, only consumers
+// named there or in an allowed group pass (anonymous requests are denied);
+// the deny lists always deny.
 func init() {
+	RegisterKeys("acl", "whitelist", "blacklist", "allow_consumers", "allow_groups", "deny_consumers", "deny_groups")
 	Register("acl", func(cfg map[string]any) (gin.HandlerFunc, error) {
 		whitelist := util.ParseCIDRs(util.ToStringSlice(cfg["whitelist"]))
 		blacklist := util.ParseCIDRs(util.ToStringSlice(cfg["blacklist"]))
+		allowConsumers := util.ToStringSlice(cfg["allow_consumers"])
+		allowGroups := util.ToStringSlice(cfg["allow_groups"])
+		denyConsumers := util.ToStringSlice(cfg["deny_consumers"])
+		denyGroups := util.ToStringSlice(cfg["deny_groups"])
 
 		return func(c *gin.Context) {
 			ipStr := util.ClientIP(c.Request)
@@ -47,6 +60,22 @@ func init() {
 					return
 				}
 			}
+			// consumer rules
+			name := c.GetString("consumer")
+			groups := c.GetStringSlice("consumer.groups")
+			inGroup := func(list []string) bool {
+				return slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(list, g) })
+			}
+			if name != "" && (slices.Contains(denyConsumers, name) || inGroup(denyGroups)) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "blocked"})
+				return
+			}
+			if len(allowConsumers) > 0 || len(allowGroups) > 0 {
+				if name == "" || !(slices.Contains(allowConsumers, name) || inGroup(allowGroups)) {
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+					return
+				}
+			}
 			c.Next()
 		}, nil
 	})
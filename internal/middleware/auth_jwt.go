@@ -12,6 +12,7 @@ import (
 )
 
 func init() {
+	RegisterKeys("auth_jwt", "secret_key", "token_lookup")
 	Register("auth_jwt", func(cfg map[string]any) (gin.HandlerFunc, error) {
 		secret := util.StrOr(cfg["secret_key"], "")
 		if secret == "" {
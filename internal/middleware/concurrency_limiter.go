@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"LensGateway.com/internal/concurrency"
+	"LensGateway.com/util"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	RegisterKeys("concurrency_limiter", "scope", "algorithm", "initial_limit", "min_limit", "max_limit",
+		"queue_size", "timeout", "queue_timeout", "retry_after")
+	RegisterFactory("concurrency_limiter", func(cfg map[string]any) (gin.HandlerFunc, func(), error) {
+		// route 按路由前缀、upstream 按主上游分别限制，值由路由匹配写入上下文；global 限制整个网关
+		scope := strings.ToLower(util.StrOr(cfg["scope"], "route"))
+		ctxKey := ""
+		switch scope {
+		case "route":
+			ctxKey = "route.prefix"
+		case "upstream":
+			ctxKey = "route.upstream"
+		case "global":
+		default:
+			return nil, nil, fmt.Errorf("unknown scope %q, expected route, upstream or global", scope)
+		}
+		name := strings.ToLower(util.StrOr(cfg["algorithm"], concurrency.Gradient))
+		opts := concurrency.Options{
+			Initial:   parseInt(cfg["initial_limit"], 20),
+			Min:       parseInt(cfg["min_limit"], 1),
+			Max:       parseInt(cfg["max_limit"], 1000),
+			QueueSize: parseInt(cfg["queue_size"], 100), // 不排队时达到上限即一律拒绝，priority 不起作用
+		}
+		if _, _, err := concurrency.Build(name, opts); err != nil {
+			return nil, nil, fmt.Errorf("%w: %q, expected one of %s", err, name, strings.Join(concurrency.Algorithms(), ", "))
+		}
+		if opts.QueueSize < 0 {
+			return nil, nil, fmt.Errorf("invalid queue_size %d", opts.QueueSize)
+		}
+		var err error
+		if opts.Timeout, err = parseDuration(cfg["timeout"], 0); err != nil {
+			return nil, nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		if opts.QueueTimeout, err = parseDuration(cfg["queue_timeout"], time.Second); err != nil {
+			return nil, nil, fmt.Errorf("invalid queue_timeout: %w", err)
+		}
+		retryAfter, err := parseDuration(cfg["retry_after"], time.Second)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid retry_after: %w", err)
+		}
+
+		// 每个路由前缀或上游各自一个限流器，首次请求时创建
+		var mu sync.Mutex
+		limiters := make(map[string]*concurrency.Limiter)
+		limiterFor := func(key string) *concurrency.Limiter {
+			mu.Lock()
+			defer mu.Unlock()
+			l, ok := limiters[key]
+			if !ok {
+				algo, opts, _ := concurrency.Build(name, opts)
+				l = concurrency.NewLimiter(scope, key, algo, opts)
+				limiters[key] = l
+			}
+			return l
+		}
+		release := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, l := range limiters {
+				l.Close()
+			}
+		}
+
+		return func(c *gin.Context) {
+			key := "gateway"
+			if ctxKey != "" {
+				v, _ := c.Get(ctxKey)
+				if key = toString(v); key == "" {
+					// 未命中路由的请求不会转发到上游，无需限制
+					c.Next()
+					return
+				}
+			}
+			done, ok := limiterFor(key).Acquire(c.Request.Context(), priorityClass(c))
+			if !ok {
+				c.Header("Retry-After", seconds(retryAfter))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server overloaded"})
+				return
+			}
+			finish := func() {
+				// 上游过载或超时的响应视为丢弃，促使限流器收紧并发上限
+				switch c.Writer.Status() {
+				case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+					done(true)
+				default:
+					done(false)
+				}
+			}
+			if AfterRoute(c, finish) {
+				return
+			}
+			defer finish()
+			c.Next()
+		}, release, nil
+	})
+}
+
+// parseDuration 解析形如 "500ms" 的时长配置，未配置时返回 def
+func parseDuration(v any, def time.Duration) (time.Duration, error) {
+	s := util.StrOr(v, "")
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return d, nil
+}
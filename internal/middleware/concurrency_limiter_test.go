@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, release, err := New("concurrency_limiter", map[string]any{
+		"scope": "upstream", "algorithm": "aimd", "initial_limit": 1, "max_limit": 1, "retry_after": "1500ms",
+		"queue_size": 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// 模拟路由处理器：路由级中间件先执行，名额在“反向代理”结束后才释放
+	var inner *httptest.ResponseRecorder
+	r := gin.New()
+	r.GET("/*path", func(c *gin.Context) {
+		c.Set("route.upstream", "users")
+		defer BeginRoute(c)()
+		if handler(c); c.IsAborted() {
+			return
+		}
+		if c.Param("path") == "/nested" {
+			inner = httptest.NewRecorder()
+			r.ServeHTTP(inner, httptest.NewRequest(http.MethodGet, "/inner", nil))
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nested", nil))
+	if w.Code != http.StatusNoContent || inner.Code != http.StatusServiceUnavailable ||
+		inner.Header().Get("Retry-After") != "2" {
+		t.Fatalf("outer %d, inner %d %v", w.Code, inner.Code, inner.Header())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/again", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("request after release: %d", w.Code)
+	}
+
+	for _, cfg := range []map[string]any{{"scope": "host"}, {"algorithm": "bbr"}, {"timeout": "soon"}} {
+		if _, _, err := New("concurrency_limiter", cfg); err == nil {
+			t.Errorf("%v should be rejected", cfg)
+		}
+	}
+}
+
+func TestConcurrencyLimiterPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		name string
+		cfg  map[string]any
+	}{
+		{"default queue", map[string]any{}},
+		{"full queue", map[string]any{"queue_size": 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := map[string]any{"scope": "global", "algorithm": "aimd", "initial_limit": 1, "max_limit": 1, "queue_timeout": "10s"}
+			for k, v := range tc.cfg {
+				cfg[k] = v
+			}
+			handler, release, err := New("concurrency_limiter", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+
+			classify, releaseClassify, err := New("priority", map[string]any{
+				"rules":   []any{map[string]any{"class": "critical", "match": "header:X-Critical"}},
+				"default": "low",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer releaseClassify()
+
+			unblock := make(chan struct{})
+			entered := make(chan string, 3) // priority class of each admitted request, in order
+			r := gin.New()
+			r.Use(classify, handler)
+			r.GET("/*path", func(c *gin.Context) {
+				entered <- c.GetString("priority")
+				if c.Param("path") == "/hold" {
+					<-unblock
+				}
+				c.Status(http.StatusNoContent)
+			})
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			type result struct {
+				name string
+				code int
+			}
+			results := make(chan result, 3)
+			send := func(name, path string, critical bool) {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+				if critical {
+					req.Header.Set("X-Critical", "1")
+				}
+				go func() {
+					resp, err := http.DefaultClient.Do(req)
+					if err != nil {
+						results <- result{name, 0}
+						return
+					}
+					resp.Body.Close()
+					results <- result{name, resp.StatusCode}
+				}()
+			}
+
+			// the only slot is taken, a low request arriving at the limit waits instead of being shed at once
+			send("holder", "/hold", false)
+			for len(entered) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			send("low", "/", false)
+			time.Sleep(100 * time.Millisecond)
+			if len(results) != 0 {
+				t.Fatalf("low request finished at the limit: %+v", <-results)
+			}
+			// a critical request is admitted ahead of it; with a full queue the low request is shed
+			send("critical", "/", true)
+			time.Sleep(100 * time.Millisecond)
+			close(unblock)
+
+			codes := make(map[string]int)
+			for range 3 {
+				res := <-results
+				codes[res.name] = res.code
+			}
+			close(entered)
+			var order []string
+			for class := range entered {
+				order = append(order, class)
+			}
+			if codes["holder"] != http.StatusNoContent || codes["critical"] != http.StatusNoContent {
+				t.Fatalf("codes %v", codes)
+			}
+			if _, full := tc.cfg["queue_size"]; full {
+				if codes["low"] != http.StatusServiceUnavailable || len(order) != 2 {
+					t.Fatalf("low request with a full queue: admitted %v, codes %v", order, codes)
+				}
+			} else if codes["low"] != http.StatusNoContent || len(order) != 3 || order[1] != "critical" {
+				t.Fatalf("critical request was not admitted first: admitted %v, codes %v", order, codes)
+			}
+		})
+	}
+}
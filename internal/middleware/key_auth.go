@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"LensGateway.com/internal/consumer"
+	"LensGateway.com/util"
+	"github.com/gin-gonic/gin"
+)
+
+// API key 认证：按 key_lookup 依次从请求头、查询参数或 Cookie 中取 key，在调用方注册表（配置中的 consumers）
+// 中查找持有者，并在上下文中写入 consumer（名称）、consumer.groups 与 consumer.metadata，
+// 供 rate_limiter、quota、priority 的 consumer 与 meta: 键、acl 的调用方规则以及访问日志使用。
+//
+//	key_lookup: ["header:X-Api-Key", "query:api_key"]  # 默认如此
+//	hide_credentials: true                            # 转发前移除 key（默认）
+//	optional: false                                   # 为 true 时放行未携带 key 的请求（不设置调用方）
+func init() {
+	RegisterKeys("key_auth", "key_lookup", "hide_credentials", "optional")
+	Register("key_auth", func(cfg map[string]any) (gin.HandlerFunc, error) {
+		lookups := util.ToStringSlice(cfg["key_lookup"])
+		if len(lookups) == 0 {
+			lookups = []string{"header:X-Api-Key", "query:api_key"}
+		}
+		hide := true
+		if v, ok := cfg["hide_credentials"].(bool); ok {
+			hide = v
+		}
+		optional, _ := cfg["optional"].(bool)
+
+		return func(c *gin.Context) {
+			key, lookup := "", ""
+			for _, l := range lookups {
+				if key = extractToken(c, l); key != "" {
+					lookup = l
+					break
+				}
+			}
+			if key == "" {
+				if optional {
+					c.Next()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing api key"})
+				return
+			}
+			who, ok := consumer.Current().Authenticate(key)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+				return
+			}
+			if hide {
+				removeCredential(c, lookup)
+			}
+			c.Set("consumer", who.Name)
+			c.Set("consumer.groups", who.Groups)
+			c.Set("consumer.metadata", who.Metadata)
+			c.Next()
+		}, nil
+	})
+}
+
+// removeCredential 从请求中移除 lookup 指向的 key，避免转发给上游
+func removeCredential(c *gin.Context, lookup string) {
+	source, name, _ := strings.Cut(lookup, ":")
+	switch strings.ToLower(source) {
+	case "header":
+		c.Request.Header.Del(name)
+	case "query":
+		q := c.Request.URL.Query()
+		q.Del(name)
+		c.Request.URL.RawQuery = q.Encode()
+	case "cookie":
+		cookies := c.Request.Cookies()
+		c.Request.Header.Del("Cookie")
+		for _, ck := range cookies {
+			if ck.Name != name {
+				c.Request.AddCookie(ck)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"LensGateway.com/internal/config"
+	"LensGateway.com/internal/consumer"
+	"github.com/gin-gonic/gin"
+)
+
+func TestKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reg, err := consumer.NewRegistry([]config.ConsumerConfig{
+		{Name: "billing", Keys: []string{consumer.HashKey("billing-key")}, Groups: []string{"internal"},
+			Metadata: map[string]string{"plan": "pro"}},
+		{Name: "acme", Keys: []string{consumer.HashKey("acme-key")}, Groups: []string{"partners"}},
+		{Name: "legacy", Keys: []string{consumer.HashKey("legacy-key")}, Groups: []string{"partners"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.Set(reg)
+	defer consumer.Set(nil)
+
+	newEngine := func(authCfg, aclCfg map[string]any) *gin.Engine {
+		auth, _, err := New("key_auth", authCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acl, _, err := New("acl", aclCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := gin.New()
+		r.Use(auth, acl)
+		r.GET("/", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"consumer": c.GetString("consumer"),
+				"plan":     c.GetStringMapString("consumer.metadata")["plan"],
+				"header":   c.GetHeader("X-Api-Key"),
+				"query":    c.Request.URL.RawQuery,
+			})
+		})
+		return r
+	}
+	do := func(r *gin.Engine, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	r := newEngine(nil, nil)
+	cases := []struct {
+		target, key string
+		code        int
+		body        string
+	}{
+		{"/", "billing-key", 200, `{"consumer":"billing","header":"","plan":"pro","query":""}`},
+		{"/?api_key=acme-key&page=2", "", 200, `{"consumer":"acme","header":"","plan":"","query":"page=2"}`},
+		{"/", "", 401, `{"error":"missing api key"}`},
+		{"/", "wrong-key", 401, `{"error":"invalid api key"}`},
+		{"/", consumer.HashKey("billing-key"), 401, `{"error":"invalid api key"}`},
+	}
+	for _, tc := range cases {
+		w := do(r, tc.target, tc.key)
+		if w.Code != tc.code || w.Body.String() != tc.body {
+			t.Errorf("GET %s key=%q: %d %s; want %d %s", tc.target, tc.key, w.Code, w.Body, tc.code, tc.body)
+		}
+	}
+
+	// optional 放行匿名请求，hide_credentials: false 保留 key
+	r = newEngine(map[string]any{"optional": true, "hide_credentials": false}, nil)
+	if w := do(r, "/", ""); w.Code != 200 || w.Body.String() != `{"consumer":"","header":"","plan":"","query":""}` {
+		t.Errorf("anonymous request with optional: %d %s", w.Code, w.Body)
+	}
+	if w := do(r, "/", "acme-key"); w.Body.String() != `{"consumer":"acme","header":"acme-key","plan":"","query":""}` {
+		t.Errorf("hide_credentials false: %s", w.Body)
+	}
+
+	// acl 按调用方与分组放行或拒绝
+	r = newEngine(map[string]any{"optional": true}, map[string]any{
+		"allow_consumers": []any{"billing"},
+		"allow_groups":    []any{"partners"},
+		"deny_consumers":  []any{"legacy"},
+	})
+	for key, code := range map[string]int{"billing-key": 200, "acme-key": 200, "legacy-key": 403, "": 403} {
+		if w := do(r, "/", key); w.Code != code {
+			t.Errorf("acl for key %q: %d; want %d", key, w.Code, code)
+		}
+	}
+	r = newEngine(nil, map[string]any{"deny_groups": []any{"partners"}})
+	for key, code := range map[string]int{"billing-key": 200, "acme-key": 403} {
+		if w := do(r, "/", key); w.Code != code {
+			t.Errorf("acl deny_groups for key %q: %d; want %d", key, w.Code, code)
+		}
+	}
+}
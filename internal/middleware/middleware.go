@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
@@ -17,34 +18,148 @@ import (
 // 作为中间件可配置的核心
 type MiddlewareCreator func(config map[string]any) (gin.HandlerFunc, error)
 
+// MiddlewareFactory 与 MiddlewareCreator 类似，但额外返回一个释放函数（可为 nil），
+// 配置重载替换掉旧中间件时调用，用于停止中间件持有的后台协程等资源
+type MiddlewareFactory func(config map[string]any) (gin.HandlerFunc, func(), error)
+
+// MiddlewareChecker 只校验配置，不打开文件、建立连接、启动后台协程或登记实例
+type MiddlewareChecker func(config map[string]any) error
+
 // 中间件注册表
-var registry = make(map[string]MiddlewareCreator)
+var (
+	registry  = make(map[string]MiddlewareFactory)
+	checkers  = make(map[string]MiddlewareChecker)
+	knownKeys = make(map[string]map[string]bool)
+)
 
 // 注册一个中间件创建器
 func Register(name string, creator MiddlewareCreator) {
+	RegisterFactory(name, func(cfg map[string]any) (gin.HandlerFunc, func(), error) {
+		handler, err := creator(cfg)
+		return handler, nil, err
+	})
+}
+
+// 注册一个带释放函数的中间件工厂
+func RegisterFactory(name string, factory MiddlewareFactory) {
 	if _, exists := registry[name]; exists {
 		panic(fmt.Sprintf("Middleware %s is already registered", name))
 	}
-	registry[name] = creator
+	registry[name] = factory
+}
+
+// 为中间件注册配置检查函数。持有外部资源（存储、连接、后台协程）的中间件应注册，
+// 校验配置（gateway validate、管理接口的写操作）时用它代替实际创建
+func RegisterChecker(name string, check MiddlewareChecker) {
+	if _, exists := checkers[name]; exists {
+		panic(fmt.Sprintf("Middleware checker %s is already registered", name))
+	}
+	checkers[name] = check
+}
+
+// 登记中间件识别的顶层配置项，UnknownKeys 据此找出拼写错误的键（如 requets_per_second）。
+// 未登记的中间件不做这项检查
+func RegisterKeys(name string, keys ...string) {
+	if _, exists := knownKeys[name]; exists {
+		panic(fmt.Sprintf("Middleware keys of %s are already registered", name))
+	}
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k] = true
+	}
+	knownKeys[name] = known
 }
 
-// 获取一个已注册的创建器
-func GetCreator(name string) (MiddlewareCreator, error) {
-	creator, exists := registry[name]
+// UnknownKeys 返回 cfg 中中间件不识别的顶层配置项，按名称排序。
+// 只检查顶层：rules、plans 等嵌套配置中的键不做检查
+func UnknownKeys(name string, cfg map[string]any) []string {
+	known, ok := knownKeys[name]
+	if !ok {
+		return nil
+	}
+	var unknown []string
+	for k := range cfg {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Registered 判断中间件是否已注册
+func Registered(name string) bool {
+	_, ok := registry[name]
+	return ok
+}
+
+// 根据名称和配置实例化一个中间件，返回的释放函数不为 nil。
+// 创建器内部的 panic（例如配置类型不符）会被转换为错误，避免错误配置拖垮网关。
+// 错误信息可能引用配置值，其中来自 ${...} 引用的值会被脱敏。
+func New(name string, cfg map[string]any) (handler gin.HandlerFunc, release func(), err error) {
+	factory, exists := registry[name]
 	if !exists {
-		return nil, fmt.Errorf("middleware %s not registered", name)
+		return nil, nil, fmt.Errorf("middleware %s not registered", name)
 	}
-	return creator, nil
+	if cfg == nil {
+		cfg = make(map[string]any)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			handler, release, err = nil, nil, fmt.Errorf("middleware %s: invalid config: %s", name, config.Redact(fmt.Sprint(r)))
+		}
+	}()
+	handler, release, err = factory(cfg)
+	if err != nil {
+		return nil, nil, errors.New(config.Redact(err.Error()))
+	}
+	if release == nil {
+		release = func() {}
+	}
+	return handler, release, nil
 }
 
-// 根据配置，动态创建和排序中间件链
-func SetupMiddlewares(router *gin.Engine, middlewareConfigs map[string]config.MiddlewareConfig) error {
+// Check 校验中间件配置而不创建持有资源的实例：注册了检查函数时只调用它，
+// 否则创建一次中间件后立即释放。panic 与错误信息的处理同 New
+func Check(name string, cfg map[string]any) (err error) {
+	check, ok := checkers[name]
+	if !ok {
+		_, release, err := New(name, cfg)
+		if err != nil {
+			return err
+		}
+		release()
+		return nil
+	}
+	if cfg == nil {
+		cfg = make(map[string]any)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("middleware %s: invalid config: %s", name, config.Redact(fmt.Sprint(r)))
+		}
+	}()
+	if err := check(cfg); err != nil {
+		return errors.New(config.Redact(err.Error()))
+	}
+	return nil
+}
+
+// Chain 是按 order 排好序的全局中间件链，连同各中间件的释放函数
+type Chain struct {
+	handlers []gin.HandlerFunc
+	releases []func()
+}
+
+// 根据配置，动态创建和排序中间件链。任一中间件创建失败时，已创建的中间件会被释放
+func BuildChain(middlewareConfigs map[string]config.MiddlewareConfig) (*Chain, error) {
 	type middlewareItem struct {
 		order   int
 		handler gin.HandlerFunc
 	}
 
 	var middlewares []middlewareItem
+	chain := &Chain{}
 
 	// 1. 遍历所有配置的中间件
 	for name, mwConf := range middlewareConfigs {
@@ -53,17 +168,18 @@ func SetupMiddlewares(router *gin.Engine, middlewareConfigs map[string]config.Mi
 		}
 
 		// 2. 从注册表中找到对应的创建函数（未注册的中间件跳过并告警）
-		creator, exists := registry[name]
-		if !exists {
+		if _, exists := registry[name]; !exists {
 			log.Printf("[gateway] middleware %q not registered, skipping", name)
 			continue
 		}
 
 		// 3. 调用创建函数，传入该中间件的具体配置，实例化一个中间件Handler
-		handler, err := creator(mwConf.Config)
+		handler, release, err := New(name, mwConf.Config)
 		if err != nil {
-			return fmt.Errorf("failed to create middleware %s: %v", name, err)
+			chain.Release()
+			return nil, fmt.Errorf("failed to create middleware %s: %v", name, err)
 		}
+		chain.releases = append(chain.releases, release)
 
 		// 4. 收集到列表中，稍后排序
 		middlewares = append(middlewares, middlewareItem{
@@ -76,12 +192,61 @@ func SetupMiddlewares(router *gin.Engine, middlewareConfigs map[string]config.Mi
 	sort.Slice(middlewares, func(i, j int) bool {
 		return middlewares[i].order < middlewares[j].order
 	})
-
-	// 6. 将排序好的中间件添加到Gin的全局使用列表中
 	for _, mw := range middlewares {
-		router.Use(mw.handler)
+		chain.handlers = append(chain.handlers, mw.handler)
+	}
+	return chain, nil
+}
+
+// Handlers 返回排序后的中间件
+func (ch *Chain) Handlers() []gin.HandlerFunc {
+	return ch.handlers
+}
+
+// Release 释放链上所有中间件持有的资源
+func (ch *Chain) Release() {
+	for _, release := range ch.releases {
+		release()
 	}
+	ch.releases = nil
+}
 
+// routeAfterKey 上下文中路由级收尾函数列表的键
+const routeAfterKey = "route.after"
+
+// BeginRoute 由路由处理器在执行路由级中间件前调用，返回的函数需在请求处理（包括反向代理）结束后调用，
+// 按注册的相反顺序执行路由级中间件通过 AfterRoute 注册的函数
+func BeginRoute(c *gin.Context) (end func()) {
+	var after []func()
+	c.Set(routeAfterKey, &after)
+	return func() {
+		for i := len(after) - 1; i >= 0; i-- {
+			after[i]()
+		}
+		after = nil
+	}
+}
+
+// AfterRoute 注册在请求处理结束后执行的函数。路由级中间件依次调用而非通过 gin 的处理链，
+// 其中 c.Next() 不包含反向代理，需要在请求结束后执行的逻辑（例如释放并发名额、统计延迟）通过它注册。
+// 不在路由级中间件中调用时返回 false，调用方应在 c.Next() 返回后自行执行
+func AfterRoute(c *gin.Context, fn func()) bool {
+	v, ok := c.Get(routeAfterKey)
+	if !ok {
+		return false
+	}
+	after := v.(*[]func())
+	*after = append(*after, fn)
+	return true
+}
+
+// 根据配置创建中间件链并添加到Gin的全局使用列表中
+func SetupMiddlewares(router *gin.Engine, middlewareConfigs map[string]config.MiddlewareConfig) error {
+	chain, err := BuildChain(middlewareConfigs)
+	if err != nil {
+		return err
+	}
+	router.Use(chain.Handlers()...)
 	return nil
 }
 
@@ -89,6 +254,7 @@ func SetupMiddlewares(router *gin.Engine, middlewareConfigs map[string]config.Mi
 
 func init() {
 	// request_logger
+	RegisterKeys("request_logger", "level")
 	Register("request_logger", func(cfg map[string]interface{}) (gin.HandlerFunc, error) {
 		level := util.StrOr(cfg["level"], "info")
 		return func(c *gin.Context) {
@@ -104,6 +270,7 @@ func init() {
 	})
 
 	// cors
+	RegisterKeys("cors", "allow_origin", "allow_methods", "allow_headers", "expose_headers", "allow_credentials", "max_age")
 	Register("cors", func(cfg map[string]any) (gin.HandlerFunc, error) {
 		allowOrigin := util.StrOr(cfg["allow_origin"], "*")
 		allowMethods := util.StrOr(cfg["allow_methods"], "GET,POST,PUT,DELETE,OPTIONS")
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"LensGateway.com/internal/concurrency"
+	"LensGateway.com/util"
+	"github.com/gin-gonic/gin"
+)
+
+// 请求优先级：priority 中间件按规则为请求分类，写入上下文的 priority（类别名称）与 priority.level
+// （类别序号，0 最重要）。concurrency_limiter 过载时先让重要的请求出队，队列满时挤出最不重要的请求。
+
+// defaultPriorityClasses 未配置 classes 时的类别，按重要程度从高到低
+var defaultPriorityClasses = []string{"critical", "high", "normal", "low"}
+
+// priorityRule 一条分类规则：match 取到的值在 values 中（values 为空时取到任意值即可）时命中；
+// absent 为 true 时改为取不到值时命中，例如未携带 Authorization 的匿名请求
+type priorityRule struct {
+	term   keyTerm
+	values []string
+	absent bool
+	level  int
+}
+
+func (r *priorityRule) matches(c *gin.Context) bool {
+	v := r.term(c)
+	if r.absent {
+		return v == ""
+	}
+	return v != "" && (len(r.values) == 0 || slices.Contains(r.values, v))
+}
+
+func init() {
+	RegisterKeys("priority", "classes", "default", "rules")
+	Register("priority", func(cfg map[string]any) (gin.HandlerFunc, error) {
+		classes := util.ToStringSlice(cfg["classes"])
+		if len(classes) == 0 {
+			classes = defaultPriorityClasses
+		}
+		levels := make(map[string]int, len(classes))
+		for i, class := range classes {
+			if _, ok := levels[class]; ok || class == "" {
+				return nil, fmt.Errorf("invalid or duplicate priority class %q", class)
+			}
+			levels[class] = i
+		}
+		levelOf := func(class string) (int, error) {
+			level, ok := levels[class]
+			if !ok {
+				return 0, fmt.Errorf("unknown priority class %q, expected one of %s", class, strings.Join(classes, ", "))
+			}
+			return level, nil
+		}
+		def, err := levelOf(util.StrOr(cfg["default"], classes[len(classes)/2]))
+		if err != nil {
+			return nil, err
+		}
+		rules, err := parsePriorityRules(cfg["rules"], levelOf)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(c *gin.Context) {
+			level := def
+			for i := range rules {
+				if rules[i].matches(c) {
+					level = rules[i].level
+					break
+				}
+			}
+			c.Set("priority", classes[level])
+			c.Set("priority.level", level)
+			c.Next()
+		}, nil
+	})
+}
+
+// parsePriorityRules 解析 rules 列表，按顺序匹配，第一条命中的规则决定类别
+func parsePriorityRules(raw any, levelOf func(string) (int, error)) ([]priorityRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("rules must be a list")
+	}
+	rules := make([]priorityRule, 0, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("rules[%d] must be a mapping", i)
+		}
+		level, err := levelOf(util.StrOr(m["class"], ""))
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		term, err := parseKeyTerm(strings.TrimSpace(util.StrOr(m["match"], "")))
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		absent, _ := m["absent"].(bool)
+		rules = append(rules, priorityRule{term: term, values: util.ToStringSlice(m["values"]), absent: absent, level: level})
+	}
+	return rules, nil
+}
+
+// priorityClass 返回 priority 中间件为请求分配的类别。未经 priority 分类的请求（未启用该中间件，
+// 或中间件排在 concurrency_limiter 之后）同属 default，排在所有已分类请求之后，避免与 critical 同级
+func priorityClass(c *gin.Context) concurrency.Class {
+	name := c.GetString("priority")
+	if name == "" {
+		return concurrency.Class{Name: "default", Level: math.MaxInt}
+	}
+	return concurrency.Class{Name: name, Level: c.GetInt("priority.level")}
+}
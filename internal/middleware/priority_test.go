@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, release, err := New("priority", map[string]any{
+		"rules": []any{
+			map[string]any{"class": "critical", "match": "route", "values": []any{"/api/checkout/"}},
+			map[string]any{"class": "low", "match": "header:X-Job-Type", "values": []any{"batch", "report"}},
+			map[string]any{"class": "high", "match": "header:X-Api-Key"},
+			map[string]any{"class": "low", "match": "header:Authorization", "absent": true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	classify := func(prefix string, headers map[string]string) (string, int) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		if prefix != "" {
+			c.Set("route.prefix", prefix)
+		}
+		handler(c)
+		class := priorityClass(c)
+		return class.Name, class.Level
+	}
+	auth := map[string]string{"Authorization": "Bearer t"}
+	cases := []struct {
+		prefix  string
+		headers map[string]string
+		class   string
+		level   int
+	}{
+		{"/api/checkout/", nil, "critical", 0},
+		{"/api/orders/", map[string]string{"Authorization": "Bearer t", "X-Job-Type": "batch"}, "low", 3},
+		{"/api/orders/", map[string]string{"X-Api-Key": "k1"}, "high", 1},
+		{"/api/orders/", nil, "low", 3},
+		{"/api/orders/", auth, "normal", 2},
+	}
+	for _, tc := range cases {
+		if class, level := classify(tc.prefix, tc.headers); class != tc.class || level != tc.level {
+			t.Errorf("%s %v = %s/%d, want %s/%d", tc.prefix, tc.headers, class, level, tc.class, tc.level)
+		}
+	}
+
+	for _, cfg := range []map[string]any{
+		{"classes": []any{"a", "a"}},
+		{"default": "urgent"},
+		{"rules": []any{map[string]any{"class": "vip", "match": "ip"}}},
+		{"rules": []any{map[string]any{"class": "low", "match": "tenant"}}},
+	} {
+		if _, _, err := New("priority", cfg); err == nil {
+			t.Errorf("%v should be rejected", cfg)
+		}
+	}
+}
+
+func TestPriorityClassUnclassified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, release, err := New("priority", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	unclassified := priorityClass(c)
+	if unclassified.Name != "default" {
+		t.Fatalf("unclassified request got class %q", unclassified.Name)
+	}
+
+	// 未经分类的请求不能与 critical 同级，应排在最不重要的类别之后
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Set("priority", "low")
+	c.Set("priority.level", len(defaultPriorityClasses)-1)
+	if low := priorityClass(c); unclassified.Level <= low.Level {
+		t.Fatalf("unclassified level %d ranks before %s level %d", unclassified.Level, low.Name, low.Level)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	handler(c)
+	if class := priorityClass(c); class.Name != "normal" || class.Level >= unclassified.Level {
+		t.Fatalf("request without a matching rule = %+v, want the default class", class)
+	}
+}
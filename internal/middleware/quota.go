@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"LensGateway.com/internal/quota"
+	"LensGateway.com/util"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// quotaConfig 解析后的 quota 配置
+type quotaConfig struct {
+	name        string
+	consumer    func(c *gin.Context) (string, bool)
+	onMissing   string
+	plan        keyTerm
+	plans       map[string][]quota.Limit
+	defaultPlan string
+	loc         *time.Location
+	failOpen    bool
+	headers     bool
+	store       quotaStoreConfig
+}
+
+func parseQuotaConfig(cfg map[string]any) (quotaConfig, error) {
+	qc := quotaConfig{name: util.StrOr(cfg["name"], "default")}
+	var err error
+	if qc.consumer, err = parseKeyExpr(util.StrOr(cfg["consumer"], "consumer")); err != nil {
+		return qc, fmt.Errorf("consumer: %w", err)
+	}
+	qc.onMissing = strings.ToLower(util.StrOr(cfg["on_missing"], "reject"))
+	if qc.onMissing != "reject" && qc.onMissing != "skip" {
+		return qc, fmt.Errorf("unknown on_missing %q, expected reject or skip", qc.onMissing)
+	}
+	if expr := util.StrOr(cfg["plan"], ""); expr != "" {
+		if qc.plan, err = parseKeyTerm(expr); err != nil {
+			return qc, fmt.Errorf("plan: %w", err)
+		}
+	}
+	if qc.plans, qc.defaultPlan, err = parseQuotaPlans(cfg); err != nil {
+		return qc, err
+	}
+	if qc.loc, err = time.LoadLocation(util.StrOr(cfg["timezone"], "UTC")); err != nil {
+		return qc, fmt.Errorf("invalid timezone: %w", err)
+	}
+	switch mode := strings.ToLower(util.StrOr(cfg["failure_mode"], "open")); mode {
+	case "open":
+		qc.failOpen = true
+	case "closed":
+	default:
+		return qc, fmt.Errorf("unknown failure_mode %q, expected open or closed", mode)
+	}
+	qc.headers, _ = cfg["headers"].(bool)
+	if cfg["headers"] == nil {
+		qc.headers = true
+	}
+	if qc.store, err = parseQuotaStore(cfg); err != nil {
+		return qc, err
+	}
+	return qc, nil
+}
+
+func init() {
+	RegisterKeys("quota", "name", "consumer", "on_missing", "plan", "plans", "limits", "default_plan", "timezone",
+		"failure_mode", "headers", "store", "path", "cleanup_interval", "redis_addr", "redis_password", "redis_db",
+		"redis_timeout", "redis_prefix", "etcd_endpoints", "etcd_timeout", "etcd_username", "etcd_password", "etcd_prefix")
+	RegisterChecker("quota", func(cfg map[string]any) error {
+		_, err := parseQuotaConfig(cfg)
+		return err
+	})
+	RegisterFactory("quota", func(cfg map[string]any) (gin.HandlerFunc, func(), error) {
+		qc, err := parseQuotaConfig(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := qc.store.open()
+		if err != nil {
+			return nil, nil, err
+		}
+		q, err := quota.New(qc.name, store, qc.loc, qc.plans, qc.defaultPlan)
+		if err != nil {
+			_ = store.Close()
+			return nil, nil, err
+		}
+		quota.Register(q)
+
+		release := func() {
+			quota.Unregister(q)
+			_ = store.Close()
+		}
+
+		return func(c *gin.Context) {
+			id, ok := qc.consumer(c)
+			if !ok {
+				if qc.onMissing == "skip" {
+					c.Next()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing quota consumer"})
+				return
+			}
+			planName := ""
+			if qc.plan != nil {
+				planName = qc.plan(c)
+			}
+			usage, allowed, err := q.Consume(c.Request.Context(), id, planName, 1)
+			if err != nil {
+				if qc.failOpen {
+					log.Printf("[gateway] quota %q unavailable, allowing request: %v", qc.name, err)
+					c.Next()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "quota unavailable"})
+				return
+			}
+			now := time.Now()
+			if qc.headers {
+				setQuotaHeaders(c, usage, now)
+			}
+			if !allowed {
+				// 需等到所有超限的周期都进入下一个窗口
+				var exceeded quota.Usage
+				for _, u := range usage {
+					if u.Used+1 > u.Limit && u.End.After(exceeded.End) {
+						exceeded = u
+					}
+				}
+				c.Header("Retry-After", seconds(exceeded.End.Sub(now)))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "quota exceeded", "period": exceeded.Period})
+				return
+			}
+			c.Next()
+		}, release, nil
+	})
+}
+
+// setQuotaHeaders 写入剩余请求数最少的周期：X-Quota-Limit、X-Quota-Remaining，
+// 以及该窗口结束前的秒数 X-Quota-Reset
+func setQuotaHeaders(c *gin.Context, usage []quota.Usage, now time.Time) {
+	if len(usage) == 0 {
+		return
+	}
+	tightest := usage[0]
+	for _, u := range usage[1:] {
+		if u.Remaining < tightest.Remaining {
+			tightest = u
+		}
+	}
+	c.Header("X-Quota-Limit", strconv.FormatInt(tightest.Limit, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+	c.Header("X-Quota-Reset", seconds(tightest.End.Sub(now)))
+}
+
+// parseQuotaPlans 解析 plans（套餐名称到各周期限额）；只配置 limits 时即为唯一的 default 套餐。
+// 在打开计数存储之前校验 default_plan，避免错误配置创建文件或连接
+func parseQuotaPlans(cfg map[string]any) (map[string][]quota.Limit, string, error) {
+	raw, _ := cfg["plans"].(map[string]any)
+	if raw == nil {
+		limits, ok := cfg["limits"].(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("either plans or limits is required")
+		}
+		raw = map[string]any{"default": limits}
+	}
+	plans := make(map[string][]quota.Limit, len(raw))
+	for name, v := range raw {
+		m, ok := v.(map[string]any)
+		if !ok || len(m) == 0 {
+			return nil, "", fmt.Errorf("plan %q must map periods to request counts", name)
+		}
+		for key := range m {
+			if _, err := quota.ParsePeriod(key); err != nil {
+				return nil, "", fmt.Errorf("plan %q: %w", name, err)
+			}
+		}
+		var limits []quota.Limit
+		for _, p := range quota.Periods {
+			v, ok := m[string(p)]
+			if !ok {
+				continue
+			}
+			n := parseInt(v, 0)
+			if n < 1 {
+				return nil, "", fmt.Errorf("plan %q: invalid %s quota %v", name, p, v)
+			}
+			limits = append(limits, quota.Limit{Period: p, Requests: int64(n)})
+		}
+		plans[name] = limits
+	}
+	defaultPlan := util.StrOr(cfg["default_plan"], "default")
+	if len(plans) == 1 && cfg["default_plan"] == nil {
+		for name := range plans {
+			defaultPlan = name
+		}
+	}
+	if _, ok := plans[defaultPlan]; !ok {
+		return nil, "", fmt.Errorf("default plan %q is not defined", defaultPlan)
+	}
+	return plans, defaultPlan, nil
+}
+
+// quotaStoreConfig 解析后的计数存储配置，open 时才打开文件或建立连接
+type quotaStoreConfig struct {
+	kind            string // file、redis 或 etcd
+	path            string
+	cleanupInterval time.Duration
+	redis           *redis.Options
+	etcd            clientv3.Config
+	prefix          string
+}
+
+// parseQuotaStore 解析 store 配置：file（默认，本地 bbolt 文件）、redis 或 etcd
+func parseQuotaStore(cfg map[string]any) (quotaStoreConfig, error) {
+	sc := quotaStoreConfig{kind: strings.ToLower(util.StrOr(cfg["store"], "file"))}
+	var err error
+	switch sc.kind {
+	case "file", "bolt":
+		sc.kind = "file"
+		sc.path = util.StrOr(cfg["path"], "data/quota.db")
+		if sc.cleanupInterval, err = parseDuration(cfg["cleanup_interval"], time.Hour); err != nil {
+			return sc, fmt.Errorf("invalid cleanup_interval: %w", err)
+		}
+	case "redis":
+		if sc.redis, err = parseRedisOptions(cfg); err != nil {
+			return sc, err
+		}
+		sc.prefix = util.StrOr(cfg["redis_prefix"], "lens:quota:")
+	case "etcd":
+		endpoints := util.ToStringSlice(cfg["etcd_endpoints"])
+		if len(endpoints) == 0 {
+			endpoints = []string{"localhost:2379"}
+		}
+		timeout, err := parseDuration(cfg["etcd_timeout"], 5*time.Second)
+		if err != nil {
+			return sc, fmt.Errorf("invalid etcd_timeout: %w", err)
+		}
+		sc.etcd = clientv3.Config{
+			Endpoints:   endpoints,
+			Username:    util.StrOr(cfg["etcd_username"], ""),
+			Password:    util.StrOr(cfg["etcd_password"], ""),
+			DialTimeout: timeout,
+		}
+		sc.prefix = util.StrOr(cfg["etcd_prefix"], "/lens/quota/")
+	default:
+		return sc, fmt.Errorf("unknown quota store %q, expected file, redis or etcd", sc.kind)
+	}
+	return sc, nil
+}
+
+func (sc quotaStoreConfig) open() (quota.Store, error) {
+	switch sc.kind {
+	case "redis":
+		return quota.NewRedis(redis.NewClient(sc.redis), sc.prefix), nil
+	case "etcd":
+		client, err := clientv3.New(sc.etcd)
+		if err != nil {
+			return nil, err
+		}
+		return quota.NewEtcd(client, sc.prefix), nil
+	default:
+		if err := ensureDir(sc.path); err != nil {
+			return nil, err
+		}
+		return quota.OpenBolt(sc.path, sc.cleanupInterval)
+	}
+}
+
+func ensureDir(path string) error {
+	dir := filepath.Dir(path)
+	if dir == "." {
+		return nil
+	}
+	return os.MkdirAll(dir, 0o755)
+}
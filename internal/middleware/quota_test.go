@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"LensGateway.com/internal/quota"
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, release, err := New("quota", map[string]any{
+		"name":         "test_plans",
+		"consumer":     "header:X-Api-Key",
+		"plan":         "header:X-Plan",
+		"default_plan": "free",
+		"plans": map[string]any{
+			"free": map[string]any{"daily": 2, "monthly": 10},
+			"pro":  map[string]any{"monthly": 100},
+		},
+		"path": filepath.Join(t.TempDir(), "quota.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	r := gin.New()
+	r.Use(handler)
+	r.GET("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	do := func(key, plan string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		if plan != "" {
+			req.Header.Set("X-Plan", plan)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("k1", "")
+	if w.Code != http.StatusNoContent || w.Header().Get("X-Quota-Limit") != "2" || w.Header().Get("X-Quota-Remaining") != "1" {
+		t.Fatalf("first request: %d %v", w.Code, w.Header())
+	}
+	do("k1", "")
+	w = do("k1", "")
+	reset, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+	if w.Code != http.StatusTooManyRequests || reset < 1 || reset > 86400 || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("over quota: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	if w = do("k1", "pro"); w.Code != http.StatusNoContent || w.Header().Get("X-Quota-Limit") != "100" {
+		t.Fatalf("pro plan: %d %v", w.Code, w.Header())
+	}
+	if w = do("", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request without key: %d", w.Code)
+	}
+
+	for _, cfg := range []map[string]any{
+		{},
+		{"limits": map[string]any{"yearly": 10}},
+		{"limits": map[string]any{"daily": 0}},
+		{"plans": map[string]any{"free": map[string]any{"daily": 1}, "pro": map[string]any{"daily": 2}}},
+		{"limits": map[string]any{"daily": 1}, "store": "s3"},
+	} {
+		cfg["path"] = filepath.Join(t.TempDir(), "quota.db")
+		if _, _, err := New("quota", cfg); err == nil {
+			t.Errorf("%v should be rejected", cfg)
+		}
+	}
+}
+
+func TestQuotaCheckOpensNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "quota.db")
+	cfg := map[string]any{"name": "checked", "limits": map[string]any{"daily": 10}, "path": path}
+	if err := Check("quota", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Fatalf("checking the config created %s", filepath.Dir(path))
+	}
+	if _, ok := quota.Lookup("checked"); ok {
+		t.Fatal("checking the config registered a quota")
+	}
+	for _, cfg := range []map[string]any{
+		{"limits": map[string]any{"daily": 1}, "store": "s3"},
+		{"limits": map[string]any{"daily": 1}, "store": "redis", "redis_timeout": "soon"},
+	} {
+		if err := Check("quota", cfg); err == nil {
+			t.Errorf("%v should be rejected", cfg)
+		}
+	}
+	if err := Check("rate_limiter", map[string]any{"store": "redis", "redis_addr": "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check("rate_limiter", map[string]any{"store": "redis", "failure_mode": "maybe"}); err == nil {
+		t.Error("an unknown failure_mode should be rejected")
+	}
+}
+
+func TestQuotaCountsConsumerByDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, release, err := New("quota", map[string]any{
+		"name":   "test_default_consumer",
+		"limits": map[string]any{"daily": 10},
+		"path":   filepath.Join(t.TempDir(), "quota.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Api-Key") == "raw-key" {
+			c.Set("consumer", "acme")
+		}
+	}, handler)
+	r.GET("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Api-Key", "raw-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("request: %d %s", w.Code, w.Body)
+	}
+	q, _ := quota.Lookup("test_default_consumer")
+	byName, _ := q.Inspect(req.Context(), "acme")
+	byKey, _ := q.Inspect(req.Context(), "raw-key")
+	if byName[0].Used != 1 || byKey[0].Used != 0 {
+		t.Fatalf("counted by name %d, by raw key %d", byName[0].Used, byKey[0].Used)
+	}
+}
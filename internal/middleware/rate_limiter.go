@@ -1,128 +1,360 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
+	"LensGateway.com/internal/ratelimit"
 	"LensGateway.com/util"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
-// A simple, fast, lock-light token bucket.
-type bucket struct {
-	rate   float64      // tokens per second
-	burst  int64        // max tokens
-	tokens int64        // current tokens (atomic)
-	last   atomic.Int64 // unix nano of last refill
-}
-
-func newBucket(rate float64, burst int64) *bucket {
-	b := &bucket{rate: rate, burst: burst, tokens: burst}
-	b.last.Store(time.Now().UnixNano())
-	return b
-}
-
-func (b *bucket) allow(n int64) bool {
-	now := time.Now().UnixNano()
-	last := b.last.Load()
-	dt := float64(now-last) / 1e9
-	if dt > 0 {
-		add := int64(dt * b.rate)
-		if add > 0 {
-			// try to advance last time
-			if b.last.CompareAndSwap(last, now) {
-				// refill
-				for {
-					cur := atomic.LoadInt64(&b.tokens)
-					nt := min(cur+add, b.burst)
-					if atomic.CompareAndSwapInt64(&b.tokens, cur, nt) {
-						break
+// rateLimitRule 一条限流规则：按键表达式分组，各组独立计数
+type rateLimitRule struct {
+	name      string
+	key       func(c *gin.Context) (string, bool)
+	onMissing string // 取不到键时的处理：ip（按客户端 IP 计数）、skip（不受该规则限制）、reject（返回 400）
+	limit     ratelimit.Limit
+}
+
+// rateLimitConfig 解析后的 rate_limiter 配置
+type rateLimitConfig struct {
+	rules   []rateLimitRule
+	algo    ratelimit.Algorithm
+	headers string
+	store   rateLimitStoreConfig
+}
+
+func parseRateLimitConfig(cfg map[string]any) (rateLimitConfig, error) {
+	rules, err := parseRateLimitRules(cfg)
+	if err != nil {
+		return rateLimitConfig{}, err
+	}
+	algo, err := ratelimit.ParseAlgorithm(util.StrOr(cfg["algorithm"], ""))
+	if err != nil {
+		return rateLimitConfig{}, err
+	}
+	headers := strings.ToLower(util.StrOr(cfg["headers"], "ietf"))
+	if headers != "ietf" && headers != "legacy" && headers != "none" {
+		return rateLimitConfig{}, fmt.Errorf("unknown headers %q, expected ietf, legacy or none", headers)
+	}
+	store, err := parseRateLimitStore(cfg)
+	if err != nil {
+		return rateLimitConfig{}, err
+	}
+	return rateLimitConfig{rules: rules, algo: algo, headers: headers, store: store}, nil
+}
+
+func init() {
+	RegisterKeys("rate_limiter", "rules", "requests_per_second", "burst", "global", "per_ip", "strategy", "algorithm",
+		"headers", "store", "max_keys", "cleanup_interval", "failure_mode", "redis_addr", "redis_password", "redis_db",
+		"redis_timeout", "redis_prefix")
+	RegisterChecker("rate_limiter", func(cfg map[string]any) error {
+		_, err := parseRateLimitConfig(cfg)
+		return err
+	})
+	RegisterFactory("rate_limiter", func(cfg map[string]any) (gin.HandlerFunc, func(), error) {
+		rlc, err := parseRateLimitConfig(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules := rlc.rules
+		limiter, release := rlc.store.open(rlc.algo)
+
+		return func(c *gin.Context) {
+			reqs := make([]ratelimit.Request, 0, len(rules))
+			applied := make([]*rateLimitRule, 0, len(rules))
+			for i := range rules {
+				r := &rules[i]
+				key, ok := r.key(c)
+				if !ok {
+					switch r.onMissing {
+					case "skip":
+						continue
+					case "reject":
+						c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing rate limit key", "rule": r.name})
+						return
+					}
+					// "=" 不会出现在转义后的键中，与取到值的键互不冲突
+					key = "=" + url.QueryEscape(util.ClientIP(c.Request))
+				}
+				reqs = append(reqs, ratelimit.Request{Key: r.name + ":" + key, Limit: r.limit})
+				applied = append(applied, r)
+			}
+			if len(reqs) == 0 {
+				c.Next()
+				return
+			}
+			results, err := limiter.Allow(c.Request.Context(), 1, reqs...)
+			if err != nil {
+				var wait time.Duration
+				for _, res := range results {
+					wait = max(wait, res.RetryAfter)
+				}
+				c.Header("Retry-After", seconds(wait))
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "rate limiter unavailable"})
+				return
+			}
+			setRateLimitHeaders(c, rlc.headers, applied, results)
+			if !ratelimit.Allowed(results) {
+				// 需等到所有拒绝的规则都允许
+				var wait time.Duration
+				var rejected string
+				for i, res := range results {
+					if !res.Allowed && res.RetryAfter >= wait {
+						wait, rejected = res.RetryAfter, applied[i].name
 					}
 				}
+				c.Header("Retry-After", seconds(wait))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded", "rule": rejected})
+				return
 			}
+			c.Next()
+		}, release, nil
+	})
+}
+
+// setRateLimitHeaders 在响应中写入限流状态。
+//
+//	ietf    RateLimit-Policy 与 RateLimit（draft-ietf-httpapi-ratelimit-headers），列出全部规则，
+//	        例如 RateLimit-Policy: "per_ip";q=20;w=1 与 RateLimit: "per_ip";r=19;t=1
+//	legacy  X-RateLimit-Limit/Remaining/Reset，取剩余请求数最少的规则，Reset 为秒数
+//	none    不写入
+//
+// 窗口 w 与恢复时间 t 均以秒为单位，向上取整
+func setRateLimitHeaders(c *gin.Context, mode string, rules []*rateLimitRule, results []ratelimit.Result) {
+	switch mode {
+	case "ietf":
+		policies := make([]string, len(rules))
+		states := make([]string, len(rules))
+		for i, r := range rules {
+			name := sfString(r.name)
+			window := time.Duration(float64(r.limit.Burst) / r.limit.Rate * float64(time.Second))
+			policies[i] = fmt.Sprintf("%s;q=%d;w=%s", name, r.limit.Burst, seconds(window))
+			states[i] = fmt.Sprintf("%s;r=%d;t=%s", name, max(0, results[i].Remaining), seconds(results[i].Reset))
 		}
-	}
-	for {
-		cur := atomic.LoadInt64(&b.tokens)
-		if cur < n {
-			return false
+		c.Header("RateLimit-Policy", strings.Join(policies, ", "))
+		c.Header("RateLimit", strings.Join(states, ", "))
+	case "legacy":
+		tightest := 0
+		for i, res := range results {
+			cur := results[tightest]
+			if res.Remaining < cur.Remaining || res.Remaining == cur.Remaining && res.Reset > cur.Reset {
+				tightest = i
+			}
 		}
-		if atomic.CompareAndSwapInt64(&b.tokens, cur, cur-n) {
-			return true
+		res := results[tightest]
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(max(0, res.Remaining), 10))
+		c.Header("X-RateLimit-Reset", seconds(res.Reset))
+	}
+}
+
+// seconds 将时长格式化为向上取整的秒数，不为零的时长至少为 1 秒
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// sfString 将规则名编码为 HTTP 结构化字段中的字符串（RFC 8941），去掉不可打印的字符
+func sfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
 		}
 	}
+	b.WriteByte('"')
+	return b.String()
 }
 
-// rate limiter registry state
-type rlState struct {
-	mu sync.Mutex
-	m  sync.Map // key -> *bucket
-	// default params
-	rate  float64
-	burst int64
+// parseRateLimitRules 解析 rules 列表。未配置 rules 时按旧的 strategy 配置生成：
+// ip 使用 per_ip，route 使用 global，combined 同时使用两者（per_ip 按路由与 IP 分组）；
+// 未配置 per_ip/global 时使用顶层的 requests_per_second 与 burst
+func parseRateLimitRules(cfg map[string]any) ([]rateLimitRule, error) {
+	if raw, ok := cfg["rules"]; ok {
+		list, ok := raw.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("rules must be a non-empty list")
+		}
+		rules := make([]rateLimitRule, 0, len(list))
+		seen := make(map[string]bool, len(list))
+		for i, item := range list {
+			m, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("rules[%d] must be a mapping", i)
+			}
+			expr := util.StrOr(m["key"], "ip")
+			rule, err := newRateLimitRule(util.StrOr(m["name"], expr), expr, m, ratelimit.Limit{})
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d]: %w", i, err)
+			}
+			if seen[rule.name] {
+				return nil, fmt.Errorf("rules[%d]: duplicate rule name %q", i, rule.name)
+			}
+			seen[rule.name] = true
+			rules = append(rules, rule)
+		}
+		return rules, nil
+	}
+
+	flat := ratelimit.Limit{Rate: parseFloat(cfg["requests_per_second"], 100.0), Burst: int64(parseInt(cfg["burst"], 50))}
+	global, _ := cfg["global"].(map[string]any)
+	perIP, _ := cfg["per_ip"].(map[string]any)
+	type spec struct {
+		name, key string
+		section   map[string]any
+	}
+	var specs []spec
+	switch strategy := strings.ToLower(util.StrOr(cfg["strategy"], "ip")); strategy {
+	case "ip":
+		specs = []spec{{"ip", "ip", perIP}}
+	case "route":
+		specs = []spec{{"route", "route", global}}
+	case "combined":
+		specs = []spec{{"route", "route", global}, {"comb", "route+ip", perIP}}
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, expected ip, route or combined", strategy)
+	}
+	rules := make([]rateLimitRule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := newRateLimitRule(spec.name, spec.key, spec.section, flat)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
 }
 
-func (s *rlState) get(key string) *bucket {
-	if v, ok := s.m.Load(key); ok {
-		return v.(*bucket)
+// newRateLimitRule 按键表达式 expr 以及 m 中的 requests_per_second、burst、on_missing 创建规则，
+// 未配置速率时使用 def
+func newRateLimitRule(name, expr string, m map[string]any, def ratelimit.Limit) (rateLimitRule, error) {
+	if expr == "route_ip" {
+		expr = "route+ip"
+	}
+	key, err := parseKeyExpr(expr)
+	if err != nil {
+		return rateLimitRule{}, err
+	}
+	onMissing := strings.ToLower(util.StrOr(m["on_missing"], "ip"))
+	if onMissing != "ip" && onMissing != "skip" && onMissing != "reject" {
+		return rateLimitRule{}, fmt.Errorf("unknown on_missing %q, expected ip, skip or reject", onMissing)
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if v, ok := s.m.Load(key); ok {
-		return v.(*bucket)
+	limit := ratelimit.Limit{
+		Rate:  parseFloat(m["requests_per_second"], def.Rate),
+		Burst: int64(parseInt(m["burst"], int(def.Burst))),
 	}
-	b := newBucket(s.rate, s.burst)
-	s.m.Store(key, b)
-	return b
+	if limit.Rate <= 0 || limit.Burst < 1 {
+		return rateLimitRule{}, fmt.Errorf("invalid rate limit %v requests/s with burst %d for rule %q, rate must be positive and burst at least 1", limit.Rate, limit.Burst, name)
+	}
+	return rateLimitRule{name: name, key: key, onMissing: onMissing, limit: limit}, nil
 }
 
-func init() {
-	Register("rate_limiter", func(cfg map[string]any) (gin.HandlerFunc, error) {
-		strategy := strings.ToLower(util.StrOr(cfg["strategy"], "ip")) // ip|route|combined
-		rate := parseFloat(cfg["requests_per_second"], 100.0)
-		burst := parseInt(cfg["burst"], 50)
-
-		// allow nested like config.global/ per_ip; prefer flat minimal config
-		if g, ok := cfg["global"].(map[string]any); ok {
-			rate = parseFloat(g["requests_per_second"], rate)
-			burst = parseInt(g["burst"], int(burst))
-		}
+// rateLimitStoreConfig 解析后的限流存储配置，open 时才创建存储与连接
+type rateLimitStoreConfig struct {
+	kind     string // local 或 redis
+	local    ratelimit.LocalOptions
+	failOpen bool
+	redis    *redis.Options
+	prefix   string
+}
 
-		state := &rlState{rate: rate, burst: int64(burst)}
-
-		keyFn := func(c *gin.Context) string {
-			ip := util.ClientIP(c.Request)
-			route, _ := c.Get("route.prefix")
-			switch strategy {
-			case "route":
-				return "route:" + toString(route)
-			case "combined":
-				return "comb:" + toString(route) + ":" + ip
-			default:
-				return "ip:" + ip
-			}
+// parseRateLimitStore 解析 store 配置。redis 存储出错时按 failure_mode 处理：
+// open（默认）退化为进程内限流，closed 拒绝请求。进程内存储的 key 数受 max_keys 限制，
+// 每隔 cleanup_interval 清理空闲 key
+func parseRateLimitStore(cfg map[string]any) (rateLimitStoreConfig, error) {
+	sc := rateLimitStoreConfig{local: ratelimit.LocalOptions{MaxKeys: parseInt(cfg["max_keys"], ratelimit.DefaultMaxKeys)}}
+	if sc.local.MaxKeys < 1 {
+		return sc, fmt.Errorf("invalid max_keys %d", sc.local.MaxKeys)
+	}
+	if v := util.StrOr(cfg["cleanup_interval"], ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return sc, fmt.Errorf("invalid cleanup_interval %q", v)
 		}
+		sc.local.CleanupInterval = d
+	}
 
-		return func(c *gin.Context) {
-			k := keyFn(c)
-			b := state.get(k)
-			if !b.allow(1) {
-				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
-				return
-			}
-			c.Next()
-		}, nil
-	})
+	sc.kind = strings.ToLower(util.StrOr(cfg["store"], "local"))
+	switch sc.kind {
+	case "local":
+		return sc, nil
+	case "redis":
+	default:
+		return sc, fmt.Errorf("unknown rate limit store %q, expected local or redis", sc.kind)
+	}
+
+	mode := strings.ToLower(util.StrOr(cfg["failure_mode"], "open"))
+	if mode != "open" && mode != "closed" {
+		return sc, fmt.Errorf("unknown failure_mode %q, expected open or closed", mode)
+	}
+	sc.failOpen = mode == "open"
+	var err error
+	if sc.redis, err = parseRedisOptions(cfg); err != nil {
+		return sc, err
+	}
+	sc.prefix = util.StrOr(cfg["redis_prefix"], "lens:rl:")
+	return sc, nil
+}
+
+// open 创建限流存储，返回的释放函数停止清理并关闭 Redis 连接
+func (sc rateLimitStoreConfig) open(algo ratelimit.Algorithm) (ratelimit.Limiter, func()) {
+	if sc.kind == "local" {
+		local := ratelimit.NewLocal(algo, sc.local)
+		return local, local.Close
+	}
+	client := redis.NewClient(sc.redis)
+	shared := ratelimit.NewRedis(algo, client, sc.prefix)
+	if !sc.failOpen {
+		return ratelimit.NewFallback(shared, nil, time.Second), func() { _ = client.Close() }
+	}
+	local := ratelimit.NewLocal(algo, sc.local)
+	return ratelimit.NewFallback(shared, local, time.Second), func() {
+		_ = client.Close()
+		local.Close()
+	}
+}
+
+// parseRedisOptions 按 redis_addr、redis_password、redis_db、redis_timeout 生成 Redis 客户端配置
+func parseRedisOptions(cfg map[string]any) (*redis.Options, error) {
+	timeout := 100 * time.Millisecond
+	if v := util.StrOr(cfg["redis_timeout"], ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid redis_timeout %q", v)
+		}
+		timeout = d
+	}
+	return &redis.Options{
+		Addr:         util.StrOr(cfg["redis_addr"], "localhost:6379"),
+		Password:     util.StrOr(cfg["redis_password"], ""),
+		DB:           parseInt(cfg["redis_db"], 0),
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		MaxRetries:   -1, // 失败后直接降级，不在请求路径上重试
+	}, nil
 }
 
 func parseFloat(v interface{}, def float64) float64 {
 	switch t := v.(type) {
 	case float64:
 		return t
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
 	case string:
 		if f, err := strconv.ParseFloat(t, 64); err == nil {
 			return f
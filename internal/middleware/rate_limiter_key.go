@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"LensGateway.com/util"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 限流键表达式：由 "+" 连接的一项或多项，每项取请求的一部分，全部取到值时组成限流键。
+//
+//	ip              客户端 IP
+//	route           匹配到的路由前缀
+//	consumer        key_auth 识别出的调用方名称
+//	header:<name>   请求头，例如 header:X-Tenant-Id
+//	query:<name>    查询参数，例如 query:api_key
+//	cookie:<name>   Cookie
+//	claim:<name>    auth_jwt 校验通过的 JWT 中的 claim，例如 claim:sub
+//	meta:<name>     调用方的元数据，例如 meta:plan
+//	ctx:<name>      前置中间件写入请求上下文的值，例如 ctx:auth.sub
+//	path:<n>        请求路径的第 n 段（从 1 开始），例如 /tenants/42/orders 的 path:2 为 42
+//
+// 例如 "header:X-Tenant-Id+route" 按租户与路由分别限流，"consumer" 按调用方限流。
+
+// keyTerm 从请求中取一项的值，取不到时返回空字符串
+type keyTerm func(c *gin.Context) string
+
+// maxKeyPart 超过该长度的值按 SHA-256 摘要计入限流键，避免过长的请求头撑大存储
+const maxKeyPart = 64
+
+// parseKeyExpr 解析限流键表达式，返回的函数在任一项取不到值时返回 false
+func parseKeyExpr(expr string) (func(c *gin.Context) (string, bool), error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("empty key expression")
+	}
+	var terms []keyTerm
+	for _, part := range strings.Split(expr, "+") {
+		term, err := parseKeyTerm(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", expr, err)
+		}
+		terms = append(terms, term)
+	}
+	return func(c *gin.Context) (string, bool) {
+		values := make([]string, len(terms))
+		for i, term := range terms {
+			v := term(c)
+			if v == "" {
+				return "", false
+			}
+			values[i] = keyPart(v)
+		}
+		return strings.Join(values, ":"), true
+	}, nil
+}
+
+// keyPart 将一项取值转换为键的一段：过长的值取摘要，再转义。
+// 转义后的值不含 ":"，不同组合不会拼出相同的键
+func keyPart(v string) string {
+	if len(v) > maxKeyPart {
+		sum := sha256.Sum256([]byte(v))
+		v = hex.EncodeToString(sum[:16])
+	}
+	return url.QueryEscape(v)
+}
+
+// KeyOf 将以 ":" 连接的各项原始取值转换为键表达式生成的键，
+// 供管理接口按调用方的原始名称查找 quota 等中间件的计数
+func KeyOf(raw string) string {
+	values := strings.Split(raw, ":")
+	for i, v := range values {
+		values[i] = keyPart(v)
+	}
+	return strings.Join(values, ":")
+}
+
+func parseKeyTerm(term string) (keyTerm, error) {
+	switch term {
+	case "ip":
+		return func(c *gin.Context) string { return util.ClientIP(c.Request) }, nil
+	case "route":
+		return func(c *gin.Context) string {
+			route, _ := c.Get("route.prefix")
+			return toString(route)
+		}, nil
+	case "consumer":
+		return func(c *gin.Context) string { return c.GetString("consumer") }, nil
+	}
+
+	source, name, ok := strings.Cut(term, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("unknown term %q, expected ip, route, consumer, header:, query:, cookie:, claim:, meta:, ctx: or path:", term)
+	}
+	switch source {
+	case "header":
+		return func(c *gin.Context) string { return c.GetHeader(name) }, nil
+	case "query":
+		return func(c *gin.Context) string { return c.Query(name) }, nil
+	case "cookie":
+		return func(c *gin.Context) string {
+			v, _ := c.Cookie(name)
+			return v
+		}, nil
+	case "claim":
+		return func(c *gin.Context) string {
+			v, _ := c.Get("jwt_claims")
+			claims, _ := v.(jwt.MapClaims)
+			switch v := claims[name].(type) {
+			case string:
+				return v
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				return strconv.FormatBool(v)
+			}
+			return ""
+		}, nil
+	case "meta":
+		return func(c *gin.Context) string { return c.GetStringMapString("consumer.metadata")[name] }, nil
+	case "ctx":
+		return func(c *gin.Context) string {
+			v, _ := c.Get(name)
+			switch v := v.(type) {
+			case string:
+				return v
+			case fmt.Stringer:
+				return v.String()
+			}
+			return ""
+		}, nil
+	case "path":
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("path segment in %q must be a positive number", term)
+		}
+		return func(c *gin.Context) string {
+			segments := strings.Split(strings.Trim(c.Request.URL.Path, "/"), "/")
+			if n > len(segments) {
+				return ""
+			}
+			return segments[n-1]
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown term %q, expected ip, route, consumer, header:, query:, cookie:, claim:, meta:, ctx: or path:", term)
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParseKeyExpr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/tenants/42/orders?api_key=k1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Tenant-Id", "acme:eu")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	c.Set("route.prefix", "/tenants")
+	c.Set("auth.sub", "alice")
+	c.Set("jwt_claims", jwt.MapClaims{"sub": "alice", "tier": float64(2)})
+	c.Set("consumer", "billing")
+	c.Set("consumer.metadata", map[string]string{"plan": "pro"})
+
+	cases := []struct {
+		expr string
+		want string // "" when the key is missing
+	}{
+		{"ip", "10.0.0.1"},
+		{"header:X-Tenant-Id", "acme%3Aeu"},
+		{"header:X-Tenant-Id + route", "acme%3Aeu:%2Ftenants"},
+		{"query:api_key", "k1"},
+		{"claim:tier", "2"},
+		{"ctx:auth.sub+path:2", "alice:42"},
+		{"path:4", ""},
+		{"header:X-Api-Key+ip", ""},
+		{"cookie:session", ""},
+		{"consumer+meta:plan", "billing:pro"},
+		{"meta:region", ""},
+	}
+	for _, tc := range cases {
+		key, err := parseKeyExpr(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		got, ok := key(c)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("%s = %q, %v; want %q", tc.expr, got, ok, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"", "tenant", "header:", "path:0", "ip+", "meta:"} {
+		if _, err := parseKeyExpr(expr); err == nil {
+			t.Errorf("%q should be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newEngine := func(cfg map[string]any) *gin.Engine {
+		handler, release, err := New("rate_limiter", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(release)
+		r := gin.New()
+		r.Use(handler)
+		r.GET("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		return r
+	}
+	do := func(r *gin.Engine, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if tenant != "" {
+			req.Header.Set("X-Tenant-Id", tenant)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	r := newEngine(map[string]any{
+		"rules": []any{
+			map[string]any{"name": "per_tenant", "key": "header:X-Tenant-Id", "on_missing": "reject", "requests_per_second": 1, "burst": 2},
+			map[string]any{"name": "per_ip", "key": "ip", "requests_per_second": 10, "burst": 5},
+		},
+	})
+	w := do(r, "acme")
+	if w.Code != http.StatusNoContent ||
+		w.Header().Get("RateLimit-Policy") != `"per_tenant";q=2;w=2, "per_ip";q=5;w=1` ||
+		w.Header().Get("RateLimit") != `"per_tenant";r=1;t=1, "per_ip";r=4;t=1` {
+		t.Fatalf("first request: %d %v", w.Code, w.Header())
+	}
+	do(r, "acme")
+	w = do(r, "acme")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" ||
+		w.Header().Get("RateLimit") != `"per_tenant";r=0;t=2, "per_ip";r=3;t=1` {
+		t.Fatalf("third request: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	if w = do(r, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("request without tenant: %d", w.Code)
+	}
+
+	r = newEngine(map[string]any{"headers": "legacy", "requests_per_second": 1, "burst": 1})
+	do(r, "")
+	w = do(r, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Limit") != "1" ||
+		w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Reset") != "1" ||
+		w.Header().Get("RateLimit") != "" {
+		t.Fatalf("legacy headers: %d %v", w.Code, w.Header())
+	}
+}
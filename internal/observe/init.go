@@ -11,6 +11,7 @@ import (
 )
 
 func init() {
+	middleware.RegisterKeys("observe")
 	middleware.Register("observe", func(cfg map[string]any) (gin.HandlerFunc, error) {
 		return func(c *gin.Context) {
 			start := time.Now()
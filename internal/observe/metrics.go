@@ -33,3 +33,37 @@ var (
 		[]string{"method", "path"},
 	)
 )
+
+// Per-route metrics, labelled with the matched route prefix rather than the raw path,
+// so cardinality stays bounded by the routing table. The admin dashboard reads them in-process.
+var (
+	RouteRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lens_gateway",
+			Name:      "route_requests_total",
+			Help:      "Total number of requests per matched route.",
+		},
+		[]string{"route", "status"},
+	)
+
+	RouteRequestDurationSeconds = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "lens_gateway",
+			Name:      "route_request_duration_seconds",
+			Help:      "Latency of requests per matched route in seconds, including route middlewares.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"route"},
+	)
+
+	RouteFailoverTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lens_gateway",
+			Name:      "route_failover_total",
+			Help:      "Total number of requests whose route's primary upstream had no available node.",
+		},
+		// action: upstream (served by the backup upstream), static (static fallback response),
+		// none (no fallback configured or the backup was down too, answered 502)
+		[]string{"route", "action"},
+	)
+)
@@ -0,0 +1,88 @@
+package observe
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RouteStat 单条路由自进程启动以来的累计统计
+type RouteStat struct {
+	Route    string `json:"route"`
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"` // 5xx 响应
+	// 耗时直方图：样本数、总耗时（秒）与累计桶（按上界升序，不含 +Inf）
+	LatencyCount uint64   `json:"latency_count"`
+	LatencySum   float64  `json:"latency_sum"`
+	Buckets      []Bucket `json:"buckets"`
+}
+
+// Bucket 直方图桶：耗时不超过 LE 秒的请求数
+type Bucket struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// RouteStats 从进程内的 Prometheus 注册表读取各路由的统计，按路由排序。
+// 速率与分位数由调用方对两次读取的差值计算。
+func RouteStats() ([]RouteStat, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]*RouteStat)
+	stat := func(route string) *RouteStat {
+		if s, ok := stats[route]; ok {
+			return s
+		}
+		s := &RouteStat{Route: route}
+		stats[route] = s
+		return s
+	}
+	for _, mf := range families {
+		switch mf.GetName() {
+		case "lens_gateway_route_requests_total":
+			for _, m := range mf.GetMetric() {
+				var route, status string
+				for _, lp := range m.GetLabel() {
+					switch lp.GetName() {
+					case "route":
+						route = lp.GetValue()
+					case "status":
+						status = lp.GetValue()
+					}
+				}
+				s := stat(route)
+				n := uint64(m.GetCounter().GetValue())
+				s.Requests += n
+				if strings.HasPrefix(status, "5") {
+					s.Errors += n
+				}
+			}
+		case "lens_gateway_route_request_duration_seconds":
+			for _, m := range mf.GetMetric() {
+				var route string
+				for _, lp := range m.GetLabel() {
+					if lp.GetName() == "route" {
+						route = lp.GetValue()
+					}
+				}
+				s := stat(route)
+				h := m.GetHistogram()
+				s.LatencyCount, s.LatencySum = h.GetSampleCount(), h.GetSampleSum()
+				s.Buckets = s.Buckets[:0]
+				for _, b := range h.GetBucket() {
+					s.Buckets = append(s.Buckets, Bucket{LE: b.GetUpperBound(), Count: b.GetCumulativeCount()})
+				}
+			}
+		}
+	}
+
+	out := make([]RouteStat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out, nil
+}
@@ -0,0 +1,159 @@
+package quota
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("quota")
+
+// boltStore 保存在本地 bbolt 文件中的计数，只适合单个网关实例。
+// 每个值为 8 字节计数与 8 字节过期时间（Unix 秒），过期的计数视为 0 并定期清理
+type boltStore struct {
+	path string
+	db   *bolt.DB
+	refs int
+	stop chan struct{}
+}
+
+// 同一文件只能被打开一次，而重新加载配置时新的中间件实例先于旧实例释放前创建，
+// 因此按路径共享已打开的文件，最后一个使用者关闭时才真正关闭
+var (
+	boltMu     sync.Mutex
+	boltStores = make(map[string]*boltStore)
+)
+
+// OpenBolt 打开（或创建）bbolt 文件作为计数存储，每隔 cleanupInterval 删除过期计数
+func OpenBolt(path string, cleanupInterval time.Duration) (Store, error) {
+	boltMu.Lock()
+	defer boltMu.Unlock()
+	if s, ok := boltStores[path]; ok {
+		s.refs++
+		return s, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	s := &boltStore{path: path, db: db, refs: 1, stop: make(chan struct{})}
+	boltStores[path] = s
+	go s.janitor(cleanupInterval)
+	return s, nil
+}
+
+func (s *boltStore) janitor(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			_ = s.sweep(now)
+		}
+	}
+}
+
+// sweep 删除已过期的计数
+func (s *boltStore) sweep(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if _, ok := decodeBolt(v, now); !ok {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// decodeBolt 返回计数，ok 为 false 表示已过期
+func decodeBolt(v []byte, now time.Time) (count int64, ok bool) {
+	if len(v) != 16 || int64(binary.BigEndian.Uint64(v[8:])) <= now.Unix() {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(v)), true
+}
+
+func encodeBolt(count int64, expireAt time.Time) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v, uint64(count))
+	binary.BigEndian.PutUint64(v[8:], uint64(expireAt.Unix()))
+	return v
+}
+
+func (s *boltStore) Consume(_ context.Context, n int64, counters ...Counter) ([]int64, bool, error) {
+	used := make([]int64, len(counters))
+	ok := true
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		now := time.Now()
+		for i, c := range counters {
+			used[i], _ = decodeBolt(b.Get([]byte(c.Key)), now)
+			ok = ok && used[i]+n <= c.Limit
+		}
+		if !ok {
+			return nil
+		}
+		for i, c := range counters {
+			used[i] += n
+			if err := b.Put([]byte(c.Key), encodeBolt(used[i], c.ExpireAt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return used, ok, err
+}
+
+func (s *boltStore) Get(_ context.Context, keys ...string) ([]int64, error) {
+	used := make([]int64, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		now := time.Now()
+		for i, key := range keys {
+			used[i], _ = decodeBolt(b.Get([]byte(key)), now)
+		}
+		return nil
+	})
+	return used, err
+}
+
+func (s *boltStore) Delete(_ context.Context, keys ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	boltMu.Lock()
+	defer boltMu.Unlock()
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	delete(boltStores, s.path)
+	close(s.stop)
+	return s.db.Close()
+}
@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdMaxRetries 并发修改同一计数时乐观事务的最大重试次数
+const etcdMaxRetries = 16
+
+// etcdStore 保存在 etcd 中的计数。用 ModRevision 比较实现乐观并发，
+// 计数挂在窗口结束时到期的租约上，同一结束时间的计数共用一个租约
+type etcdStore struct {
+	kv     clientv3.KV
+	lease  clientv3.Lease
+	closer func() error
+	prefix string
+
+	mu     sync.Mutex
+	leases map[int64]clientv3.LeaseID // 按过期时间（Unix 秒）缓存的租约
+}
+
+// NewEtcd 创建 etcd 计数存储，key 加上 prefix
+func NewEtcd(client *clientv3.Client, prefix string) Store {
+	return &etcdStore{kv: client, lease: client, closer: client.Close, prefix: prefix, leases: make(map[int64]clientv3.LeaseID)}
+}
+
+// leaseFor 返回 expireAt 之后到期的租约
+func (e *etcdStore) leaseFor(ctx context.Context, expireAt time.Time) (clientv3.LeaseID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if id, ok := e.leases[expireAt.Unix()]; ok {
+		return id, nil
+	}
+	now := time.Now()
+	for at := range e.leases {
+		if at <= now.Unix() {
+			delete(e.leases, at)
+		}
+	}
+	resp, err := e.lease.Grant(ctx, int64(expireAt.Sub(now)/time.Second)+60)
+	if err != nil {
+		return 0, err
+	}
+	e.leases[expireAt.Unix()] = resp.ID
+	return resp.ID, nil
+}
+
+func (e *etcdStore) forgetLease(expireAt time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.leases, expireAt.Unix())
+}
+
+func (e *etcdStore) Consume(ctx context.Context, n int64, counters ...Counter) ([]int64, bool, error) {
+	gets := make([]clientv3.Op, len(counters))
+	for i, c := range counters {
+		gets[i] = clientv3.OpGet(e.prefix + c.Key)
+	}
+	for range etcdMaxRetries {
+		resp, err := e.kv.Txn(ctx).Then(gets...).Commit()
+		if err != nil {
+			return nil, false, err
+		}
+		used := make([]int64, len(counters))
+		cmps := make([]clientv3.Cmp, len(counters))
+		ok := true
+		for i, c := range counters {
+			var modRev int64
+			if kvs := resp.Responses[i].GetResponseRange().GetKvs(); len(kvs) > 0 {
+				used[i], _ = strconv.ParseInt(string(kvs[0].Value), 10, 64)
+				modRev = kvs[0].ModRevision
+			}
+			cmps[i] = clientv3.Compare(clientv3.ModRevision(e.prefix+c.Key), "=", modRev)
+			ok = ok && used[i]+n <= c.Limit
+		}
+		if !ok {
+			return used, false, nil
+		}
+
+		puts := make([]clientv3.Op, len(counters))
+		for i, c := range counters {
+			lease, err := e.leaseFor(ctx, c.ExpireAt)
+			if err != nil {
+				return nil, false, err
+			}
+			used[i] += n
+			puts[i] = clientv3.OpPut(e.prefix+c.Key, strconv.FormatInt(used[i], 10), clientv3.WithLease(lease))
+		}
+		txn, err := e.kv.Txn(ctx).If(cmps...).Then(puts...).Commit()
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			// 租约被提前撤销，重新申请
+			for _, c := range counters {
+				e.forgetLease(c.ExpireAt)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if txn.Succeeded {
+			return used, true, nil
+		}
+	}
+	return nil, false, fmt.Errorf("quota counters changed concurrently %d times, giving up", etcdMaxRetries)
+}
+
+func (e *etcdStore) Get(ctx context.Context, keys ...string) ([]int64, error) {
+	gets := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		gets[i] = clientv3.OpGet(e.prefix + key)
+	}
+	resp, err := e.kv.Txn(ctx).Then(gets...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	used := make([]int64, len(keys))
+	for i := range keys {
+		if kvs := resp.Responses[i].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			used[i], _ = strconv.ParseInt(string(kvs[0].Value), 10, 64)
+		}
+	}
+	return used, nil
+}
+
+func (e *etcdStore) Delete(ctx context.Context, keys ...string) error {
+	dels := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		dels[i] = clientv3.OpDelete(e.prefix + key)
+	}
+	_, err := e.kv.Txn(ctx).Then(dels...).Commit()
+	return err
+}
+
+func (e *etcdStore) Close() error {
+	return e.closer()
+}
@@ -0,0 +1,263 @@
+// Package quota 按日历对齐的周期（每小时、每天、每周、每月）统计每个调用方的请求数，
+// 计数保存在可替换的存储（本地 bbolt 文件、Redis 或 etcd）中，网关重启后仍然有效。
+package quota
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Period 配额周期，窗口按 Quota 的时区对齐到自然小时、日、周（周一开始）、月
+type Period string
+
+const (
+	Hourly  Period = "hourly"
+	Daily   Period = "daily"
+	Weekly  Period = "weekly"
+	Monthly Period = "monthly"
+)
+
+// Periods 所有周期，从短到长
+var Periods = []Period{Hourly, Daily, Weekly, Monthly}
+
+// ParsePeriod 解析周期名称
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(strings.ToLower(s)); p {
+	case Hourly, Daily, Weekly, Monthly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown quota period %q, expected hourly, daily, weekly or monthly", s)
+}
+
+// Window 返回 t 所在窗口的起止时间，t 的时区决定窗口边界
+func (p Period) Window(t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	switch p {
+	case Hourly:
+		start = time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+		return start, start.Add(time.Hour)
+	case Daily:
+		start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1)
+	case Weekly:
+		offset := (int(t.Weekday()) + 6) % 7 // 距周一的天数
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 7)
+	default:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// stamp 窗口在计数键中的标识，例如 2026-10（月）、2026-10-19（周，取周一）
+func (p Period) stamp(start time.Time) string {
+	switch p {
+	case Hourly:
+		return start.Format("2006-01-02T15")
+	case Monthly:
+		return start.Format("2006-01")
+	}
+	return start.Format("2006-01-02")
+}
+
+// Limit 一个周期内允许的请求数
+type Limit struct {
+	Period   Period `json:"period"`
+	Requests int64  `json:"requests"`
+}
+
+// Counter 存储中的一个计数，窗口结束后（ExpireAt）即可删除
+type Counter struct {
+	Key      string
+	Limit    int64
+	ExpireAt time.Time
+}
+
+// Store 计数存储
+type Store interface {
+	// Consume 在所有计数都不会超过各自 Limit 时给它们加 n，返回调用后的计数；
+	// 任一计数超限时不修改任何计数，ok 为 false
+	Consume(ctx context.Context, n int64, counters ...Counter) (used []int64, ok bool, err error)
+	// Get 返回计数，不存在或已过期的计数为 0
+	Get(ctx context.Context, keys ...string) ([]int64, error)
+	// Delete 删除计数
+	Delete(ctx context.Context, keys ...string) error
+	Close() error
+}
+
+// Usage 调用方在一个周期的当前窗口中的用量
+type Usage struct {
+	Period    Period    `json:"period"`
+	Limit     int64     `json:"limit,omitempty"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Start     time.Time `json:"window_start"`
+	End       time.Time `json:"window_end"`
+}
+
+// Quota 一组按套餐（plan）区分的配额，计数键为 name:调用方:周期:窗口
+type Quota struct {
+	name        string
+	store       Store
+	loc         *time.Location
+	plans       map[string][]Limit
+	defaultPlan string
+	now         func() time.Time
+}
+
+// New 创建配额。plans 中每个套餐列出各周期的限额，未指定套餐的调用方使用 defaultPlan
+func New(name string, store Store, loc *time.Location, plans map[string][]Limit, defaultPlan string) (*Quota, error) {
+	if _, ok := plans[defaultPlan]; !ok {
+		return nil, fmt.Errorf("default plan %q is not defined", defaultPlan)
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Quota{name: name, store: store, loc: loc, plans: plans, defaultPlan: defaultPlan, now: time.Now}, nil
+}
+
+// Name 返回配额名称
+func (q *Quota) Name() string { return q.name }
+
+// Plans 返回各套餐的限额
+func (q *Quota) Plans() map[string][]Limit { return q.plans }
+
+// DefaultPlan 返回默认套餐
+func (q *Quota) DefaultPlan() string { return q.defaultPlan }
+
+// Limits 返回套餐的限额，未知套餐使用默认套餐
+func (q *Quota) Limits(plan string) []Limit {
+	if limits, ok := q.plans[plan]; ok {
+		return limits
+	}
+	return q.plans[q.defaultPlan]
+}
+
+func (q *Quota) key(consumer string, p Period, start time.Time) string {
+	return q.name + ":" + consumer + ":" + string(p) + ":" + p.stamp(start)
+}
+
+// Consume 为调用方计入 n 个请求。任一周期超限时不计入，ok 为 false
+func (q *Quota) Consume(ctx context.Context, consumer, plan string, n int64) (usage []Usage, ok bool, err error) {
+	limits := q.Limits(plan)
+	now := q.now().In(q.loc)
+	counters := make([]Counter, len(limits))
+	usage = make([]Usage, len(limits))
+	for i, l := range limits {
+		start, end := l.Period.Window(now)
+		counters[i] = Counter{Key: q.key(consumer, l.Period, start), Limit: l.Requests, ExpireAt: end}
+		usage[i] = Usage{Period: l.Period, Limit: l.Requests, Start: start, End: end}
+	}
+	used, ok, err := q.store.Consume(ctx, n, counters...)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range usage {
+		usage[i].Used = used[i]
+		usage[i].Remaining = max(0, usage[i].Limit-used[i])
+	}
+	return usage, ok, nil
+}
+
+// periods 返回所有套餐用到的周期，从短到长
+func (q *Quota) periods() []Period {
+	var periods []Period
+	for _, p := range Periods {
+		for _, limits := range q.plans {
+			if slices.ContainsFunc(limits, func(l Limit) bool { return l.Period == p }) {
+				periods = append(periods, p)
+				break
+			}
+		}
+	}
+	return periods
+}
+
+// Inspect 返回调用方在所有已配置周期的当前窗口中的用量（不含限额，限额取决于调用方的套餐）
+func (q *Quota) Inspect(ctx context.Context, consumer string) ([]Usage, error) {
+	now := q.now().In(q.loc)
+	periods := q.periods()
+	keys := make([]string, len(periods))
+	usage := make([]Usage, len(periods))
+	for i, p := range periods {
+		start, end := p.Window(now)
+		keys[i] = q.key(consumer, p, start)
+		usage[i] = Usage{Period: p, Start: start, End: end}
+	}
+	used, err := q.store.Get(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range usage {
+		usage[i].Used = used[i]
+	}
+	return usage, nil
+}
+
+// Reset 清零调用方在当前窗口的用量，periods 为空时清零所有周期
+func (q *Quota) Reset(ctx context.Context, consumer string, periods ...Period) error {
+	if len(periods) == 0 {
+		periods = q.periods()
+	}
+	now := q.now().In(q.loc)
+	keys := make([]string, len(periods))
+	for i, p := range periods {
+		start, _ := p.Window(now)
+		keys[i] = q.key(consumer, p, start)
+	}
+	return q.store.Delete(ctx, keys...)
+}
+
+// 正在生效的配额，供管理接口查询与重置。重新加载配置与校验配置时会同时存在同名的多个实例，
+// 按创建顺序保存，查询时返回最新的实例
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string][]*Quota)
+)
+
+// Register 注册配额，在中间件释放时调用 Unregister
+func Register(q *Quota) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[q.name] = append(registry[q.name], q)
+}
+
+// Unregister 注销配额
+func Unregister(q *Quota) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	list := slices.DeleteFunc(registry[q.name], func(other *Quota) bool { return other == q })
+	if len(list) == 0 {
+		delete(registry, q.name)
+		return
+	}
+	registry[q.name] = list
+}
+
+// Lookup 按名称查找最新注册的配额
+func Lookup(name string) (*Quota, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := registry[name]
+	if len(list) == 0 {
+		return nil, false
+	}
+	return list[len(list)-1], true
+}
+
+// Names 返回正在生效的配额名称，已排序
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
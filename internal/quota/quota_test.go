@@ -0,0 +1,163 @@
+package quota
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestWindow(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	// Sunday 2026-10-18 23:30 UTC is Monday 07:30 in UTC+8
+	now := time.Date(2026, 10, 18, 23, 30, 0, 0, time.UTC)
+	cases := []struct {
+		period     Period
+		t          time.Time
+		start, end string
+	}{
+		{Hourly, now, "2026-10-18T23:00:00Z", "2026-10-19T00:00:00Z"},
+		{Daily, now, "2026-10-18T00:00:00Z", "2026-10-19T00:00:00Z"},
+		{Weekly, now, "2026-10-12T00:00:00Z", "2026-10-19T00:00:00Z"},
+		{Weekly, now.In(shanghai), "2026-10-19T00:00:00+08:00", "2026-10-26T00:00:00+08:00"},
+		{Monthly, now, "2026-10-01T00:00:00Z", "2026-11-01T00:00:00Z"},
+		{Monthly, time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC), "2026-12-01T00:00:00Z", "2027-01-01T00:00:00Z"},
+	}
+	for _, tc := range cases {
+		start, end := tc.period.Window(tc.t)
+		if start.Format(time.RFC3339) != tc.start || end.Format(time.RFC3339) != tc.end {
+			t.Errorf("%s window of %v = %v - %v, want %s - %s", tc.period, tc.t, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// testStores returns a fresh store of every kind that can run without external services.
+func testStores(t *testing.T) map[string]Store {
+	bolt, err := OpenBolt(filepath.Join(t.TempDir(), "quota.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := miniredis.RunT(t)
+	stores := map[string]Store{
+		"bolt":  bolt,
+		"redis": NewRedis(redis.NewClient(&redis.Options{Addr: m.Addr()}), "test:"),
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	})
+	return stores
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			q, err := New("plans", store, time.UTC, map[string][]Limit{
+				"free": {{Daily, 2}, {Monthly, 3}},
+				"pro":  {{Monthly, 100}},
+			}, "free")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// stores expire counters by the wall clock, keep the windows in the future
+			now := time.Date(2100, 3, 10, 12, 0, 0, 0, time.UTC)
+			q.now = func() time.Time { return now }
+
+			for i := 1; i <= 2; i++ {
+				usage, ok, err := q.Consume(ctx, "k1", "", 1)
+				if err != nil || !ok || usage[0].Used != int64(i) || usage[1].Remaining != int64(3-i) {
+					t.Fatalf("request %d: %+v %v %v", i, usage, ok, err)
+				}
+			}
+			// the daily quota is used up, the monthly count must not move
+			if usage, ok, err := q.Consume(ctx, "k1", "free", 1); err != nil || ok || usage[1].Used != 2 {
+				t.Fatalf("over the daily quota: %+v %v %v", usage, ok, err)
+			}
+			// another consumer and another plan are counted separately
+			if _, ok, _ := q.Consume(ctx, "k2", "pro", 1); !ok {
+				t.Fatal("k2 rejected")
+			}
+
+			now = now.Add(24 * time.Hour)
+			if _, ok, _ := q.Consume(ctx, "k1", "free", 1); !ok {
+				t.Fatal("rejected on the next day")
+			}
+			if _, ok, _ := q.Consume(ctx, "k1", "free", 1); ok {
+				t.Fatal("allowed over the monthly quota")
+			}
+
+			usage, err := q.Inspect(ctx, "k1")
+			if err != nil || len(usage) != 2 || usage[0].Period != Daily || usage[0].Used != 1 || usage[1].Used != 3 {
+				t.Fatalf("inspect: %+v %v", usage, err)
+			}
+			if err := q.Reset(ctx, "k1", Monthly); err != nil {
+				t.Fatal(err)
+			}
+			if usage, _ = q.Inspect(ctx, "k1"); usage[0].Used != 1 || usage[1].Used != 0 {
+				t.Fatalf("after monthly reset: %+v", usage)
+			}
+			if err := q.Reset(ctx, "k1"); err != nil {
+				t.Fatal(err)
+			}
+			if usage, _ = q.Inspect(ctx, "k1"); usage[0].Used != 0 {
+				t.Fatalf("after reset: %+v", usage)
+			}
+		})
+	}
+}
+
+func TestBoltSharedAndSweep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.db")
+	a, err := OpenBolt(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reloaded middleware opens the same file before the old one is released
+	b, err := OpenBolt(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	expireAt := time.Now().Add(time.Hour)
+	if _, ok, err := a.Consume(ctx, 1, Counter{Key: "k", Limit: 5, ExpireAt: expireAt}); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	a.Close()
+	if used, err := b.Get(ctx, "k"); err != nil || used[0] != 1 {
+		t.Fatalf("after closing the first handle: %v %v", used, err)
+	}
+
+	if err := b.(*boltStore).sweep(expireAt.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	c, err := OpenBolt(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if used, _ := c.Get(ctx, "k"); used[0] != 0 {
+		t.Fatalf("expired counter survived the sweep: %v", used)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	live := &Quota{name: "reg"}
+	Register(live)
+	// validating a config creates and releases another instance with the same name
+	check := &Quota{name: "reg"}
+	Register(check)
+	Unregister(check)
+	if q, ok := Lookup("reg"); !ok || q != live {
+		t.Fatal("live quota lost after validation")
+	}
+	Unregister(live)
+	if _, ok := Lookup("reg"); ok {
+		t.Fatal("quota still registered")
+	}
+}
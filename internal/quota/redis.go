@@ -0,0 +1,90 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// consumeScript 在所有计数都不超限时给它们加 n，并设置为窗口结束时过期。
+// ARGV 为 n，之后每个 key 依次为 limit、过期时间（Unix 秒）；返回 {ok, 各计数调用后的值...}
+var consumeScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local used = {}
+local ok = 1
+for i, key in ipairs(KEYS) do
+  used[i] = tonumber(redis.call("GET", key)) or 0
+  if used[i] + n > tonumber(ARGV[2 * i]) then
+    ok = 0
+  end
+end
+if ok == 1 then
+  for i, key in ipairs(KEYS) do
+    used[i] = redis.call("INCRBY", key, n)
+    redis.call("EXPIREAT", key, ARGV[2 * i + 1])
+  end
+end
+table.insert(used, 1, ok)
+return used
+`)
+
+// redisStore 多个网关副本共享的计数，保存在 Redis 中。同一次计入的多个 key 在 Redis Cluster 中需位于同一个 slot
+type redisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedis 创建 Redis 计数存储，key 加上 prefix
+func NewRedis(client redis.UniversalClient, prefix string) Store {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (r *redisStore) keys(keys []string) []string {
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		out[i] = r.prefix + key
+	}
+	return out
+}
+
+func (r *redisStore) Consume(ctx context.Context, n int64, counters ...Counter) ([]int64, bool, error) {
+	keys := make([]string, len(counters))
+	args := make([]any, 0, 1+2*len(counters))
+	args = append(args, n)
+	for i, c := range counters {
+		keys[i] = r.prefix + c.Key
+		args = append(args, c.Limit, c.ExpireAt.Unix())
+	}
+	vals, err := consumeScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(vals) != 1+len(counters) {
+		return nil, false, fmt.Errorf("unexpected quota script result %v", vals)
+	}
+	return vals[1:], vals[0] == 1, nil
+}
+
+func (r *redisStore) Get(ctx context.Context, keys ...string) ([]int64, error) {
+	vals, err := r.client.MGet(ctx, r.keys(keys)...).Result()
+	if err != nil {
+		return nil, err
+	}
+	used := make([]int64, len(keys))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			used[i], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	return used, nil
+}
+
+func (r *redisStore) Delete(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, r.keys(keys)...).Err()
+}
+
+func (r *redisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Fallback 优先使用共享存储；共享存储出错时，在 backoff 期间内不再访问它，
+// fail-open 时改用进程内的 local 限流（每个副本各自限流），fail-closed 时返回 ErrUnavailable。
+type Fallback struct {
+	primary  Limiter
+	local    Limiter // fail-closed 时为 nil
+	backoff  time.Duration
+	failedAt atomic.Int64 // 最近一次出错的 unix nano，0 表示正常
+}
+
+// NewFallback 创建带降级的限流器，local 为 nil 表示 fail-closed
+func NewFallback(primary, local Limiter, backoff time.Duration) *Fallback {
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	return &Fallback{primary: primary, local: local, backoff: backoff}
+}
+
+func (f *Fallback) Allow(ctx context.Context, n int64, reqs ...Request) ([]Result, error) {
+	if failed := f.failedAt.Load(); failed == 0 || time.Since(time.Unix(0, failed)) >= f.backoff {
+		res, err := f.primary.Allow(ctx, n, reqs...)
+		if err == nil {
+			if failed != 0 && f.failedAt.CompareAndSwap(failed, 0) {
+				log.Printf("[gateway] rate limit store recovered")
+			}
+			return res, nil
+		}
+		if f.failedAt.Swap(time.Now().UnixNano()) == 0 {
+			if f.local != nil {
+				log.Printf("[gateway] rate limit store unavailable, limiting per instance: %v", err)
+			} else {
+				log.Printf("[gateway] rate limit store unavailable, rejecting requests: %v", err)
+			}
+		}
+	}
+	if f.local == nil {
+		results := make([]Result, len(reqs))
+		for i, req := range reqs {
+			results[i] = Result{Limit: req.Limit.Burst, RetryAfter: f.backoff}
+		}
+		return results, ErrUnavailable
+	}
+	return f.local.Allow(ctx, n, reqs...)
+}
@@ -0,0 +1,399 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Number of keys held by in-process limiters, across all rate_limiter instances.
+	localKeys = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lens_gateway",
+			Name:      "ratelimit_local_keys",
+			Help:      "Number of rate limit keys tracked in process.",
+		},
+	)
+
+	// Keys dropped from in-process limiters before the limiter was closed.
+	localEvictions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lens_gateway",
+			Name:      "ratelimit_local_evictions_total",
+			Help:      "Total number of rate limit keys evicted from in-process limiters.",
+		},
+		// reason: idle (the state had returned to that of a new key) | capacity (max_keys reached)
+		[]string{"reason"},
+	)
+)
+
+const (
+	// DefaultMaxKeys 进程内限流器默认最多保存的 key 数
+	DefaultMaxKeys = 100000
+	// DefaultCleanupInterval 进程内限流器默认清理空闲 key 的间隔
+	DefaultCleanupInterval = time.Minute
+
+	localShards = 16
+)
+
+// LocalOptions 进程内限流器的内存上限
+type LocalOptions struct {
+	// MaxKeys 最多保存的 key 数，超过时淘汰最久未使用的 key（其计数随之丢失），0 表示 DefaultMaxKeys
+	MaxKeys int
+	// CleanupInterval 清理空闲 key 的间隔，0 表示 DefaultCleanupInterval。
+	// 空闲 key 指状态已恢复到与新 key 相同（例如令牌桶已满）的 key，清理不会影响判定结果
+	CleanupInterval time.Duration
+}
+
+// Local 进程内限流器，每个网关副本各自计数。key 分片保存，每个分片按最近使用顺序淘汰；
+// 每个 key 的状态由各自的互斥锁保护。不再使用时需调用 Close 停止后台清理。
+type Local struct {
+	newState func() localState
+	now      func() time.Time
+	seed     maphash.Seed
+	perShard int
+	shards   [localShards]localShard
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type localShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> *localEntry
+	lru     list.List                // 最近使用的在前
+}
+
+type localEntry struct {
+	mu     sync.Mutex
+	key    string
+	state  localState
+	idleAt atomic.Int64 // 状态恢复到与新 key 相同的时间（unix nano）
+}
+
+// localState 单个 key 的算法状态，调用时已持有该 key 的锁
+type localState interface {
+	// check 将状态推进到 now（补充令牌、移出过期请求等），返回计入 n 个请求前的判定结果，不计入请求
+	check(limit Limit, now time.Time, n int64) Result
+	// take 计入 n 个请求，仅在同一次判定的 check 允许后调用
+	take(limit Limit, now time.Time, n int64)
+	// idleAt 返回状态恢复到与新 key 相同的时间
+	idleAt(limit Limit) time.Time
+}
+
+// NewLocal 创建进程内限流器，并启动后台清理
+func NewLocal(algo Algorithm, opts LocalOptions) *Local {
+	l := newLocal(algo, opts, time.Now)
+	interval := opts.CleanupInterval
+	if interval <= 0 {
+		interval = DefaultCleanupInterval
+	}
+	go l.janitor(interval)
+	return l
+}
+
+func newLocal(algo Algorithm, opts LocalOptions, now func() time.Time) *Local {
+	var newState func() localState
+	switch algo {
+	case TokenBucket:
+		newState = func() localState { return new(tokenBucketState) }
+	case SlidingWindowLog:
+		newState = func() localState { return new(slidingLogState) }
+	case SlidingWindow:
+		newState = func() localState { return new(slidingWindowState) }
+	case GCRA:
+		newState = func() localState { return new(gcraState) }
+	default:
+		panic(fmt.Sprintf("ratelimit: unknown algorithm %q", algo))
+	}
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	l := &Local{
+		newState: newState,
+		now:      now,
+		seed:     maphash.MakeSeed(),
+		perShard: max(1, (maxKeys+localShards-1)/localShards),
+		stop:     make(chan struct{}),
+	}
+	for i := range l.shards {
+		l.shards[i].entries = make(map[string]*list.Element)
+	}
+	return l
+}
+
+// entry 返回 key 的状态，不存在时创建，分片已满时淘汰最久未使用的 key
+func (l *Local) entry(key string) *localEntry {
+	sh := &l.shards[maphash.String(l.seed, key)%localShards]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if el, ok := sh.entries[key]; ok {
+		sh.lru.MoveToFront(el)
+		return el.Value.(*localEntry)
+	}
+	if sh.lru.Len() >= l.perShard {
+		oldest := sh.lru.Back()
+		sh.remove(oldest)
+		localEvictions.WithLabelValues("capacity").Inc()
+	}
+	e := &localEntry{key: key, state: l.newState()}
+	sh.entries[key] = sh.lru.PushFront(e)
+	localKeys.Inc()
+	return e
+}
+
+func (sh *localShard) remove(el *list.Element) {
+	delete(sh.entries, el.Value.(*localEntry).key)
+	sh.lru.Remove(el)
+	localKeys.Dec()
+}
+
+func (l *Local) Allow(_ context.Context, n int64, reqs ...Request) ([]Result, error) {
+	entries := make([]*localEntry, len(reqs))
+	for i, r := range reqs {
+		entries[i] = l.entry(r.Key)
+	}
+	// 按 key 的顺序加锁，规则顺序不同的并发判定也不会死锁
+	locked := slices.Clone(entries)
+	slices.SortFunc(locked, func(a, b *localEntry) int { return strings.Compare(a.key, b.key) })
+	locked = slices.Compact(locked)
+	for _, e := range locked {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+	}
+
+	now := l.now()
+	results := make([]Result, len(reqs))
+	for i, r := range reqs {
+		results[i] = entries[i].state.check(r.Limit, now, n)
+	}
+	allowed := Allowed(results)
+	for i, r := range reqs {
+		if allowed {
+			entries[i].state.take(r.Limit, now, n)
+			results[i].Remaining -= n
+		}
+		idle := entries[i].state.idleAt(r.Limit)
+		entries[i].idleAt.Store(idle.UnixNano())
+		results[i].Reset = max(0, idle.Sub(now))
+	}
+	return results, nil
+}
+
+// Len 返回当前保存的 key 数
+func (l *Local) Len() int {
+	n := 0
+	for i := range l.shards {
+		sh := &l.shards[i]
+		sh.mu.Lock()
+		n += sh.lru.Len()
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// sweep 移除在 now 之前已空闲的 key
+func (l *Local) sweep(now time.Time) {
+	for i := range l.shards {
+		sh := &l.shards[i]
+		sh.mu.Lock()
+		for el := sh.lru.Back(); el != nil; {
+			prev := el.Prev()
+			if idle := el.Value.(*localEntry).idleAt.Load(); idle != 0 && idle <= now.UnixNano() {
+				sh.remove(el)
+				localEvictions.WithLabelValues("idle").Inc()
+			}
+			el = prev
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (l *Local) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.sweep(l.now())
+		}
+	}
+}
+
+// Close 停止后台清理并释放全部 key
+func (l *Local) Close() {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		for i := range l.shards {
+			sh := &l.shards[i]
+			sh.mu.Lock()
+			localKeys.Sub(float64(sh.lru.Len()))
+			sh.entries = make(map[string]*list.Element)
+			sh.lru.Init()
+			sh.mu.Unlock()
+		}
+	})
+}
+
+type tokenBucketState struct {
+	tokens float64
+	last   time.Time // 零值表示新建的桶
+}
+
+func (s *tokenBucketState) check(limit Limit, now time.Time, n int64) Result {
+	burst := float64(limit.Burst)
+	if s.last.IsZero() {
+		s.tokens, s.last = burst, now
+	} else if dt := now.Sub(s.last).Seconds(); dt > 0 {
+		s.tokens = min(burst, s.tokens+dt*limit.Rate)
+		s.last = now
+	}
+	res := Result{Limit: limit.Burst, Remaining: int64(s.tokens)}
+	if s.tokens >= float64(n) {
+		res.Allowed = true
+	} else {
+		res.RetryAfter = retryAfter(float64(n)-s.tokens, limit.Rate)
+	}
+	return res
+}
+
+func (s *tokenBucketState) take(_ Limit, _ time.Time, n int64) {
+	s.tokens -= float64(n)
+}
+
+func (s *tokenBucketState) idleAt(limit Limit) time.Time {
+	return s.last.Add(retryAfter(float64(limit.Burst)-s.tokens, limit.Rate))
+}
+
+type slidingLogState struct {
+	log []time.Time // 窗口内请求的时间，升序
+}
+
+func (s *slidingLogState) check(limit Limit, now time.Time, n int64) Result {
+	window := limit.window()
+	cut := now.Add(-window)
+	expired := sort.Search(len(s.log), func(i int) bool { return s.log[i].After(cut) })
+	s.log = slices.Delete(s.log, 0, expired)
+
+	count := int64(len(s.log))
+	res := Result{Limit: limit.Burst, Remaining: max(0, limit.Burst-count)}
+	if count+n <= limit.Burst {
+		res.Allowed = true
+		return res
+	}
+	res.RetryAfter = window
+	if n <= limit.Burst {
+		// 需要等到最早的 count+n-Burst 个请求移出窗口
+		res.RetryAfter = s.log[count+n-limit.Burst-1].Add(window).Sub(now)
+	}
+	return res
+}
+
+func (s *slidingLogState) take(_ Limit, now time.Time, n int64) {
+	for range n {
+		s.log = append(s.log, now)
+	}
+}
+
+func (s *slidingLogState) idleAt(limit Limit) time.Time {
+	if len(s.log) == 0 {
+		return time.Unix(0, 1)
+	}
+	return s.log[len(s.log)-1].Add(limit.window())
+}
+
+type slidingWindowState struct {
+	index     int64 // 当前固定窗口的序号，即 now / window
+	prev, cur int64 // 上一个与当前窗口的请求数
+}
+
+func (s *slidingWindowState) check(limit Limit, now time.Time, n int64) Result {
+	window := limit.window()
+	index := now.UnixNano() / int64(window)
+	switch {
+	case index == s.index+1:
+		s.prev, s.cur = s.cur, 0
+	case index > s.index+1:
+		s.prev, s.cur = 0, 0
+	}
+	s.index = max(s.index, index)
+
+	elapsed := float64(now.UnixNano()-index*int64(window)) / float64(window)
+	estimate := float64(s.prev)*(1-elapsed) + float64(s.cur)
+	res := Result{Limit: limit.Burst, Remaining: max(0, int64(float64(limit.Burst)-estimate))}
+	if estimate+float64(n) <= float64(limit.Burst) {
+		res.Allowed = true
+		return res
+	}
+	res.RetryAfter = slidingWindowWait(limit.Burst, s.prev, s.cur, n, elapsed, window)
+	return res
+}
+
+func (s *slidingWindowState) take(_ Limit, _ time.Time, n int64) {
+	s.cur += n
+}
+
+func (s *slidingWindowState) idleAt(limit Limit) time.Time {
+	if s.prev == 0 && s.cur == 0 {
+		return time.Unix(0, 1)
+	}
+	// 两个窗口之后，当前窗口的计数不再计入估算
+	return time.Unix(0, (s.index+2)*int64(limit.window()))
+}
+
+// slidingWindowWait 估算滑动窗口计数的估计值降到允许 n 个请求所需的时间
+func slidingWindowWait(burst, prev, cur, n int64, elapsed float64, window time.Duration) time.Duration {
+	free := float64(burst - cur - n)
+	if free >= 0 && prev > 0 {
+		// 在当前窗口内，上一窗口的权重下降到足够小
+		return time.Duration((1 - free/float64(prev) - elapsed) * float64(window))
+	}
+	if burst < n || cur == 0 {
+		return time.Duration((1 - elapsed) * float64(window))
+	}
+	// 下一个窗口中，当前窗口成为上一窗口
+	need := math.Max(0, 1-float64(burst-n)/float64(cur))
+	return time.Duration((1 - elapsed + need) * float64(window))
+}
+
+type gcraState struct {
+	tat time.Time // 理论到达时间，不早于上次判定的时间
+}
+
+func (s *gcraState) check(limit Limit, now time.Time, n int64) Result {
+	if s.tat.Before(now) {
+		s.tat = now
+	}
+	emission := limit.emission()
+	tolerance := emission * time.Duration(limit.Burst)
+	res := Result{Limit: limit.Burst, Remaining: max(0, int64((tolerance-s.tat.Sub(now))/emission))}
+	if allowAt := s.tat.Add(emission * time.Duration(n)).Add(-tolerance); allowAt.After(now) {
+		res.RetryAfter = allowAt.Sub(now)
+		return res
+	}
+	res.Allowed = true
+	return res
+}
+
+func (s *gcraState) take(limit Limit, _ time.Time, n int64) {
+	s.tat = s.tat.Add(limit.emission() * time.Duration(n))
+}
+
+func (s *gcraState) idleAt(Limit) time.Time {
+	return s.tat
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLocalEviction(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Burst: 5}
+	for _, algo := range algorithms {
+		t.Run(string(algo), func(t *testing.T) {
+			now := base
+			l := newLocal(algo, LocalOptions{MaxKeys: 4 * localShards}, func() time.Time { return now })
+			keys := testutil.ToFloat64(localKeys)
+			capacity := testutil.ToFloat64(localEvictions.WithLabelValues("capacity"))
+			allow := func(key string) Result {
+				results, err := l.Allow(ctx, 1, Request{Key: key, Limit: limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return results[0]
+			}
+
+			// a scan over many keys stays within max_keys
+			for i := range 1000 {
+				allow("ip:" + strconv.Itoa(i))
+			}
+			if n := l.Len(); n > 4*localShards {
+				t.Fatalf("%d keys tracked, max %d", n, 4*localShards)
+			}
+			if got := testutil.ToFloat64(localKeys) - keys; got != float64(l.Len()) {
+				t.Fatalf("keys gauge moved by %v, want %d", got, l.Len())
+			}
+			if got := testutil.ToFloat64(localEvictions.WithLabelValues("capacity")) - capacity; got != float64(1000-l.Len()) {
+				t.Fatalf("capacity evictions = %v, want %d", got, 1000-l.Len())
+			}
+
+			// only keys whose state has returned to that of a new key are swept
+			now = now.Add(2 * limit.window())
+			allow("busy")
+			allow("busy")
+			l.sweep(now)
+			if l.Len() != 1 {
+				t.Fatalf("%d keys left after sweeping idle keys, want the busy one", l.Len())
+			}
+			if res := allow("busy"); res.Remaining != limit.Burst-3 {
+				t.Fatalf("busy key lost its state: %+v", res)
+			}
+			l.sweep(now.Add(2 * time.Second))
+			if l.Len() != 0 {
+				t.Fatalf("%d keys left after they all became idle", l.Len())
+			}
+
+			l.Close()
+			if got := testutil.ToFloat64(localKeys); got != keys {
+				t.Fatalf("keys gauge = %v after close, want %v", got, keys)
+			}
+		})
+	}
+}
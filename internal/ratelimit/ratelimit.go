@@ -0,0 +1,95 @@
+// Package ratelimit 提供限流算法及其存储后端：进程内存储，以及多个网关副本共享的 Redis 存储。
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable 共享存储不可用且配置为 fail-closed 时返回
+var ErrUnavailable = errors.New("rate limit store unavailable")
+
+// Algorithm 限流算法
+type Algorithm string
+
+const (
+	// TokenBucket 令牌桶：以 Rate 的速率补充令牌，桶容量为 Burst
+	TokenBucket Algorithm = "token_bucket"
+	// SlidingWindowLog 滑动窗口日志：记录窗口内每个请求的时间，精确但内存占用与 Burst 成正比
+	SlidingWindowLog Algorithm = "sliding_window_log"
+	// SlidingWindow 滑动窗口计数：按上一个固定窗口的计数加权估算，每个 key 只需两个计数器
+	SlidingWindow Algorithm = "sliding_window"
+	// GCRA 通用信元速率算法：与令牌桶等价，但每个 key 只需保存一个时间戳
+	GCRA Algorithm = "gcra"
+)
+
+// ParseAlgorithm 解析算法名称，空字符串表示令牌桶
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(strings.ToLower(s)); a {
+	case "":
+		return TokenBucket, nil
+	case TokenBucket, SlidingWindowLog, SlidingWindow, GCRA:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown rate limit algorithm %q, expected token_bucket, sliding_window_log, sliding_window or gcra", s)
+}
+
+// Limit 限流参数，各算法含义一致：长期速率为 Rate，瞬时最多放行 Burst 个请求。
+// 滑动窗口算法的窗口长度为 Burst/Rate，即任意窗口内最多 Burst 个请求。
+type Limit struct {
+	Rate  float64 // 每秒请求数
+	Burst int64
+}
+
+// window 滑动窗口算法的窗口长度
+func (l Limit) window() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// emission GCRA 中相邻两个请求的理论间隔
+func (l Limit) emission() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// Request 一次判定中的一条规则：key 及其限流参数。同一个 Limiter 中，相同的 key 应始终使用相同的参数
+type Request struct {
+	Key   string
+	Limit Limit
+}
+
+// Result 一条规则的判定结果
+type Result struct {
+	Allowed    bool          // 该规则是否允许
+	Limit      int64         // 即 Burst
+	Remaining  int64         // 判定后还能立即放行的请求数
+	RetryAfter time.Duration // 被拒绝时，再次请求前需等待的时间
+	Reset      time.Duration // 剩余请求数恢复到 Limit 所需的时间
+}
+
+// Limiter 按 key 做限流判定。reqs 中的规则一起判定：全部允许时每条规则各计入 n 个请求，
+// 任一规则拒绝时都不计入，返回与 reqs 一一对应的结果。
+// 本地存储与 Redis 存储的各算法均实现该接口，调用方需保证 Rate > 0、Burst > 0 且 key 互不相同。
+type Limiter interface {
+	Allow(ctx context.Context, n int64, reqs ...Request) ([]Result, error)
+}
+
+// Allowed 判断是否全部规则都允许
+func Allowed(results []Result) bool {
+	for _, r := range results {
+		if !r.Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// retryAfter 返回补足 missing 个令牌所需的时间
+func retryAfter(missing float64, rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration(missing / rate * float64(time.Second))
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var algorithms = []Algorithm{TokenBucket, SlidingWindowLog, SlidingWindow, GCRA}
+
+// testStore is a limiter together with a clock the test controls.
+type testStore struct {
+	name    string
+	limiter Limiter
+	advance func(time.Duration)
+}
+
+// base is aligned to the fixed windows of the limits used below
+var base = time.Unix(1_700_000_000, 0)
+
+func testStores(t *testing.T, algo Algorithm) []testStore {
+	var now atomic.Int64
+	now.Store(base.UnixNano())
+	local := testStore{
+		name:    "local",
+		limiter: newLocal(algo, LocalOptions{}, func() time.Time { return time.Unix(0, now.Load()) }),
+		advance: func(d time.Duration) { now.Add(int64(d)) },
+	}
+
+	m := miniredis.RunT(t)
+	m.SetTime(base)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr(), PoolSize: 32})
+	t.Cleanup(func() { client.Close() })
+	current := base
+	shared := testStore{
+		name:    "redis",
+		limiter: NewRedis(algo, client, "test:"),
+		advance: func(d time.Duration) {
+			current = current.Add(d)
+			m.SetTime(current)
+		},
+	}
+	return []testStore{local, shared}
+}
+
+// hammer sends requests from several goroutines at once and returns how many were allowed.
+func hammer(t *testing.T, l Limiter, limit Limit, goroutines, each int) int64 {
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				results, err := l.Allow(context.Background(), 1, Request{Key: "k", Limit: limit})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res := results[0]; res.Allowed {
+					allowed.Add(1)
+				} else if res.RetryAfter <= 0 {
+					t.Errorf("rejected without retry-after: %+v", res)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed.Load()
+}
+
+func TestConcurrentBurst(t *testing.T) {
+	limit := Limit{Rate: 100, Burst: 50}
+	for _, algo := range algorithms {
+		for _, s := range testStores(t, algo) {
+			t.Run(string(algo)+"/"+s.name, func(t *testing.T) {
+				if n := hammer(t, s.limiter, limit, 20, 10); n != limit.Burst {
+					t.Fatalf("allowed %d of 200 concurrent requests, want %d", n, limit.Burst)
+				}
+				s.advance(2 * limit.window())
+				if n := hammer(t, s.limiter, limit, 20, 10); n != limit.Burst {
+					t.Fatalf("allowed %d after the limit recovered, want %d", n, limit.Burst)
+				}
+			})
+		}
+	}
+}
+
+func TestSteadyRate(t *testing.T) {
+	limit := Limit{Rate: 100, Burst: 10}
+	const steps, step = 200, 5 * time.Millisecond // one second, offering 800 requests/s
+	for _, algo := range algorithms {
+		for _, s := range testStores(t, algo) {
+			t.Run(string(algo)+"/"+s.name, func(t *testing.T) {
+				var allowed int64
+				for range steps {
+					allowed += hammer(t, s.limiter, limit, 4, 1)
+					s.advance(step)
+				}
+				// bucket algorithms allow the burst on top of the rate, window algorithms
+				// at most burst per window; the sliding window counter is an estimate
+				want := limit.Burst + int64(limit.Rate)
+				low, high := want-1, want
+				switch algo {
+				case SlidingWindowLog:
+					want = int64(limit.Rate)
+					low, high = want, want
+				case SlidingWindow:
+					want = int64(limit.Rate)
+					low, high = want-10, want+5
+				}
+				if allowed < low || allowed > high {
+					t.Fatalf("allowed %d requests in one second, want %d", allowed, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Burst: 5}
+	for _, algo := range algorithms {
+		for _, s := range testStores(t, algo) {
+			t.Run(string(algo)+"/"+s.name, func(t *testing.T) {
+				hammer(t, s.limiter, limit, 1, int(limit.Burst))
+				s.advance(30 * time.Millisecond)
+				results, err := s.limiter.Allow(ctx, 1, Request{Key: "k", Limit: limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				res := results[0]
+				if res.Allowed || res.Remaining != 0 || res.Limit != limit.Burst {
+					t.Fatalf("exhausted limit: %+v, %v", res, err)
+				}
+				// the counter may have to wait for the next window to discount this one
+				if res.RetryAfter <= 0 || res.RetryAfter > 2*limit.window() {
+					t.Fatalf("retry after %v", res.RetryAfter)
+				}
+				// full recovery takes at least as long as the next request
+				if res.Reset < res.RetryAfter || res.Reset > 2*limit.window() {
+					t.Fatalf("reset after %v, retry after %v", res.Reset, res.RetryAfter)
+				}
+				s.advance(res.RetryAfter.Round(time.Millisecond) + time.Millisecond)
+				if results, err := s.limiter.Allow(ctx, 1, Request{Key: "k", Limit: limit}); err != nil || !Allowed(results) {
+					t.Fatalf("after waiting %v: %+v, %v", res.RetryAfter, results, err)
+				}
+			})
+		}
+	}
+}
+
+func TestMultiRule(t *testing.T) {
+	ctx := context.Background()
+	route := Limit{Rate: 100, Burst: 10}
+	perIP := Limit{Rate: 0.001, Burst: 1} // never recovers during the test
+	allow := func(l Limiter, ip int) []Result {
+		results, err := l.Allow(ctx, 1,
+			Request{Key: "route:/api", Limit: route},
+			Request{Key: "ip:" + strconv.Itoa(ip), Limit: perIP})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	for _, algo := range algorithms {
+		for _, s := range testStores(t, algo) {
+			t.Run(string(algo)+"/"+s.name, func(t *testing.T) {
+				// 40 clients race for the 10 requests the route allows
+				var mu sync.Mutex
+				admitted := make(map[int]bool)
+				var wg sync.WaitGroup
+				for ip := range 40 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if Allowed(allow(s.limiter, ip)) {
+							mu.Lock()
+							admitted[ip] = true
+							mu.Unlock()
+						}
+					}()
+				}
+				wg.Wait()
+				if len(admitted) != int(route.Burst) {
+					t.Fatalf("admitted %d clients, want %d", len(admitted), route.Burst)
+				}
+
+				s.advance(time.Second)
+				// rejected by their own rule, without consuming the route's tokens
+				for ip := range admitted {
+					results := allow(s.limiter, ip)
+					if Allowed(results) || !results[0].Allowed || results[1].Allowed || results[0].Remaining != route.Burst {
+						t.Fatalf("client %d again: %+v", ip, results)
+					}
+				}
+				// rejected by the route earlier, so their own token is still there
+				var n int64
+				for ip := range 40 {
+					if !admitted[ip] && n < route.Burst {
+						if results := allow(s.limiter, ip); !Allowed(results) || results[0].Remaining != route.Burst-n-1 {
+							t.Fatalf("client %d: %+v", ip, results)
+						}
+						n++
+					}
+				}
+			})
+		}
+	}
+}
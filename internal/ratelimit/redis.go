@@ -0,0 +1,267 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 各算法的 Lua 脚本定义 load、check、take 三个函数，与 limitScript 中的驱动代码一起在 Redis 中原子地执行：
+// 先判定全部 key，全部允许时才计入请求。时间使用 Redis 服务器时间（微秒），避免各网关副本时钟不一致，
+// 状态在不再影响判定后过期。
+//
+//	load(key, rate, burst, now)          读取状态并推进到 now
+//	check(st, rate, burst, n, now)       返回 allowed, 计入前剩余的请求数, 再次请求前需等待的微秒数
+//	take(key, st, rate, burst, n, now)   计入 n 个请求并保存状态
+//	reset(st, rate, burst, taken, now)   计入 taken 个请求后，剩余请求数恢复到 burst 所需的微秒数
+//
+// 同一次判定的多个 key 在 Redis Cluster 中需位于同一个 slot。
+
+const tokenBucketLua = `
+local function load(key, rate, burst, now)
+  local s = redis.call("HMGET", key, "tokens", "ts")
+  local st = {tokens = tonumber(s[1]), ts = tonumber(s[2])}
+  if st.tokens == nil or st.ts == nil then
+    st.tokens, st.ts = burst, now
+  elseif now > st.ts then
+    st.tokens = math.min(burst, st.tokens + (now - st.ts) * rate / 1000000)
+    st.ts = now
+  end
+  return st
+end
+
+local function check(st, rate, burst, n, now)
+  if st.tokens >= n then
+    return true, st.tokens, 0
+  end
+  return false, st.tokens, math.ceil((n - st.tokens) * 1000000 / rate)
+end
+
+local function take(key, st, rate, burst, n, now)
+  redis.call("HSET", key, "tokens", tostring(st.tokens - n), "ts", st.ts)
+  -- a full bucket carries no state, let it expire once it would have refilled
+  redis.call("PEXPIRE", key, math.ceil(burst * 1000 / rate) + 1000)
+end
+
+local function reset(st, rate, burst, taken, now)
+  return math.ceil((burst - st.tokens + taken) * 1000000 / rate)
+end
+`
+
+const slidingLogLua = `
+local function load(key, rate, burst, now)
+  local window = math.floor(burst * 1000000 / rate)
+  redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+  return {key = key, window = window, count = redis.call("ZCARD", key)}
+end
+
+local function check(st, rate, burst, n, now)
+  if st.count + n <= burst then
+    return true, burst - st.count, 0
+  end
+  local wait = st.window
+  if n <= burst then
+    -- wait until the oldest count+n-burst requests have left the window
+    local i = st.count + n - burst - 1
+    local oldest = redis.call("ZRANGE", st.key, i, i, "WITHSCORES")
+    wait = tonumber(oldest[2]) + st.window - now
+  end
+  return false, math.max(0, burst - st.count), wait
+end
+
+local function take(key, st, rate, burst, n, now)
+  for i = 1, n do
+    redis.call("ZADD", key, now, ARGV[2] .. ":" .. i)
+  end
+  redis.call("PEXPIRE", key, math.ceil(st.window / 1000) + 1000)
+end
+
+local function reset(st, rate, burst, taken, now)
+  if taken > 0 then
+    return st.window
+  end
+  local newest = redis.call("ZRANGE", st.key, -1, -1, "WITHSCORES")
+  if newest[2] == nil then
+    return 0
+  end
+  return math.max(0, tonumber(newest[2]) + st.window - now)
+end
+`
+
+const slidingWindowLua = `
+local function load(key, rate, burst, now)
+  local window = math.floor(burst * 1000000 / rate)
+  local index = math.floor(now / window)
+  local s = redis.call("HMGET", key, "index", "prev", "cur")
+  local st = {window = window, index = tonumber(s[1]) or index, prev = tonumber(s[2]) or 0, cur = tonumber(s[3]) or 0}
+  if index == st.index + 1 then
+    st.prev, st.cur = st.cur, 0
+  elseif index > st.index + 1 then
+    st.prev, st.cur = 0, 0
+  end
+  st.index = math.max(index, st.index)
+  st.elapsed = (now - st.index * window) / window
+  st.estimate = st.prev * (1 - st.elapsed) + st.cur
+  return st
+end
+
+local function check(st, rate, burst, n, now)
+  if st.estimate + n <= burst then
+    return true, burst - st.estimate, 0
+  end
+  local wait
+  local free = burst - st.cur - n
+  if free >= 0 and st.prev > 0 then
+    wait = (1 - free / st.prev - st.elapsed) * st.window
+  elseif burst < n or st.cur == 0 then
+    wait = (1 - st.elapsed) * st.window
+  else
+    -- in the next window the current one becomes the previous
+    wait = (1 - st.elapsed + math.max(0, 1 - (burst - n) / st.cur)) * st.window
+  end
+  return false, math.max(0, burst - st.estimate), math.ceil(wait)
+end
+
+local function take(key, st, rate, burst, n, now)
+  redis.call("HSET", key, "index", string.format("%.0f", st.index), "prev", st.prev, "cur", st.cur + n)
+  redis.call("PEXPIRE", key, math.ceil(2 * st.window / 1000) + 1000)
+end
+
+local function reset(st, rate, burst, taken, now)
+  if st.prev == 0 and st.cur + taken == 0 then
+    return 0
+  end
+  -- two windows later the current count no longer weighs in
+  return (st.index + 2) * st.window - now
+end
+`
+
+const gcraLua = `
+local function load(key, rate, burst, now)
+  local tat = tonumber(redis.call("GET", key)) or now
+  return {tat = math.max(tat, now)}
+end
+
+local function check(st, rate, burst, n, now)
+  local emission = 1000000 / rate
+  local tolerance = emission * burst
+  local remaining = math.max(0, (tolerance - (st.tat - now)) / emission)
+  local allow_at = st.tat + emission * n - tolerance
+  if allow_at > now then
+    return false, remaining, math.ceil(allow_at - now)
+  end
+  return true, remaining, 0
+end
+
+local function take(key, st, rate, burst, n, now)
+  local tat = st.tat + n * 1000000 / rate
+  redis.call("SET", key, string.format("%.0f", tat), "PX", math.ceil((tat - now) / 1000) + 1000)
+end
+
+local function reset(st, rate, burst, taken, now)
+  return math.ceil(st.tat + taken * 1000000 / rate - now)
+end
+`
+
+// limitScript 驱动代码。ARGV 为 n、本次请求的唯一 ID（滑动窗口日志使用），之后每个 key 依次为 rate、burst；
+// 返回每个 key 的 {allowed, 剩余请求数（可为小数字符串）, 等待微秒数, 恢复微秒数}
+const limitScript = `
+local n = tonumber(ARGV[1])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local states, checks = {}, {}
+local ok = true
+for i, key in ipairs(KEYS) do
+  local rate, burst = tonumber(ARGV[1 + 2 * i]), tonumber(ARGV[2 + 2 * i])
+  states[i] = load(key, rate, burst, now)
+  local allowed, remaining, wait = check(states[i], rate, burst, n, now)
+  checks[i] = {allowed, remaining, wait}
+  ok = ok and allowed
+end
+
+local out = {}
+for i, key in ipairs(KEYS) do
+  local rate, burst = tonumber(ARGV[1 + 2 * i]), tonumber(ARGV[2 + 2 * i])
+  local allowed, remaining, wait = checks[i][1], checks[i][2], checks[i][3]
+  local taken = 0
+  if ok then
+    take(key, states[i], rate, burst, n, now)
+    remaining = remaining - n
+    taken = n
+  end
+  out[#out + 1] = allowed and 1 or 0
+  out[#out + 1] = tostring(remaining)
+  out[#out + 1] = wait
+  out[#out + 1] = reset(states[i], rate, burst, taken, now)
+end
+return out
+`
+
+var scripts = map[Algorithm]*redis.Script{
+	TokenBucket:      redis.NewScript(tokenBucketLua + limitScript),
+	SlidingWindowLog: redis.NewScript(slidingLogLua + limitScript),
+	SlidingWindow:    redis.NewScript(slidingWindowLua + limitScript),
+	GCRA:             redis.NewScript(gcraLua + limitScript),
+}
+
+// redisLimiter 多个网关副本共享的限流器，状态保存在 Redis 中
+type redisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+	script *redis.Script
+
+	id  string // 本实例的随机 ID，与 seq 一起组成请求 ID
+	seq atomic.Uint64
+}
+
+// NewRedis 创建 Redis 限流器。key 加上 prefix 与算法名称，
+// 多个网关副本使用相同的 prefix、算法与参数即共享限额。
+func NewRedis(algo Algorithm, client redis.UniversalClient, prefix string) Limiter {
+	script, ok := scripts[algo]
+	if !ok {
+		panic(fmt.Sprintf("ratelimit: unknown algorithm %q", algo))
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return &redisLimiter{client: client, prefix: prefix + string(algo) + ":", script: script, id: hex.EncodeToString(b[:])}
+}
+
+func (r *redisLimiter) Allow(ctx context.Context, n int64, reqs ...Request) ([]Result, error) {
+	keys := make([]string, len(reqs))
+	args := make([]any, 0, 2+2*len(reqs))
+	args = append(args, n, r.id+":"+strconv.FormatUint(r.seq.Add(1), 36))
+	for i, req := range reqs {
+		keys[i] = r.prefix + req.Key
+		args = append(args, strconv.FormatFloat(req.Limit.Rate, 'f', -1, 64), req.Limit.Burst)
+	}
+	vals, err := r.script.Run(ctx, r.client, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != 4*len(reqs) {
+		return nil, fmt.Errorf("unexpected rate limit script result %v", vals)
+	}
+	results := make([]Result, len(reqs))
+	for i, req := range reqs {
+		allowed, _ := vals[4*i].(int64)
+		remaining, _ := strconv.ParseFloat(fmt.Sprint(vals[4*i+1]), 64)
+		wait, _ := vals[4*i+2].(int64)
+		reset, _ := vals[4*i+3].(int64)
+		results[i] = Result{
+			Allowed:    allowed == 1,
+			Limit:      req.Limit.Burst,
+			Remaining:  int64(math.Floor(remaining)),
+			RetryAfter: time.Duration(wait) * time.Microsecond,
+			Reset:      time.Duration(reset) * time.Microsecond,
+		}
+	}
+	return results, nil
+}
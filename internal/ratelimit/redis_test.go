@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisTokenBucket(t *testing.T) {
+	m := miniredis.RunT(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	// two gateway instances share the same bucket
+	newLimiter := func() Limiter {
+		client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedis(TokenBucket, client, "test:")
+	}
+	allow := func(l Limiter, key string) (Result, error) {
+		results, err := l.Allow(ctx, 1, Request{Key: key, Limit: limit})
+		if err != nil {
+			return Result{}, err
+		}
+		return results[0], nil
+	}
+	a, b := newLimiter(), newLimiter()
+	for i, l := range []Limiter{a, b, a} {
+		res, err := allow(l, "ip:1.2.3.4")
+		if err != nil || !res.Allowed || res.Remaining != int64(2-i) {
+			t.Fatalf("request %d: %+v, %v", i, res, err)
+		}
+	}
+	res, err := allow(b, "ip:1.2.3.4")
+	if err != nil || res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("fourth request: %+v, %v", res, err)
+	}
+	if res, _ := allow(a, "ip:5.6.7.8"); !res.Allowed {
+		t.Fatal("other keys have their own bucket")
+	}
+	if ttl := m.TTL("test:token_bucket:ip:1.2.3.4"); ttl <= 0 || ttl > 5*time.Second {
+		t.Fatalf("bucket ttl = %v", ttl)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	m := miniredis.RunT(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 2}
+	client := redis.NewClient(&redis.Options{Addr: m.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	primary := NewRedis(TokenBucket, client, "test:")
+
+	local := NewLocal(TokenBucket, LocalOptions{})
+	t.Cleanup(local.Close)
+	open := NewFallback(primary, local, time.Hour)
+	closed := NewFallback(primary, nil, time.Hour)
+	req := Request{Key: "k", Limit: limit}
+	if results, err := closed.Allow(ctx, 1, req); err != nil || !Allowed(results) {
+		t.Fatalf("closed with redis up: %+v, %v", results, err)
+	}
+
+	m.Close()
+	// fail-open limits per instance
+	for i := range 3 {
+		results, err := open.Allow(ctx, 1, req)
+		if err != nil || Allowed(results) != (i < 2) {
+			t.Fatalf("open request %d: %+v, %v", i, results, err)
+		}
+	}
+	if _, err := closed.Allow(ctx, 1, req); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("closed with redis down: %v", err)
+	}
+}
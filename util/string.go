@@ -35,3 +35,15 @@ func StrOr(v any, def string) string {
 	}
 	return def
 }
+
+func IntOr(v any, def int) int {
+	switch t := v.(type) {
+	case int:
+		return t
+	case int64:
+		return int(t)
+	case float64:
+		return int(t)
+	}
+	return def
+}